@@ -1,9 +1,11 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"image"
+	"os"
 	"time"
 
 	"bib.de/img_proc/internal"
@@ -11,7 +13,7 @@ import (
 
 var (
 	helpFlag   = flag.Bool("h", false, "display flag help")
-	imageFlag  = flag.String("i", "", "path to the image")
+	imageFlag  = flag.String("i", "", "path to the image, - reads from stdin, a frame number pattern like frame_%04d.png reads an image sequence")
 	filterFlag = flag.String("f", "",
 		"type of filter to be applied\n"+
 			"followed by required/optional non-flag arguments\n"+
@@ -20,18 +22,103 @@ var (
 			"\tblur\n"+
 			"\tinvert\n"+
 			"\tcomic        (optional: color step count   (int) default 3)\n"+
-			"\tspot         (required: posX, posY, radius (int, int, float))\n"+
+			"\tspot         (required: posX, posY, radius (int, int, float)) vignette preset fading to white\n"+
+			"\tvignette     (optional: strength, feather (float, float) default 0.6, 0.6, centerX, centerY, radiusX, radiusY (4 floats),\n"+
+			"\t              linear/smoothstep/gaussian default smoothstep, color default black, invert)\n"+
 			"\tedge         (optional: amplification      (int) default 1)\n"+
-			"\theat\n"+
-			"\tgaussianblur (optional: kernel size/radius, sigma (int, float) default 5, 2.0)")
+			"\theat         colormap preset with six bands from black over blue, cyan, green and yellow to red\n"+
+			"\tcolormap     (optional: viridis, magma, inferno, turbo, jet, grayscale, heat or color stops default viridis,\n"+
+			"\t              reverse, steps count (int), legend [height (int)])\n"+
+			"\tgaussianblur (optional: kernel size/radius, sigma (int, float) default 5, 2.0)\n"+
+			"\tresize       (required: width, height (int, int) 0 keeps the aspect ratio, percentage (float%)\n"+
+			"\t              or fit/fill, width, height; optional: nearest, bilinear, bicubic, lanczos3, area default bicubic)\n"+
+			"\tcrop         (required: x, y, width, height (int, int, int, int); optional: background color)\n"+
+			"\tflip         (optional: h, v or hv default h)\n"+
+			"\trot90        (optional: clockwise quarter turns (int) default 1)\n"+
+			"\trotate       (required: clockwise angle in degrees (float); optional: interpolation, background color)\n"+
+			"\taffine       (required: matrix a, b, c, d, e, f (6 floats); optional: interpolation, background color)\n"+
+			"\tperspective  (required: source corners tl, tr, br, bl x1, y1 ... x4, y4 (8 floats), width, height (int, int);\n"+
+			"\t              optional: interpolation default bilinear, background color)\n"+
+			"\tlens         (required: centerX, centerY, k1 (int, int, float); optional: k2 (float), interpolation, background color)\n"+
+			"\tswirl        (required: centerX, centerY, radius, degrees (int, int, float, float); optional: interpolation, background color)\n"+
+			"\tripple       (required: centerX, centerY, amplitude, wavelength (int, int, float, float); optional: interpolation, background color)\n"+
+			"\tfisheye      (required: centerX, centerY, radius (int, int, float); optional: strength (float) default 0.5, interpolation, background color)\n"+
+			"\tpolar        (required: centerX, centerY (int, int); optional: inverse, interpolation, background color)\n"+
+			"\tlevels       (required: blackIn, whiteIn (float, float) 0-255; optional: gamma (float), blackOut, whiteOut (float, float))\n"+
+			"\tbrightness   (required: change in percent (float))\n"+
+			"\tcontrast     (required: change in percent (float))\n"+
+			"\texposure     (required: change in stops (float))\n"+
+			"\tgamma        (required: gamma (float))\n"+
+			"\tcurves       (required: x,y control points 0-255 or a control point file)\n"+
+			"\t              levels and curves apply to single channels when prefixed by r, g, b or rgb\n"+
+			"\thue          (required: rotation in degrees (float); optional: hsl or lch default hsl)\n"+
+			"\tsaturation   (required: change in percent (float >= -100))\n"+
+			"\tvibrance     (required: change in percent (float))\n"+
+			"\tlightness    (required: change in percent (float))\n"+
+			"\tselective    (required: groups of range (reds, yellows, greens, cyans, blues, magentas or hue),\n"+
+			"\t              hue shift, saturation and lightness change (float, float, float))\n"+
+			"\tlut          (required: .cube file or hald clut image; optional: trilinear or tetrahedral default tetrahedral,\n"+
+			"\t              strength in percent (float) default 100)\n"+
+			"\tgrayscale    (optional: 601, 709, average, lightness, r, g or b default 709)\n"+
+			"\tsepia        (optional: strength in percent (float) default 100)\n"+
+			"\tduotone      (required: shadow and highlight colors)\n"+
+			"\ttritone      (required: shadow, midtone and highlight colors)\n"+
+			"\tmixer        (required: 3x4 matrix rr, rg, rb, ro, gr, gg, gb, go, br, bg, bb, bo (12 floats) offsets -255-255,\n"+
+			"\t              3x3 matrix without offsets or one row followed by monochrome)\n"+
+			"\ttemperature  (required: light temperature in kelvin (float) 6500 is neutral; optional: tint (float) -100-100)\n"+
+			"\tautolevels   (optional: clipped share of each histogram end in percent (float) default 0.1)\n"+
+			"\tequalize\n"+
+			"\tclahe        (optional: clip limit (float), grid x, y (int, int) default 2.0, 8, 8)\n"+
+			"\twhitebalance (optional: grayworld, whitepatch or percentile [percentile (float) default 99] default grayworld)\n"+
+			"\tthreshold    (optional: level (float) 0-255 default 128, otsu, mean/gaussian [window (int) offset (float)],\n"+
+			"\t              sauvola/niblack [window (int) k (float)], window default 15)\n"+
+			"\tdither       (required: floyd, atkinson, jarvis, sierra, bayer or bluenoise;\n"+
+			"\t              optional: levels per channel (int) default 2 or palette colors)\n"+
+			"\tquantize     (optional: mediancut, octree or kmeans default mediancut, color count (int) default 16,\n"+
+			"\t              dither method)\n"+
+			"\terode, dilate, open, close, gradient, tophat, blackhat\n"+
+			"\t             (optional: square, cross or disk [radius (int) default 1], rect radiusX, radiusY (int, int)\n"+
+			"\t              or custom rows of 0 and 1 e.g. 010 111 010, default square 1) -I repeats the operation\n"+
+			"\tbilateral    (optional: spatial sigma 0-100, range sigma 0-255 (float, float) default 3, 30)\n"+
+			"\tguided       (optional: radius, smoothing 0-255 (int, float) default 4, 25)\n"+
+			"\tkuwahara     (optional: radius (int) default 4, anisotropic [sharpness (float) default 8])\n"+
+			"\toutline      (optional: gradient threshold 0-255 (float) default 40, color default black)\n"+
+			"filters can be chained with +, e.g. -f resize 50% + blur + invert\n"+
+			"colors are #rrggbb, #rrggbbaa, black, white or transparent, use -- before negative arguments\n"+
+			"temporal filters for image sequences, they can't be iterated with -I:\n"+
+			"\ttmedian      (optional: frame radius (int) default 1)\n"+
+			"\ttavg         (optional: frame radius (int) default 1)\n"+
+			"\tema          (optional: weight of the current frame (float) default 0.3)")
 	iterationFlag      = flag.Int("I", 1, "iteration count of filter")
-	outputFilePathFlag = flag.String("o", "", "file output path")
+	outputFilePathFlag = flag.String("o", "", "file output path, - writes to stdout")
 	coreCountFlag      = flag.Int("c", 0, "number of logical processors used, default max available")
+	bitDepthFlag       = flag.Int("bit-depth", 0, "bit depth per channel of the output image (1 for black and white, 8 or 16), default bit depth of the input image")
+	ditherFlag         = flag.Bool("dither", false, "use dithering when reducing the output to 8 bit or to a gif palette")
+	noAutoOrientFlag   = flag.Bool("no-auto-orient", false, "don't rotate the image according to its exif orientation tag")
+	stripMetadataFlag  = flag.Bool("strip-metadata", false, "don't copy exif, xmp and text metadata to the output image")
+	colorSpaceFlag     = flag.String("colorspace", "srgb", "working color space images are converted to (srgb, displayp3, adobergb or none), the lab, lch, white balance and temperature filters convert with it, none assumes srgb")
+	formatFlag         = flag.String("format", "", "output image format (png, jpeg or gif), default derived from the output file extension, png for stdout")
+	maxPixelsFlag      = flag.Int64("max-pixels", internal.DefaultImageLimits.MaxPixels, "maximum pixel count of the input and the resized images, 0 disables the limit")
+	maxDimensionFlag   = flag.Int("max-dimension", internal.DefaultImageLimits.MaxDimension, "maximum width and height of the input and the resized images, 0 disables the limit")
+	seqStartFlag       = flag.Int("seq-start", -1, "first frame number of an image sequence, default 0 or 1 whichever exists")
+	globalPaletteFlag  = flag.Bool("global-palette", false, "use one palette for all frames of an animated gif instead of one per frame")
+	quantizerFlag      = flag.String("quantizer", internal.QUANTIZER_MEDIAN_CUT, "palette quantizer of gif output (mediancut, octree or kmeans)")
+	exportCubeFlag     = flag.String("export-cube", "", "writes the per-pixel filters of -f as a 3d .cube lut to this path instead of filtering an image, - writes to stdout")
+	cubeSizeFlag       = flag.Int("cube-size", internal.CUBE_DEFAULT_SIZE, "grid size of the lut written by -export-cube")
+	maxMemoryFlag      = flag.Int64("max-memory", internal.DefaultImageLimits.MaxMemory>>20, "maximum estimated memory in MiB used for the image buffers, 0 disables the limit")
 )
 
 func main() {
-	fmt.Println("Image Processing Collection by Patrick Protte")
-	fmt.Println()
+	fmt.Fprintln(os.Stderr, "Image Processing Collection by Patrick Protte")
+	fmt.Fprintln(os.Stderr)
+
+	if len(os.Args) > 1 && os.Args[1] == PALETTE_COMMAND {
+		if err := runPaletteCommand(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
 
 	flag.Parse()
 	args := flag.Args()
@@ -41,72 +128,266 @@ func main() {
 		return
 	}
 
-	if *imageFlag == "" {
-		fmt.Println("please enter a .png file path via -i flag.\ncheck help -h for more information")
+	if *exportCubeFlag != "" {
+		if err := exportCube(args); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
 		return
 	}
 
+	if *imageFlag == "" {
+		fmt.Fprintln(os.Stderr, "please enter an image file path via -i flag, - reads from stdin.\ncheck help -h for more information")
+		os.Exit(1)
+	}
+
 	if *filterFlag == "" {
-		fmt.Println("please enter filter via -f flag.\ncheck help -h for more information")
-		return
+		fmt.Fprintln(os.Stderr, "please enter filter via -f flag.\ncheck help -h for more information")
+		os.Exit(1)
+	}
+
+	if _, err := internal.ResolveOutputFormat(*outputFilePathFlag, *formatFlag); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if err := internal.ValidateBitDepth(*bitDepthFlag); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
 
+	if err := internal.ValidateQuantizer(*quantizerFlag); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	workingSpace, err := workingColorSpace()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	limits := internal.ImageLimits{MaxPixels: *maxPixelsFlag, MaxDimension: *maxDimensionFlag, MaxMemory: *maxMemoryFlag << 20}
+	readOptions := internal.ReadOptions{AutoOrient: !*noAutoOrientFlag, WorkingSpace: workingSpace, Limits: limits}
+	writeOptions := internal.WriteOptions{Format: *formatFlag, BitDepth: *bitDepthFlag, Dither: *ditherFlag, GlobalPalette: *globalPaletteFlag, Quantizer: *quantizerFlag}
+
 	var programStart = time.Now()
 	var start = programStart
 
-	fmt.Println("reading image", *imageFlag)
+	if internal.IsSequencePattern(*imageFlag) {
+		if err := processSequence(args, readOptions, writeOptions); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
 
-	img, err := internal.ReadImage(*imageFlag)
-	if err != nil {
-		fmt.Println(err)
+		fmt.Fprintf(os.Stderr, "entire process took %d ms", time.Now().Sub(programStart).Milliseconds())
 		return
 	}
 
-	fmt.Println("image read")
-	fmt.Printf("reading process took %d ms\n\n", time.Now().Sub(start).Milliseconds())
+	fmt.Fprintln(os.Stderr, "reading image", *imageFlag)
 
-	start = time.Now()
-	fmt.Println("starting filter process")
-
-	var filterEngine internal.ImageFilterEngineInterface
-
-	switch _img := img.(type) {
-	case *image.RGBA64:
-		tmpImg := image.NewRGBA64(img.Bounds())
-		fe := internal.NewImageFilterEngine(*imageFlag, *outputFilePathFlag, _img, tmpImg, *coreCountFlag)
-		filterEngine = fe
-	case *image.RGBA:
-		tmpImg := image.NewRGBA(img.Bounds())
-		fe := internal.NewImageFilterEngine(*imageFlag, *outputFilePathFlag, _img, tmpImg, *coreCountFlag)
-		filterEngine = fe
-	default:
-		fmt.Println("unsupported image type")
-		return
+	data, err := internal.ReadInputFile(*imageFlag)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	var img image.Image
+	anim, metadata, err := internal.DecodeAnimation(data, readOptions)
+	if errors.Is(err, internal.ErrNotAnimated) {
+		img, metadata, err = internal.DecodeImage(data, readOptions)
 	}
 
+	if errors.Is(err, internal.ErrImageLimitExceeded) {
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, "the limits can be raised via -max-pixels, -max-dimension and -max-memory")
+		os.Exit(1)
+	} else if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	fmt.Fprintln(os.Stderr, "image read")
+	fmt.Fprintf(os.Stderr, "reading process took %d ms\n\n", time.Now().Sub(start).Milliseconds())
+
+	if *stripMetadataFlag {
+		// the icc profile is kept, the pixels would be displayed with wrong colors otherwise
+		metadata = &internal.ImageMetadata{Icc: metadata.Icc}
+	}
+
+	writeOptions.Metadata = metadata
+
+	if anim != nil {
+		err = processAnimation(anim, args, readOptions, writeOptions)
+	} else {
+		err = processImage(img, args, readOptions, writeOptions)
+	}
+
+	if errors.Is(err, internal.ErrImageLimitExceeded) {
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, "the limits can be raised via -max-pixels, -max-dimension and -max-memory")
+		os.Exit(1)
+	} else if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	fmt.Fprintf(os.Stderr, "entire process took %d ms", time.Now().Sub(programStart).Milliseconds())
+}
+
+func processImage(img image.Image, args []string, readOptions internal.ReadOptions, writeOptions internal.WriteOptions) error {
+	if *bitDepthFlag == internal.BIT_DEPTH_16 {
+		img = internal.ToRGBA64(img)
+	}
+
+	start := time.Now()
+	fmt.Fprintln(os.Stderr, "starting filter process")
+
+	filterEngine, err := internal.NewImageFilterEngineFromImage(*imageFlag, *outputFilePathFlag, img, *coreCountFlag)
+	if err != nil {
+		return err
+	}
+
+	filterEngine.SetWriteOptions(writeOptions)
+	filterEngine.SetLimits(readOptions.Limits)
+	filterEngine.SetWorkingSpace(readOptions.WorkingSpace)
+
 	if err := filterEngine.SetFilter(*filterFlag, args); err != nil {
-		fmt.Println(err)
-		return
+		return err
 	}
 
 	if err := filterEngine.Run(*iterationFlag); err != nil {
-		fmt.Println(err)
-		return
+		return err
 	}
 
-	fmt.Println("filter finished")
-	fmt.Printf("filter process took %d ms\n\n", time.Now().Sub(start).Milliseconds())
+	fmt.Fprintln(os.Stderr, "filter finished")
+	fmt.Fprintf(os.Stderr, "filter process took %d ms\n\n", time.Now().Sub(start).Milliseconds())
 
 	start = time.Now()
-	fmt.Println("writing file")
+	fmt.Fprintln(os.Stderr, "writing file")
 
 	if filePath, err := filterEngine.WriteOutputFile(); err != nil {
-		fmt.Println(err)
-		return
+		return err
 	} else {
-		fmt.Println("wrote file to: " + filePath)
+		fmt.Fprintln(os.Stderr, "wrote file to: "+filePath)
+	}
+
+	fmt.Fprintf(os.Stderr, "writing process took %d ms\n\n", time.Now().Sub(start).Milliseconds())
+	return nil
+}
+
+func processAnimation(anim *internal.Animation, args []string, readOptions internal.ReadOptions, writeOptions internal.WriteOptions) error {
+	start := time.Now()
+	fmt.Fprintln(os.Stderr, "starting filter process for", len(anim.Frames), "frames")
+
+	for i, frame := range anim.Frames {
+		fmt.Fprintf(os.Stderr, "frame %d / %d\n", i+1, len(anim.Frames))
+
+		if *bitDepthFlag == internal.BIT_DEPTH_16 {
+			frame.Image = internal.ToRGBA64(frame.Image)
+		}
+
+		filterEngine, err := internal.NewImageFilterEngineFromImage(*imageFlag, *outputFilePathFlag, frame.Image, *coreCountFlag)
+		if err != nil {
+			return err
+		}
+
+		filterEngine.SetLimits(readOptions.Limits)
+		filterEngine.SetWorkingSpace(readOptions.WorkingSpace)
+
+		if err := filterEngine.SetFilter(*filterFlag, args); err != nil {
+			return err
+		}
+
+		if err := filterEngine.Run(*iterationFlag); err != nil {
+			return err
+		}
+
+		anim.Frames[i].Image = *filterEngine.GetOutput()
+	}
+
+	fmt.Fprintln(os.Stderr, "filter finished")
+	fmt.Fprintf(os.Stderr, "filter process took %d ms\n\n", time.Now().Sub(start).Milliseconds())
+
+	// animations keep their container format unless another one is requested
+	if writeOptions.Format == "" && (*outputFilePathFlag == "" || *outputFilePathFlag == internal.STDIO_PATH) {
+		writeOptions.Format = anim.Format
+	}
+
+	outputFilePath := *outputFilePathFlag
+	if outputFilePath == "" {
+		outputFilePath = internal.DefaultOutputFilePath(*imageFlag, *filterFlag, writeOptions.Format)
+	}
+
+	start = time.Now()
+	fmt.Fprintln(os.Stderr, "writing file")
+
+	if filePath, err := internal.WriteAnimation(outputFilePath, anim, writeOptions); err != nil {
+		return err
+	} else {
+		fmt.Fprintln(os.Stderr, "wrote file to: "+filePath)
+	}
+
+	fmt.Fprintf(os.Stderr, "writing process took %d ms\n\n", time.Now().Sub(start).Milliseconds())
+	return nil
+}
+
+func processSequence(args []string, readOptions internal.ReadOptions, writeOptions internal.WriteOptions) error {
+	seq, err := internal.OpenImageSequence(*imageFlag, *seqStartFlag)
+	if err != nil {
+		return err
+	}
+
+	outputPattern := *outputFilePathFlag
+	if outputPattern == "" {
+		outputPattern = internal.DefaultOutputFilePath(*imageFlag, *filterFlag, writeOptions.Format)
+	}
+
+	start := time.Now()
+	fmt.Fprintln(os.Stderr, "starting filter process for", seq.Count, "frames of", *imageFlag)
+
+	if err := internal.RunImageSequence(seq, outputPattern, *filterFlag, args, *iterationFlag, *coreCountFlag, *stripMetadataFlag, readOptions, writeOptions); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(os.Stderr, "wrote frames to: "+outputPattern)
+	fmt.Fprintf(os.Stderr, "filter process took %d ms\n\n", time.Now().Sub(start).Milliseconds())
+	return nil
+}
+
+// returns the profile of the -colorspace flag, nil for none
+func workingColorSpace() (*internal.ColorProfile, error) {
+	if *colorSpaceFlag == "none" {
+		return nil, nil
+	}
+	return internal.GetColorProfile(*colorSpaceFlag)
+}
+
+// writes the per-pixel filters of the pipeline as a .cube lut
+func exportCube(args []string) error {
+	if *filterFlag == "" {
+		return errors.New("please enter the filters to export via -f flag")
+	}
+
+	workingSpace, err := workingColorSpace()
+	if err != nil {
+		return err
+	}
+
+	out := os.Stdout
+	if *exportCubeFlag != internal.STDIO_PATH {
+		file, err := os.Create(*exportCubeFlag)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+
+	if err := internal.ExportCubeLUT(out, *filterFlag, args, *cubeSizeFlag, workingSpace); err != nil {
+		return err
 	}
 
-	fmt.Printf("writing process took %d ms\n\n", time.Now().Sub(start).Milliseconds())
-	fmt.Printf("entire process took %d ms", time.Now().Sub(programStart).Milliseconds())
+	fmt.Fprintln(os.Stderr, "wrote lut to: "+*exportCubeFlag)
+	return nil
 }
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"os"
+
+	"bib.de/img_proc/internal"
+)
+
+const (
+	PALETTE_COMMAND     = "palette"
+	PALETTE_FORMAT_HEX  = "hex"
+	PALETTE_FORMAT_JSON = "json"
+)
+
+type paletteJsonEntry struct {
+	Color      string  `json:"color"`
+	Proportion float64 `json:"proportion"`
+}
+
+// prints the dominant colors of the image with the share of the pixels closest to them
+func runPaletteCommand(args []string) error {
+	flags := flag.NewFlagSet(PALETTE_COMMAND, flag.ContinueOnError)
+	imagePath := flags.String("i", "", "path to the image, - reads from stdin, all frames of an animation are combined")
+	size := flags.Int("n", 8, "number of colors")
+	quantizer := flags.String("quantizer", internal.QUANTIZER_MEDIAN_CUT, "mediancut, octree or kmeans")
+	format := flags.String("format", PALETTE_FORMAT_HEX, "output format (hex or json)")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *imagePath == "" {
+		return errors.New("please enter an image file path via -i flag, - reads from stdin")
+	}
+	if *size < 1 {
+		return errors.New("number of colors needs to be at least 1")
+	}
+	if err := internal.ValidateQuantizer(*quantizer); err != nil {
+		return err
+	}
+	if *format != PALETTE_FORMAT_HEX && *format != PALETTE_FORMAT_JSON {
+		return errors.New("palette format has to be hex or json")
+	}
+
+	srgb, err := internal.GetColorProfile("srgb")
+	if err != nil {
+		return err
+	}
+	readOptions := internal.ReadOptions{AutoOrient: true, WorkingSpace: srgb, Limits: internal.DefaultImageLimits}
+
+	data, err := internal.ReadInputFile(*imagePath)
+	if err != nil {
+		return err
+	}
+
+	var imgs []image.Image
+	if anim, _, err := internal.DecodeAnimation(data, readOptions); err == nil {
+		for _, frame := range anim.Frames {
+			imgs = append(imgs, frame.Image)
+		}
+	} else if errors.Is(err, internal.ErrNotAnimated) {
+		img, _, err := internal.DecodeImage(data, readOptions)
+		if err != nil {
+			return err
+		}
+		imgs = append(imgs, img)
+	} else {
+		return err
+	}
+
+	entries := internal.ExtractPalette(imgs, *size, *quantizer)
+
+	if *format == PALETTE_FORMAT_JSON {
+		out := make([]paletteJsonEntry, len(entries))
+		for i, entry := range entries {
+			out[i] = paletteJsonEntry{entry.Hex(), entry.Proportion}
+		}
+
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(out)
+	}
+
+	for _, entry := range entries {
+		fmt.Printf("%s %6.2f%%\n", entry.Hex(), entry.Proportion*100)
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package internal
+
+import (
+	"errors"
+	"image"
+	"image/draw"
+	"strconv"
+)
+
+const (
+	AUTO_LEVELS_BINS = 1024
+)
+
+// stretches every channel so that clip percent of the pixels at each end of its histogram turn black and white
+type autoLevelsParams struct {
+	clip float64
+}
+
+type AutoLevelsRGBA64Filter struct {
+	autoLevelsParams
+	tone *ToneRGBA64Filter
+}
+
+type AutoLevelsRGBAFilter struct {
+	autoLevelsParams
+	tone *ToneRGBAFilter
+}
+
+func parseAutoLevelsParams(args []string) (autoLevelsParams, error) {
+	params := autoLevelsParams{0.1}
+
+	if len(args) >= 1 {
+		clip, err := strconv.ParseFloat(args[0], 64)
+		if err != nil || clip < 0 || clip >= 50 {
+			return params, errors.New("first non-flag argument needs to be the clipped share of each histogram end in percent (float between 0 and 50)")
+		}
+		params.clip = clip
+	}
+
+	return params, nil
+}
+
+// counts the non premultiplied channel values of the visible pixels in the rows startY to endY
+func (params *autoLevelsParams) analyze(img draw.RGBA64Image, startY, endY int) interface{} {
+	bnds := img.Bounds()
+	var hists [3][]float64
+	for ch := range hists {
+		hists[ch] = make([]float64, AUTO_LEVELS_BINS)
+	}
+
+	for y := startY; y < endY; y++ {
+		for x := bnds.Min.X; x < bnds.Max.X; x++ {
+			r, g, b, a := unpremultiply(img.RGBA64At(x, y))
+			if a == 0 {
+				continue
+			}
+			for ch, v := range [3]float64{r, g, b} {
+				hists[ch][luminanceBin(v, AUTO_LEVELS_BINS)]++
+			}
+		}
+	}
+
+	return hists
+}
+
+func (params *autoLevelsParams) Merge(a, b interface{}) interface{} {
+	histsA, histsB := a.([3][]float64), b.([3][]float64)
+	for ch := range histsA {
+		addHistograms(histsA[ch], histsB[ch])
+	}
+	return histsA
+}
+
+// returns the tone function stretching the clipped histogram ranges to the full value range
+func (params *autoLevelsParams) toneFunc(stats interface{}) ToneFunc {
+	hists := stats.([3][]float64)
+	var low, high [3]float64
+
+	for ch, hist := range hists {
+		var total float64
+		for _, count := range hist {
+			total += count
+		}
+
+		limit := total * params.clip / 100
+		lo, hi := 0, len(hist)-1
+		for acc := 0.0; lo < hi && acc+hist[lo] <= limit; lo++ {
+			acc += hist[lo]
+		}
+		for acc := 0.0; hi > lo && acc+hist[hi] <= limit; hi-- {
+			acc += hist[hi]
+		}
+
+		low[ch], high[ch] = float64(lo)/(AUTO_LEVELS_BINS-1), float64(hi)/(AUTO_LEVELS_BINS-1)
+	}
+
+	return func(ch int, v float64) float64 {
+		if high[ch] <= low[ch] {
+			return v
+		}
+		return (v - low[ch]) / (high[ch] - low[ch])
+	}
+}
+
+func (filter *AutoLevelsRGBA64Filter) Analyze(img *image.RGBA64, startY, endY int) interface{} {
+	return filter.analyze(img, startY, endY)
+}
+
+func (filter *AutoLevelsRGBAFilter) Analyze(img *image.RGBA, startY, endY int) interface{} {
+	return filter.analyze(img, startY, endY)
+}
+
+func (filter *AutoLevelsRGBA64Filter) SetStats(stats interface{}) {
+	filter.tone = NewToneRGBA64Filter(filter.toneFunc(stats))
+}
+
+func (filter *AutoLevelsRGBAFilter) SetStats(stats interface{}) {
+	filter.tone = NewToneRGBAFilter(filter.toneFunc(stats))
+}
+
+func (filter *AutoLevelsRGBA64Filter) Apply(img, filteredImg *image.RGBA64, startY, endY int, prgrsCh chan int) {
+	filter.tone.Apply(img, filteredImg, startY, endY, prgrsCh)
+}
+
+func (filter *AutoLevelsRGBAFilter) Apply(img, filteredImg *image.RGBA, startY, endY int, prgrsCh chan int) {
+	filter.tone.Apply(img, filteredImg, startY, endY, prgrsCh)
+}
@@ -0,0 +1,123 @@
+package internal
+
+import (
+	"errors"
+	"image"
+	"image/draw"
+	"math"
+	"strconv"
+)
+
+const (
+	// entries of the range weight table over the squared color distance between 0 and 3
+	BILATERAL_RANGE_STEPS = 4096
+	// bounds the spatial weight table and the neighborhood, which already holds 401 x 401 pixels
+	BILATERAL_MAX_SIGMA = 100.0
+)
+
+// averages the pixels within two spatial sigmas weighted by their distance and by the distance
+// of their color to the color of the center, so edges with a larger color step than the range sigma stay sharp
+type bilateralParams struct {
+	radius      int
+	spatial     []float64
+	rangeWeight []float64
+}
+
+type BilateralRGBA64Filter struct {
+	bilateralParams
+}
+
+type BilateralRGBAFilter struct {
+	bilateralParams
+}
+
+// parses [spatial sigma in pixels [range sigma between 0 and 255]], 3 and 30 by default
+func parseBilateralParams(args []string) (bilateralParams, error) {
+	vals := []float64{3, 30}
+	if len(args) > 2 {
+		return bilateralParams{}, errors.New("filter needs the spatial sigma in pixels and the range sigma between 0 and 255 (float, float) as optional non-flag arguments")
+	}
+
+	parsed, err := parseFloatArgs(args)
+	if err != nil {
+		return bilateralParams{}, errors.New("filter needs the spatial sigma in pixels and the range sigma between 0 and 255 (float, float) as optional non-flag arguments")
+	}
+	copy(vals, parsed)
+	sigmaS, sigmaR := vals[0], vals[1]/0xff
+	if math.IsNaN(sigmaS) || math.IsNaN(sigmaR) || math.IsInf(sigmaR, 0) || sigmaS <= 0 || sigmaR <= 0 {
+		return bilateralParams{}, errors.New("spatial and range sigma need to be finite and greater than 0")
+	}
+	if sigmaS > BILATERAL_MAX_SIGMA {
+		return bilateralParams{}, errors.New("spatial sigma needs to be at most " + strconv.FormatFloat(BILATERAL_MAX_SIGMA, 'f', -1, 64))
+	}
+
+	params := bilateralParams{radius: int(math.Ceil(2 * sigmaS))}
+	size := 2*params.radius + 1
+	params.spatial = make([]float64, size*size)
+	for y := range size {
+		for x := range size {
+			dx, dy := float64(x-params.radius), float64(y-params.radius)
+			params.spatial[y*size+x] = math.Exp(-(dx*dx + dy*dy) / (2 * sigmaS * sigmaS))
+		}
+	}
+
+	params.rangeWeight = make([]float64, BILATERAL_RANGE_STEPS+1)
+	for i := range params.rangeWeight {
+		d2 := float64(i) * 3 / BILATERAL_RANGE_STEPS
+		params.rangeWeight[i] = math.Exp(-d2 / (2 * sigmaR * sigmaR))
+	}
+
+	return params, nil
+}
+
+func (params *bilateralParams) apply(img, filteredImg draw.RGBA64Image, startY, endY int, prgrsCh chan int) {
+	band := loadImageBand(img, startY, endY, params.radius)
+	w, h := band.bnds.Dx(), band.bnds.Dy()
+	size := 2*params.radius + 1
+
+	// the range distance compares the unpremultiplied colors
+	colors := make([][3]float64, w*h)
+	for i := range colors {
+		if a := band.pix[i*4+3]; a > 0 {
+			colors[i] = [3]float64{band.pix[i*4] / a, band.pix[i*4+1] / a, band.pix[i*4+2] / a}
+		}
+	}
+
+	progress := newRowProgressReporter(startY, endY, prgrsCh)
+	for y := startY - band.bnds.Min.Y; y < endY-band.bnds.Min.Y; y++ {
+		for x := range w {
+			center := colors[y*w+x]
+			var c [4]float64
+			var weights float64
+
+			for ky := max(0, y-params.radius); ky <= min(h-1, y+params.radius); ky++ {
+				for kx := max(0, x-params.radius); kx <= min(w-1, x+params.radius); kx++ {
+					other := colors[ky*w+kx]
+					dr, dg, db := other[0]-center[0], other[1]-center[1], other[2]-center[2]
+					weight := params.spatial[(ky-y+params.radius)*size+kx-x+params.radius] *
+						params.rangeWeight[min(int((dr*dr+dg*dg+db*db)*BILATERAL_RANGE_STEPS/3), BILATERAL_RANGE_STEPS)]
+
+					i := band.offset(kx, ky)
+					for ch := range c {
+						c[ch] += band.pix[i+ch] * weight
+					}
+					weights += weight
+				}
+			}
+
+			for ch := range c {
+				c[ch] /= weights
+			}
+			band.set(filteredImg, x, y, c)
+		}
+		progress.RowDone()
+	}
+}
+
+func (filter *BilateralRGBA64Filter) Apply(img, filteredImg *image.RGBA64, startY, endY int, prgrsCh chan int) {
+	filter.apply(img, filteredImg, startY, endY, prgrsCh)
+}
+
+func (filter *BilateralRGBAFilter) Apply(img, filteredImg *image.RGBA, startY, endY int, prgrsCh chan int) {
+	filter.apply(img, filteredImg, startY, endY, prgrsCh)
+}
@@ -0,0 +1,171 @@
+package internal
+
+import (
+	"errors"
+	"math"
+	"strconv"
+)
+
+const (
+	HUE_SPACE_HSL = "hsl"
+	HUE_SPACE_LCH = "lch"
+
+	// hue distance at which the selective color adjustment of a range fades out
+	SELECTIVE_COLOR_WIDTH = 60.0
+)
+
+var selectiveColorRanges = map[string]float64{
+	"reds":     0,
+	"yellows":  60,
+	"greens":   120,
+	"cyans":    180,
+	"blues":    240,
+	"magentas": 300,
+}
+
+// rotates the hue by degrees, in hsl by default or in lch, which keeps the perceived lightness
+func parseHue(args []string) (SpaceColorFunc, error) {
+	degrees, err := parseSingleToneArg(args, "filter needs the hue rotation in degrees (float) as non-flag argument, optionally followed by hsl or lch")
+	if err != nil {
+		return nil, err
+	}
+
+	space := HUE_SPACE_HSL
+	if len(args) >= 2 {
+		space = args[1]
+	}
+
+	switch space {
+	case HUE_SPACE_HSL:
+		return func(conv *colorConverter) ColorFunc {
+			return func(r, g, b float64) (float64, float64, float64) {
+				h, s, l := rgbToHsl(r, g, b)
+				return hslToRgb(h+degrees, s, l)
+			}
+		}, nil
+	case HUE_SPACE_LCH:
+		return func(conv *colorConverter) ColorFunc {
+			return func(r, g, b float64) (float64, float64, float64) {
+				l, c, h := labToLch(conv.rgbToLab(r, g, b))
+				return conv.labToRgb(lchToLab(l, c, h+degrees))
+			}
+		}, nil
+	default:
+		return nil, errors.New("second non-flag argument needs to be hsl or lch")
+	}
+}
+
+// scales cb and cr of the pixel, which changes the saturation without changing the luminance
+func scaleChroma(r, g, b, factor float64) (float64, float64, float64) {
+	y, cb, cr := rgbToYCbCr(r, g, b)
+	return yCbCrToRgb(y, cb*factor, cr*factor)
+}
+
+// scales the chroma by 1 + amount/100, -100 turns the image gray
+func parseSaturation(args []string) (ColorFunc, error) {
+	amount, err := parseSingleToneArg(args, "filter needs the saturation change in percent (float >= -100) as non-flag argument")
+	if err != nil {
+		return nil, err
+	}
+	if amount < -100 {
+		return nil, errors.New("saturation change needs to be at least -100")
+	}
+
+	return func(r, g, b float64) (float64, float64, float64) {
+		return scaleChroma(r, g, b, 1+amount/100)
+	}, nil
+}
+
+// like saturation, but the change fades out with the hsv saturation, so muted colors change more than saturated ones
+func parseVibrance(args []string) (ColorFunc, error) {
+	amount, err := parseSingleToneArg(args, "filter needs the vibrance change in percent (float between -100 and 100) as non-flag argument")
+	if err != nil {
+		return nil, err
+	}
+	if amount < -100 || amount > 100 {
+		return nil, errors.New("vibrance change needs to be between -100 and 100")
+	}
+
+	return func(r, g, b float64) (float64, float64, float64) {
+		_, s, _ := rgbToHsv(r, g, b)
+		return scaleChroma(r, g, b, 1+amount/100*(1-s))
+	}, nil
+}
+
+// shifts the lab lightness by amount percent, which keeps hue and chroma as far as the gamut allows
+func parseLightness(args []string) (SpaceColorFunc, error) {
+	amount, err := parseSingleToneArg(args, "filter needs the lightness change in percent (float between -100 and 100) as non-flag argument")
+	if err != nil {
+		return nil, err
+	}
+	if amount < -100 || amount > 100 {
+		return nil, errors.New("lightness change needs to be between -100 and 100")
+	}
+
+	return func(conv *colorConverter) ColorFunc {
+		return func(r, g, b float64) (float64, float64, float64) {
+			l, a, bb := conv.rgbToLab(r, g, b)
+			return conv.labToRgb(math.Max(0, math.Min(100, l+amount)), a, bb)
+		}
+	}, nil
+}
+
+type selectiveColorRange struct {
+	center, hueShift, saturation, lightness float64
+}
+
+// parses range, hue shift, saturation and lightness change groups, a range is reds, yellows, greens,
+// cyans, blues, magentas or its center hue in degrees, every range fades out over 60 degrees
+func parseSelectiveColor(args []string) (ColorFunc, error) {
+	if len(args) == 0 || len(args)%4 != 0 {
+		return nil, errors.New("filter needs groups of a hue range (reds, yellows, greens, cyans, blues, magentas or hue in degrees), hue shift in degrees, saturation and lightness change in percent as non-flag arguments")
+	}
+
+	ranges := make([]selectiveColorRange, 0, len(args)/4)
+	for i := 0; i < len(args); i += 4 {
+		center, found := selectiveColorRanges[args[i]]
+		if !found {
+			var err error
+			if center, err = strconv.ParseFloat(args[i], 64); err != nil {
+				return nil, errors.New("hue range needs to be reds, yellows, greens, cyans, blues, magentas or a hue in degrees (float), got " + args[i])
+			}
+		}
+
+		vals, err := parseFloatArgs(args[i+1 : i+4])
+		if err != nil {
+			return nil, errors.New("hue shift, saturation and lightness change need to be floats")
+		}
+		if vals[1] < -100 || vals[2] < -100 || vals[2] > 100 {
+			return nil, errors.New("saturation change needs to be at least -100, lightness change between -100 and 100")
+		}
+		ranges = append(ranges, selectiveColorRange{center, vals[0], vals[1] / 100, vals[2] / 100})
+	}
+
+	return func(r, g, b float64) (float64, float64, float64) {
+		h, s, l := rgbToHsl(r, g, b)
+		_, c, _, _ := hueChroma(r, g, b)
+
+		var hueShift float64
+		saturation, lightness := 1.0, 0.0
+		for _, rng := range ranges {
+			// grays have no hue, so the weight fades out with the chroma
+			dist := math.Abs(math.Mod(math.Mod(h-rng.center, 360)+540, 360) - 180)
+			if dist >= SELECTIVE_COLOR_WIDTH {
+				continue
+			}
+			weight := c * (1 + math.Cos(math.Pi*dist/SELECTIVE_COLOR_WIDTH)) / 2
+
+			hueShift += rng.hueShift * weight
+			saturation *= 1 + rng.saturation*weight
+			lightness += rng.lightness * weight
+		}
+
+		lightness = math.Max(-1, math.Min(1, lightness))
+		if lightness > 0 {
+			l += (1 - l) * lightness
+		} else {
+			l += l * lightness
+		}
+		return hslToRgb(h+hueShift, clampUnit(s*saturation), clampUnit(l))
+	}, nil
+}
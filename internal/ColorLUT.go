@@ -0,0 +1,336 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	LUT_TRILINEAR   = "trilinear"
+	LUT_TETRAHEDRAL = "tetrahedral"
+
+	CUBE_DEFAULT_SIZE = 33
+	CUBE_MAX_SIZE     = 256
+)
+
+// color lookup table of a .cube file or a hald clut, the optional 1d table is applied before the 3d table
+type ColorLUT struct {
+	// 1d table with one entry per input value
+	shaper               [][3]float64
+	shaperMin, shaperMax [3]float64
+	// 3d table with size^3 entries, red changes fastest
+	table              [][3]float64
+	size               int
+	tableMin, tableMax [3]float64
+	tetrahedral        bool
+}
+
+// loads a .cube file or a hald clut image, the interpolation is trilinear or tetrahedral
+func LoadColorLUT(path, interpolation string) (*ColorLUT, error) {
+	if interpolation != LUT_TRILINEAR && interpolation != LUT_TETRAHEDRAL {
+		return nil, errors.New("lut interpolation needs to be trilinear or tetrahedral")
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var lut *ColorLUT
+	if strings.HasSuffix(strings.ToLower(path), ".cube") {
+		lut, err = parseCube(data)
+	} else {
+		lut, err = decodeHaldCLUT(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	lut.tetrahedral = interpolation == LUT_TETRAHEDRAL
+	return lut, nil
+}
+
+func parseCubeTriple(fields []string) ([3]float64, error) {
+	var v [3]float64
+	if len(fields) != 3 {
+		return v, errors.New("expected 3 values")
+	}
+	for i, field := range fields {
+		f, err := strconv.ParseFloat(field, 64)
+		if err != nil {
+			return v, err
+		}
+		v[i] = f
+	}
+	return v, nil
+}
+
+// parses the adobe/resolve .cube format, a file can hold a 1d table, a 3d table or a 1d shaper followed by a 3d table
+func parseCube(data []byte) (*ColorLUT, error) {
+	lut := &ColorLUT{shaperMax: [3]float64{1, 1, 1}, tableMax: [3]float64{1, 1, 1}}
+	size1D, size3D := 0, 0
+	var values [][3]float64
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		lineErr := func(msg string) error {
+			return fmt.Errorf("cube line %d: %s", line, msg)
+		}
+
+		switch fields[0] {
+		case "TITLE":
+		case "LUT_1D_SIZE", "LUT_3D_SIZE":
+			size, err := strconv.Atoi(strings.Join(fields[1:], ""))
+			if err != nil || size < 2 || (fields[0] == "LUT_3D_SIZE" && size > CUBE_MAX_SIZE) || size > 0x10000 {
+				return nil, lineErr("invalid table size")
+			}
+			if fields[0] == "LUT_1D_SIZE" {
+				size1D = size
+			} else {
+				size3D = size
+			}
+		case "DOMAIN_MIN", "DOMAIN_MAX":
+			v, err := parseCubeTriple(fields[1:])
+			if err != nil {
+				return nil, lineErr("domain needs 3 floats")
+			}
+			if fields[0] == "DOMAIN_MIN" {
+				lut.shaperMin, lut.tableMin = v, v
+			} else {
+				lut.shaperMax, lut.tableMax = v, v
+			}
+		case "LUT_1D_INPUT_RANGE", "LUT_3D_INPUT_RANGE":
+			if len(fields) != 3 {
+				return nil, lineErr("input range needs 2 floats")
+			}
+			lo, errLo := strconv.ParseFloat(fields[1], 64)
+			hi, errHi := strconv.ParseFloat(fields[2], 64)
+			if errLo != nil || errHi != nil {
+				return nil, lineErr("input range needs 2 floats")
+			}
+			if fields[0] == "LUT_1D_INPUT_RANGE" {
+				lut.shaperMin, lut.shaperMax = [3]float64{lo, lo, lo}, [3]float64{hi, hi, hi}
+			} else {
+				lut.tableMin, lut.tableMax = [3]float64{lo, lo, lo}, [3]float64{hi, hi, hi}
+			}
+		default:
+			v, err := parseCubeTriple(fields)
+			if err != nil {
+				return nil, lineErr("unknown keyword or invalid table entry " + fields[0])
+			}
+			values = append(values, v)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if size1D == 0 && size3D == 0 {
+		return nil, errors.New("cube file needs LUT_1D_SIZE or LUT_3D_SIZE")
+	}
+	if len(values) != size1D+size3D*size3D*size3D {
+		return nil, fmt.Errorf("cube file has %d table entries, its sizes need %d", len(values), size1D+size3D*size3D*size3D)
+	}
+
+	for ch := range 3 {
+		if lut.shaperMax[ch] <= lut.shaperMin[ch] || lut.tableMax[ch] <= lut.tableMin[ch] {
+			return nil, errors.New("cube domain max needs to be greater than domain min")
+		}
+	}
+
+	lut.shaper, lut.table, lut.size = values[:size1D], values[size1D:], size3D
+	return lut, nil
+}
+
+// a hald clut of level l is a square image with l^3 pixels per side holding a 3d table of size l^2
+func decodeHaldCLUT(data []byte) (*ColorLUT, error) {
+	img, _, err := DecodeImage(data, ReadOptions{Limits: DefaultImageLimits})
+	if err != nil {
+		return nil, err
+	}
+
+	bnds := img.Bounds()
+	level := int(math.Round(math.Cbrt(float64(bnds.Dx()))))
+	if bnds.Dx() != bnds.Dy() || level*level*level != bnds.Dx() || level < 2 {
+		return nil, errors.New("hald clut needs to be a square image with a cubic side length, e.g. 512x512 for level 8")
+	}
+
+	size := level * level
+	lut := &ColorLUT{size: size, table: make([][3]float64, size*size*size), tableMax: [3]float64{1, 1, 1}}
+	for i := range lut.table {
+		r, g, b, _ := img.At(bnds.Min.X+i%bnds.Dx(), bnds.Min.Y+i/bnds.Dx()).RGBA()
+		lut.table[i] = [3]float64{float64(r) / 0xffff, float64(g) / 0xffff, float64(b) / 0xffff}
+	}
+
+	return lut, nil
+}
+
+func (lut *ColorLUT) at(r, g, b int) [3]float64 {
+	return lut.table[(b*lut.size+g)*lut.size+r]
+}
+
+func (lut *ColorLUT) applyShaper(c [3]float64) [3]float64 {
+	n := len(lut.shaper)
+	for ch := range c {
+		pos := clampUnit((c[ch]-lut.shaperMin[ch])/(lut.shaperMax[ch]-lut.shaperMin[ch])) * float64(n-1)
+		i := min(int(pos), n-2)
+		c[ch] = lut.shaper[i][ch] + (lut.shaper[i+1][ch]-lut.shaper[i][ch])*(pos-float64(i))
+	}
+	return c
+}
+
+func (lut *ColorLUT) applyTable(c [3]float64) [3]float64 {
+	var base [3]int
+	var frac [3]float64
+	for ch := range c {
+		pos := clampUnit((c[ch]-lut.tableMin[ch])/(lut.tableMax[ch]-lut.tableMin[ch])) * float64(lut.size-1)
+		base[ch] = min(int(pos), lut.size-2)
+		frac[ch] = pos - float64(base[ch])
+	}
+
+	r0, g0, b0 := base[0], base[1], base[2]
+	fr, fg, fb := frac[0], frac[1], frac[2]
+	c000, c111 := lut.at(r0, g0, b0), lut.at(r0+1, g0+1, b0+1)
+
+	var out [3]float64
+	if lut.tetrahedral {
+		// the cube is split into 6 tetrahedra along its diagonal, the order of the fractions selects the one holding the point
+		var c1, c2 [3]float64
+		var w0, w1, w2, w3 float64
+		switch {
+		case fr >= fg && fg >= fb:
+			c1, c2 = lut.at(r0+1, g0, b0), lut.at(r0+1, g0+1, b0)
+			w0, w1, w2, w3 = 1-fr, fr-fg, fg-fb, fb
+		case fr >= fb && fb >= fg:
+			c1, c2 = lut.at(r0+1, g0, b0), lut.at(r0+1, g0, b0+1)
+			w0, w1, w2, w3 = 1-fr, fr-fb, fb-fg, fg
+		case fb >= fr && fr >= fg:
+			c1, c2 = lut.at(r0, g0, b0+1), lut.at(r0+1, g0, b0+1)
+			w0, w1, w2, w3 = 1-fb, fb-fr, fr-fg, fg
+		case fg >= fr && fr >= fb:
+			c1, c2 = lut.at(r0, g0+1, b0), lut.at(r0+1, g0+1, b0)
+			w0, w1, w2, w3 = 1-fg, fg-fr, fr-fb, fb
+		case fg >= fb && fb >= fr:
+			c1, c2 = lut.at(r0, g0+1, b0), lut.at(r0, g0+1, b0+1)
+			w0, w1, w2, w3 = 1-fg, fg-fb, fb-fr, fr
+		default:
+			c1, c2 = lut.at(r0, g0, b0+1), lut.at(r0, g0+1, b0+1)
+			w0, w1, w2, w3 = 1-fb, fb-fg, fg-fr, fr
+		}
+		for ch := range out {
+			out[ch] = w0*c000[ch] + w1*c1[ch] + w2*c2[ch] + w3*c111[ch]
+		}
+		return out
+	}
+
+	c100, c010, c110 := lut.at(r0+1, g0, b0), lut.at(r0, g0+1, b0), lut.at(r0+1, g0+1, b0)
+	c001, c101, c011 := lut.at(r0, g0, b0+1), lut.at(r0+1, g0, b0+1), lut.at(r0, g0+1, b0+1)
+	for ch := range out {
+		c00 := c000[ch] + (c100[ch]-c000[ch])*fr
+		c10 := c010[ch] + (c110[ch]-c010[ch])*fr
+		c01 := c001[ch] + (c101[ch]-c001[ch])*fr
+		c11 := c011[ch] + (c111[ch]-c011[ch])*fr
+		c0 := c00 + (c10-c00)*fg
+		c1 := c01 + (c11-c01)*fg
+		out[ch] = c0 + (c1-c0)*fb
+	}
+	return out
+}
+
+func (lut *ColorLUT) Apply(r, g, b float64) (float64, float64, float64) {
+	c := [3]float64{r, g, b}
+	if len(lut.shaper) > 0 {
+		c = lut.applyShaper(c)
+	}
+	if lut.size > 0 {
+		c = lut.applyTable(c)
+	}
+	return c[0], c[1], c[2]
+}
+
+// parses the lut path, optionally followed by the interpolation and the strength in percent
+func parseLUT(args []string) (ColorFunc, error) {
+	if len(args) == 0 {
+		return nil, errors.New("filter needs a .cube file or hald clut image as non-flag argument, optionally followed by trilinear or tetrahedral and the strength in percent (float)")
+	}
+
+	interpolation, strength := LUT_TETRAHEDRAL, 1.0
+	for _, arg := range args[1:] {
+		if v, err := strconv.ParseFloat(arg, 64); err == nil {
+			if v < 0 || v > 100 {
+				return nil, errors.New("lut strength needs to be between 0 and 100")
+			}
+			strength = v / 100
+		} else {
+			interpolation = arg
+		}
+	}
+
+	lut, err := LoadColorLUT(args[0], interpolation)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(r, g, b float64) (float64, float64, float64) {
+		lr, lg, lb := lut.Apply(r, g, b)
+		return r + (lr-r)*strength, g + (lg-g)*strength, b + (lb-b)*strength
+	}, nil
+}
+
+// evaluates the per-pixel filters of the pipeline at every grid point and writes them as a 3d .cube table of the size,
+// the grid holds the rgb values of the working space
+func ExportCubeLUT(w io.Writer, filterName string, args []string, size int, space *ColorProfile) error {
+	if size < 2 || size > CUBE_MAX_SIZE {
+		return fmt.Errorf("cube size needs to be between 2 and %d", CUBE_MAX_SIZE)
+	}
+
+	filters, names, err := parsePipeline[*image.RGBA64](filterName, args)
+	if err != nil {
+		return err
+	}
+	setWorkingSpace(filters, space)
+
+	fns := make([]ColorFunc, len(filters))
+	for i, filter := range filters {
+		pixelFilter, ok := filter.(PixelFilterer)
+		if !ok {
+			return errors.New(names[i] + " is not a per-pixel filter and can't be exported as a lut")
+		}
+		fns[i] = pixelFilter.PixelFunc()
+	}
+
+	out := bufio.NewWriter(w)
+	fmt.Fprintf(out, "TITLE \"%s\"\n", strings.Join(names, " + "))
+	fmt.Fprintf(out, "LUT_3D_SIZE %d\n", size)
+	fmt.Fprintln(out, "DOMAIN_MIN 0.0 0.0 0.0")
+	fmt.Fprintln(out, "DOMAIN_MAX 1.0 1.0 1.0")
+
+	step := 1 / float64(size-1)
+	for b := range size {
+		for g := range size {
+			for r := range size {
+				cr, cg, cb := float64(r)*step, float64(g)*step, float64(b)*step
+				for _, fn := range fns {
+					cr, cg, cb = fn(cr, cg, cb)
+					cr, cg, cb = clampUnit(cr), clampUnit(cg), clampUnit(cb)
+				}
+				fmt.Fprintf(out, "%.6f %.6f %.6f\n", cr, cg, cb)
+			}
+		}
+	}
+
+	return out.Flush()
+}
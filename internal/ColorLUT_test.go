@@ -0,0 +1,223 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"strings"
+	"testing"
+)
+
+// 1d table mapping every channel value v to 1 - v
+const invertingShaper = "LUT_1D_SIZE 2\n1 1 1\n0 0 0\n"
+
+// entries of an identity 3d table of the size, red changes fastest
+func identityCubeEntries(size int) string {
+	var sb strings.Builder
+	step := 1 / float64(size-1)
+	for b := range size {
+		for g := range size {
+			for r := range size {
+				fmt.Fprintf(&sb, "%g %g %g\n", float64(r)*step, float64(g)*step, float64(b)*step)
+			}
+		}
+	}
+	return sb.String()
+}
+
+func identityCube(size int) string {
+	return fmt.Sprintf("TITLE \"identity\"\nLUT_3D_SIZE %d\n", size) + identityCubeEntries(size)
+}
+
+// encodes a 16 bit hald clut of the level holding an identity table
+func identityHaldPNG(t *testing.T, level int) []byte {
+	t.Helper()
+
+	size, side := level*level, level*level*level
+	scale := float64(0xffff) / float64(size-1)
+	img := image.NewRGBA64(image.Rect(0, 0, side, side))
+	for i := range size * size * size {
+		r, g, b := i%size, (i/size)%size, i/(size*size)
+		img.SetRGBA64(i%side, i/side, color.RGBA64{uint16(math.Round(float64(r) * scale)), uint16(math.Round(float64(g) * scale)), uint16(math.Round(float64(b) * scale)), 0xffff})
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func checkLUTSamples(t *testing.T, lut *ColorLUT, samples [][2][3]float64, eps float64) {
+	t.Helper()
+
+	for _, sample := range samples {
+		in, want := sample[0], sample[1]
+		r, g, b := lut.Apply(in[0], in[1], in[2])
+		for ch, got := range [3]float64{r, g, b} {
+			if math.Abs(got-want[ch]) > eps {
+				t.Errorf("Apply(%v) = %v, want %v", in, [3]float64{r, g, b}, want)
+				break
+			}
+		}
+	}
+}
+
+func TestParseCube(t *testing.T) {
+	tests := []struct {
+		name            string
+		data            string
+		shaperLen, size int
+		samples         [][2][3]float64
+	}{
+		{
+			"1d only", invertingShaper, 2, 0,
+			[][2][3]float64{{{0, 0.25, 1}, {1, 0.75, 0}}},
+		},
+		{
+			"3d only", identityCube(3), 0, 3,
+			[][2][3]float64{{{0.1, 0.5, 0.9}, {0.1, 0.5, 0.9}}},
+		},
+		{
+			"shaper and 3d", invertingShaper + "LUT_3D_SIZE 2\n" + identityCubeEntries(2), 2, 2,
+			[][2][3]float64{{{0, 0.25, 1}, {1, 0.75, 0}}},
+		},
+		{
+			"comments and blank lines", "# comment\n\nLUT_3D_SIZE 2\n\n" + identityCubeEntries(2), 0, 2,
+			[][2][3]float64{{{0.3, 0.3, 0.3}, {0.3, 0.3, 0.3}}},
+		},
+		{
+			"domain", "DOMAIN_MIN 0 0 0\nDOMAIN_MAX 2 4 2\n" + identityCube(2), 0, 2,
+			[][2][3]float64{{{1, 1, 2}, {0.5, 0.25, 1}}, {{3, -1, 0}, {1, 0, 0}}},
+		},
+		{
+			"3d input range", "LUT_3D_INPUT_RANGE 0 2\n" + identityCube(2), 0, 2,
+			[][2][3]float64{{{1, 0.5, 2}, {0.5, 0.25, 1}}},
+		},
+		{
+			"1d input range only scales the shaper", "LUT_1D_INPUT_RANGE 0 2\nLUT_1D_SIZE 2\n0 0 0\n1 1 1\nLUT_3D_SIZE 2\n" + identityCubeEntries(2), 2, 2,
+			[][2][3]float64{{{1, 2, 0.5}, {0.5, 1, 0.25}}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			lut, err := parseCube([]byte(test.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(lut.shaper) != test.shaperLen || lut.size != test.size {
+				t.Fatalf("got a shaper of %d entries and a table of size %d, want %d and %d", len(lut.shaper), lut.size, test.shaperLen, test.size)
+			}
+			checkLUTSamples(t, lut, test.samples, 1e-9)
+		})
+	}
+}
+
+func TestParseCubeRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		// part of the expected error message
+		err string
+	}{
+		{"no size", "0 0 0\n1 1 1\n", "needs LUT_1D_SIZE or LUT_3D_SIZE"},
+		{"too few entries", "LUT_3D_SIZE 2\n" + strings.Join(strings.SplitAfter(identityCubeEntries(2), "\n")[:5], ""), "cube file has 5 table entries, its sizes need 8"},
+		{"too many entries", "LUT_3D_SIZE 2\n" + identityCubeEntries(3), "cube file has 27 table entries, its sizes need 8"},
+		{"missing shaper entries", "LUT_1D_SIZE 4\nLUT_3D_SIZE 2\n" + identityCubeEntries(2), "its sizes need 12"},
+		{"size 1", "LUT_3D_SIZE 1\n0 0 0\n", "invalid table size"},
+		{"3d size above the maximum", "LUT_3D_SIZE 257\n", "invalid table size"},
+		{"1d size above the maximum", "LUT_1D_SIZE 65537\n", "invalid table size"},
+		{"unknown keyword", "LUT_3D_SIZE 2\nFOO 1\n", "unknown keyword"},
+		{"entry with 2 values", "LUT_1D_SIZE 2\n0 0\n1 1\n", "line 2"},
+		{"domain with 2 values", "DOMAIN_MIN 0 0\n" + identityCube(2), "domain needs 3 floats"},
+		{"input range with 1 value", "LUT_3D_INPUT_RANGE 1\n" + identityCube(2), "input range needs 2 floats"},
+		{"empty domain", "DOMAIN_MIN 0 1 0\nDOMAIN_MAX 1 1 1\n" + identityCube(2), "domain max needs to be greater"},
+		{"inverted input range", "LUT_1D_INPUT_RANGE 1 0\n" + invertingShaper, "domain max needs to be greater"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			lut, err := parseCube([]byte(test.data))
+			if err == nil {
+				t.Fatalf("expected an error, got a lut of size %d", lut.size)
+			}
+			if !strings.Contains(err.Error(), test.err) {
+				t.Errorf("expected an error containing %q, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestColorLUTIdentity(t *testing.T) {
+	var samples [][2][3]float64
+	for _, r := range []float64{0, 0.13, 0.5, 0.77, 1} {
+		for _, g := range []float64{0, 0.31, 0.62, 1} {
+			for _, b := range []float64{0, 0.05, 0.49, 0.98} {
+				c := [3]float64{r, g, b}
+				samples = append(samples, [2][3]float64{c, c})
+			}
+		}
+	}
+
+	for _, size := range []int{2, 5, 17} {
+		for _, interpolation := range []string{LUT_TRILINEAR, LUT_TETRAHEDRAL} {
+			t.Run(fmt.Sprintf("%s size %d", interpolation, size), func(t *testing.T) {
+				lut, err := parseCube([]byte(identityCube(size)))
+				if err != nil {
+					t.Fatal(err)
+				}
+				lut.tetrahedral = interpolation == LUT_TETRAHEDRAL
+				checkLUTSamples(t, lut, samples, 1e-6)
+			})
+		}
+	}
+}
+
+func TestDecodeHaldCLUT(t *testing.T) {
+	for _, level := range []int{2, 3} {
+		t.Run(fmt.Sprintf("identity level %d", level), func(t *testing.T) {
+			size := level * level
+			lut, err := decodeHaldCLUT(identityHaldPNG(t, level))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if lut.size != size {
+				t.Fatalf("got table size %d, want %d", lut.size, size)
+			}
+
+			samples := [][2][3]float64{{{0, 0, 0}, {0, 0, 0}}, {{1, 1, 1}, {1, 1, 1}}, {{0.2, 0.6, 0.9}, {0.2, 0.6, 0.9}}}
+			checkLUTSamples(t, lut, samples, 1e-4)
+		})
+	}
+
+	tests := []struct {
+		name          string
+		width, height int
+	}{
+		{"not square", 8, 9},
+		{"side not a cube", 10, 10},
+		{"level 1", 1, 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, test.width, test.height))); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := decodeHaldCLUT(buf.Bytes()); err == nil {
+				t.Errorf("expected an error for a %dx%d hald clut", test.width, test.height)
+			}
+		})
+	}
+
+	t.Run("not an image", func(t *testing.T) {
+		if _, err := decodeHaldCLUT([]byte("LUT_3D_SIZE 2")); err == nil {
+			t.Errorf("expected an error")
+		}
+	})
+}
@@ -0,0 +1,154 @@
+package internal
+
+import (
+	"errors"
+	"math"
+)
+
+const (
+	GRAYSCALE_REC601    = "601"
+	GRAYSCALE_REC709    = "709"
+	GRAYSCALE_AVERAGE   = "average"
+	GRAYSCALE_LIGHTNESS = "lightness"
+)
+
+// rows of the channel mixer give the output r, g and b as weights of the input r, g, b and an offset
+type channelMatrix [3][4]float64
+
+var identityChannelMatrix = channelMatrix{
+	{1, 0, 0, 0},
+	{0, 1, 0, 0},
+	{0, 0, 1, 0},
+}
+
+// the common sepia matrix, warm browns with slightly lifted highlights
+var sepiaChannelMatrix = channelMatrix{
+	{0.393, 0.769, 0.189, 0},
+	{0.349, 0.686, 0.168, 0},
+	{0.272, 0.534, 0.131, 0},
+}
+
+// gray value weights of the grayscale methods, lightness is handled separately
+var grayscaleWeights = map[string][3]float64{
+	GRAYSCALE_REC601:  {0.299, 0.587, 0.114},
+	GRAYSCALE_REC709:  {INTENSITY_RED_FACTOR, INTENSITY_GREEN_FACTOR, INTENSITY_BLUE_FACTOR},
+	GRAYSCALE_AVERAGE: {1.0 / 3, 1.0 / 3, 1.0 / 3},
+	"r":               {1, 0, 0},
+	"g":               {0, 1, 0},
+	"b":               {0, 0, 1},
+}
+
+func (m *channelMatrix) apply(r, g, b float64) (float64, float64, float64) {
+	var out [3]float64
+	for i, row := range m {
+		out[i] = row[0]*r + row[1]*g + row[2]*b + row[3]
+	}
+	return out[0], out[1], out[2]
+}
+
+// blends the matrix with the identity, strength 1 keeps it unchanged
+func (m channelMatrix) blend(strength float64) channelMatrix {
+	for i := range m {
+		for j := range m[i] {
+			m[i][j] = identityChannelMatrix[i][j] + (m[i][j]-identityChannelMatrix[i][j])*strength
+		}
+	}
+	return m
+}
+
+// parses [601|709|average|lightness|r|g|b], rec. 709 like the luminance of the other filters by default
+func parseGrayscale(args []string) (ColorFunc, error) {
+	method := GRAYSCALE_REC709
+	if len(args) >= 1 {
+		method = args[0]
+	}
+
+	if method == GRAYSCALE_LIGHTNESS {
+		return func(r, g, b float64) (float64, float64, float64) {
+			l := (max(r, g, b) + min(r, g, b)) / 2
+			return l, l, l
+		}, nil
+	}
+
+	weights, found := grayscaleWeights[method]
+	if !found {
+		return nil, errors.New("filter needs 601, 709, average, lightness, r, g or b as optional non-flag argument")
+	}
+	return func(r, g, b float64) (float64, float64, float64) {
+		v := weights[0]*r + weights[1]*g + weights[2]*b
+		return v, v, v
+	}, nil
+}
+
+// parses an optional strength in percent, 100 by default
+func parseSepia(args []string) (ColorFunc, error) {
+	strength := 100.0
+	if len(args) >= 1 {
+		var err error
+		if strength, err = parseSingleToneArg(args, "filter needs the strength in percent (float between 0 and 100) as optional non-flag argument"); err != nil {
+			return nil, err
+		}
+		if strength < 0 || strength > 100 {
+			return nil, errors.New("sepia strength needs to be between 0 and 100")
+		}
+	}
+
+	matrix := sepiaChannelMatrix.blend(strength / 100)
+	return matrix.apply, nil
+}
+
+// maps the luminance to a gradient through the given color stops, shadows first
+func toneMapping(args []string, stops int, usage string) (ColorFunc, error) {
+	if len(args) != stops {
+		return nil, errors.New(usage)
+	}
+
+	gradient, err := parseColorStops(args)
+	if err != nil {
+		return nil, errors.New(usage + ": " + err.Error())
+	}
+	return func(r, g, b float64) (float64, float64, float64) {
+		c := gradient(clampUnit(luminance(r, g, b)))
+		return c[0], c[1], c[2]
+	}, nil
+}
+
+func parseDuotone(args []string) (ColorFunc, error) {
+	return toneMapping(args, 2, "filter needs the shadow and highlight colors as non-flag arguments")
+}
+
+func parseTritone(args []string) (ColorFunc, error) {
+	return toneMapping(args, 3, "filter needs the shadow, midtone and highlight colors as non-flag arguments")
+}
+
+// parses the 3x4 matrix row by row, 9 values leave the offsets at 0, offsets are between -255 and 255,
+// monochrome as last argument takes a single row for all channels
+func parseChannelMixer(args []string) (ColorFunc, error) {
+	rows := 3
+	if len(args) > 0 && args[len(args)-1] == "monochrome" {
+		rows = 1
+		args = args[:len(args)-1]
+	}
+
+	usage := "filter needs the 3x4 channel matrix rr, rg, rb, ro, gr, gg, gb, go, br, bg, bb, bo (12 floats) or the 3x3 matrix without offsets as non-flag arguments, " +
+		"or a single row followed by monochrome"
+	if len(args) != rows*3 && len(args) != rows*4 {
+		return nil, errors.New(usage)
+	}
+	vals, err := parseFloatArgs(args)
+	if err != nil {
+		return nil, errors.New(usage)
+	}
+
+	var matrix channelMatrix
+	cols := len(vals) / rows
+	for i := range matrix {
+		row := i % rows
+		copy(matrix[i][:], vals[row*cols:(row+1)*cols])
+		if math.Abs(matrix[i][3]) > 255 {
+			return nil, errors.New("channel mixer offsets need to be between -255 and 255")
+		}
+		matrix[i][3] /= 255
+	}
+	return matrix.apply, nil
+}
@@ -0,0 +1,245 @@
+package internal
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"sort"
+)
+
+type colorMatrix [3][3]float64
+
+type toneCurve struct {
+	// parametric curve y = (a*x+b)^g + e for x >= d, y = c*x + f otherwise
+	g, a, b, c, d, e, f float64
+	// sampled curve, used instead of the parameters when set
+	table []float64
+}
+
+type ColorProfile struct {
+	Name  string
+	toXYZ colorMatrix
+	trc   [3]toneCurve
+	icc   []byte
+}
+
+var (
+	whitePointD65 = [3]float64{0.95047, 1.0, 1.08883}
+	whitePointD50 = [3]float64{0.96422, 1.0, 0.82521}
+
+	bradfordMatrix = colorMatrix{
+		{0.8951, 0.2664, -0.1614},
+		{-0.7502, 1.7135, 0.0367},
+		{0.0389, -0.0685, 1.0296},
+	}
+
+	srgbCurve  = toneCurve{g: 2.4, a: 1 / 1.055, b: 0.055 / 1.055, c: 1 / 12.92, d: 0.04045}
+	adobeCurve = toneCurve{g: 563.0 / 256.0, a: 1}
+
+	ColorProfileSRGB       = newColorProfile("sRGB", [3][2]float64{{0.64, 0.33}, {0.30, 0.60}, {0.15, 0.06}}, whitePointD65, srgbCurve)
+	ColorProfileDisplayP3  = newColorProfile("Display P3", [3][2]float64{{0.680, 0.320}, {0.265, 0.690}, {0.150, 0.060}}, whitePointD65, srgbCurve)
+	ColorProfileAdobeRGB   = newColorProfile("Adobe RGB (1998)", [3][2]float64{{0.64, 0.33}, {0.21, 0.71}, {0.15, 0.06}}, whitePointD65, adobeCurve)
+	colorProfilesByName    = map[string]*ColorProfile{"srgb": ColorProfileSRGB, "displayp3": ColorProfileDisplayP3, "adobergb": ColorProfileAdobeRGB}
+	errUnsupportedProfile  = errors.New("unsupported icc profile")
+	colorProfileCompareEps = 1.0 / 4096.0
+)
+
+func GetColorProfile(name string) (*ColorProfile, error) {
+	if profile, found := colorProfilesByName[name]; found {
+		return profile, nil
+	}
+
+	return nil, errors.New("unknown color space, available: srgb, displayp3, adobergb")
+}
+
+func newColorProfile(name string, primaries [3][2]float64, white [3]float64, curve toneCurve) *ColorProfile {
+	var m colorMatrix
+	for i, p := range primaries {
+		m[0][i] = p[0] / p[1]
+		m[1][i] = 1
+		m[2][i] = (1 - p[0] - p[1]) / p[1]
+	}
+
+	// scale the primaries so that rgb(1, 1, 1) maps onto the white point
+	s := m.inverse().apply(white)
+	for row := range 3 {
+		for col := range 3 {
+			m[row][col] *= s[col]
+		}
+	}
+
+	profile := &ColorProfile{Name: name, toXYZ: chromaticAdaptation(white, whitePointD50).mul(m), trc: [3]toneCurve{curve, curve, curve}}
+	profile.icc = encodeIccProfile(profile, white)
+	return profile
+}
+
+func chromaticAdaptation(from, to [3]float64) colorMatrix {
+	coneFrom := bradfordMatrix.apply(from)
+	coneTo := bradfordMatrix.apply(to)
+
+	var scale colorMatrix
+	for i := range 3 {
+		scale[i][i] = coneTo[i] / coneFrom[i]
+	}
+
+	return bradfordMatrix.inverse().mul(scale.mul(bradfordMatrix))
+}
+
+func (profile *ColorProfile) Icc() []byte {
+	return profile.icc
+}
+
+func (profile *ColorProfile) equals(other *ColorProfile) bool {
+	for row := range 3 {
+		for col := range 3 {
+			if math.Abs(profile.toXYZ[row][col]-other.toXYZ[row][col]) > colorProfileCompareEps {
+				return false
+			}
+		}
+	}
+
+	for ch := range 3 {
+		for v := 0.0; v <= 1.0; v += 1.0 / 16.0 {
+			if math.Abs(profile.trc[ch].toLinear(v)-other.trc[ch].toLinear(v)) > colorProfileCompareEps {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+func (m colorMatrix) mul(o colorMatrix) (r colorMatrix) {
+	for row := range 3 {
+		for col := range 3 {
+			for k := range 3 {
+				r[row][col] += m[row][k] * o[k][col]
+			}
+		}
+	}
+	return
+}
+
+func (m colorMatrix) apply(v [3]float64) (r [3]float64) {
+	for row := range 3 {
+		r[row] = m[row][0]*v[0] + m[row][1]*v[1] + m[row][2]*v[2]
+	}
+	return
+}
+
+func (m colorMatrix) inverse() (r colorMatrix) {
+	det := m[0][0]*(m[1][1]*m[2][2]-m[1][2]*m[2][1]) -
+		m[0][1]*(m[1][0]*m[2][2]-m[1][2]*m[2][0]) +
+		m[0][2]*(m[1][0]*m[2][1]-m[1][1]*m[2][0])
+
+	r[0][0] = (m[1][1]*m[2][2] - m[1][2]*m[2][1]) / det
+	r[0][1] = (m[0][2]*m[2][1] - m[0][1]*m[2][2]) / det
+	r[0][2] = (m[0][1]*m[1][2] - m[0][2]*m[1][1]) / det
+	r[1][0] = (m[1][2]*m[2][0] - m[1][0]*m[2][2]) / det
+	r[1][1] = (m[0][0]*m[2][2] - m[0][2]*m[2][0]) / det
+	r[1][2] = (m[0][2]*m[1][0] - m[0][0]*m[1][2]) / det
+	r[2][0] = (m[1][0]*m[2][1] - m[1][1]*m[2][0]) / det
+	r[2][1] = (m[0][1]*m[2][0] - m[0][0]*m[2][1]) / det
+	r[2][2] = (m[0][0]*m[1][1] - m[0][1]*m[1][0]) / det
+	return
+}
+
+func (curve *toneCurve) toLinear(v float64) float64 {
+	if curve.table != nil {
+		pos := math.Max(0, math.Min(1, v)) * float64(len(curve.table)-1)
+		idx := int(pos)
+		if idx >= len(curve.table)-1 {
+			return curve.table[len(curve.table)-1]
+		}
+		return curve.table[idx] + (curve.table[idx+1]-curve.table[idx])*(pos-float64(idx))
+	}
+
+	if v >= curve.d {
+		return math.Pow(math.Max(0, curve.a*v+curve.b), curve.g) + curve.e
+	}
+	return curve.c*v + curve.f
+}
+
+func (curve *toneCurve) fromLinear(v float64) float64 {
+	if curve.table != nil {
+		n := len(curve.table)
+		idx := sort.SearchFloat64s(curve.table, v)
+		if idx <= 0 {
+			return 0
+		} else if idx >= n {
+			return 1
+		}
+
+		lo, hi := curve.table[idx-1], curve.table[idx]
+		frac := 0.0
+		if hi > lo {
+			frac = (v - lo) / (hi - lo)
+		}
+		return (float64(idx-1) + frac) / float64(n-1)
+	}
+
+	if v >= math.Pow(math.Max(0, curve.a*curve.d+curve.b), curve.g)+curve.e {
+		return (math.Pow(math.Max(0, v-curve.e), 1/curve.g) - curve.b) / curve.a
+	}
+	if curve.c == 0 {
+		return curve.d
+	}
+	return (v - curve.f) / curve.c
+}
+
+func ConvertColorProfile(img image.Image, src, dst *ColorProfile) {
+	if src.equals(dst) {
+		return
+	}
+
+	rgbaImg, ok := img.(draw.RGBA64Image)
+	if !ok {
+		return
+	}
+
+	_, is16Bit := img.(*image.RGBA64)
+	cMax := 0xff
+	if is16Bit {
+		cMax = 0xffff
+	}
+
+	// the source curve only has to be evaluated for every possible channel value once
+	decodeLUT := make([][]float64, 3)
+	for ch := range 3 {
+		decodeLUT[ch] = make([]float64, cMax+1)
+		for v := range cMax + 1 {
+			decodeLUT[ch][v] = src.trc[ch].toLinear(float64(v) / float64(cMax))
+		}
+	}
+
+	m := dst.toXYZ.inverse().mul(src.toXYZ)
+	bnds := img.Bounds()
+
+	for y := bnds.Min.Y; y < bnds.Max.Y; y++ {
+		for x := bnds.Min.X; x < bnds.Max.X; x++ {
+			c := rgbaImg.RGBA64At(x, y)
+			if c.A == 0 {
+				continue
+			}
+
+			a := float64(c.A) / 0xffff
+			rgb := [3]float64{}
+			for ch, v := range [3]uint16{c.R, c.G, c.B} {
+				unpremultiplied := math.Min(1, float64(v)/0xffff/a)
+				rgb[ch] = decodeLUT[ch][int(math.Round(unpremultiplied*float64(cMax)))]
+			}
+
+			rgb = m.apply(rgb)
+
+			var out [3]uint16
+			for ch := range 3 {
+				encoded := dst.trc[ch].fromLinear(math.Max(0, math.Min(1, rgb[ch])))
+				out[ch] = uint16(math.Round(math.Max(0, math.Min(1, encoded))*a*float64(cMax)) * float64(0xffff/cMax))
+			}
+
+			rgbaImg.SetRGBA64(x, y, color.RGBA64{out[0], out[1], out[2], c.A})
+		}
+	}
+}
@@ -0,0 +1,431 @@
+package internal
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"slices"
+)
+
+const (
+	GIF_PALETTE_SIZE   = 256
+	ALPHA_TRANSPARENCY = 0x80
+
+	QUANTIZER_MEDIAN_CUT = "mediancut"
+	QUANTIZER_OCTREE     = "octree"
+	QUANTIZER_KMEANS     = "kmeans"
+
+	KMEANS_MAX_ITERATIONS = 16
+	// the iterations stop once no center moves farther than this in 8 bit units
+	KMEANS_CONVERGENCE = 0.5
+)
+
+// builds a palette of at most size colors from the counted colors
+type quantizer func(colors []colorCount, size int) color.Palette
+
+var quantizers = map[string]quantizer{
+	QUANTIZER_MEDIAN_CUT: medianCut,
+	QUANTIZER_OCTREE:     octree,
+	QUANTIZER_KMEANS:     kMeans,
+}
+
+// share of the visible pixels closest to a palette color
+type PaletteEntry struct {
+	Color      color.RGBA
+	Proportion float64
+}
+
+type colorCount struct {
+	clr   [3]uint8
+	count int
+}
+
+type colorBox struct {
+	colors []colorCount
+}
+
+func countColors(imgs []image.Image) ([]colorCount, bool) {
+	counts := map[[3]uint8]int{}
+	hasTransparency := false
+
+	for _, img := range imgs {
+		bnds := img.Bounds()
+		for y := bnds.Min.Y; y < bnds.Max.Y; y++ {
+			for x := bnds.Min.X; x < bnds.Max.X; x++ {
+				c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+				if c.A < ALPHA_TRANSPARENCY {
+					hasTransparency = true
+					continue
+				}
+				counts[[3]uint8{c.R, c.G, c.B}]++
+			}
+		}
+	}
+
+	return sortedColorCounts(counts), hasTransparency
+}
+
+// the colors are sorted, so the palettes don't depend on the iteration order of the map
+func sortedColorCounts(counts map[[3]uint8]int) []colorCount {
+	colors := make([]colorCount, 0, len(counts))
+	for clr, count := range counts {
+		colors = append(colors, colorCount{clr, count})
+	}
+	slices.SortFunc(colors, func(a, b colorCount) int {
+		return slices.Compare(a.clr[:], b.clr[:])
+	})
+
+	return colors
+}
+
+func (box *colorBox) widestChannel() (int, int) {
+	channel, width := 0, -1
+	for ch := range 3 {
+		lo, hi := uint8(0xff), uint8(0)
+		for _, c := range box.colors {
+			lo, hi = min(lo, c.clr[ch]), max(hi, c.clr[ch])
+		}
+		if int(hi)-int(lo) > width {
+			channel, width = ch, int(hi)-int(lo)
+		}
+	}
+	return channel, width
+}
+
+func (box *colorBox) mean() color.Color {
+	var sum [3]int
+	total := 0
+	for _, c := range box.colors {
+		for ch := range 3 {
+			sum[ch] += int(c.clr[ch]) * c.count
+		}
+		total += c.count
+	}
+
+	return color.RGBA{uint8(sum[0] / total), uint8(sum[1] / total), uint8(sum[2] / total), 0xff}
+}
+
+// splits the box with the widest channel range at the weighted median until enough boxes exist
+func medianCut(colors []colorCount, size int) color.Palette {
+	if len(colors) == 0 {
+		return color.Palette{}
+	}
+
+	boxes := []*colorBox{{colors}}
+	for len(boxes) < size {
+		splitIdx, splitCh, splitWidth := -1, 0, 0
+		for i, box := range boxes {
+			if len(box.colors) < 2 {
+				continue
+			}
+			if ch, width := box.widestChannel(); width > splitWidth {
+				splitIdx, splitCh, splitWidth = i, ch, width
+			}
+		}
+		if splitIdx < 0 {
+			break
+		}
+
+		box := boxes[splitIdx]
+		slices.SortFunc(box.colors, func(a, b colorCount) int {
+			return int(a.clr[splitCh]) - int(b.clr[splitCh])
+		})
+
+		total := 0
+		for _, c := range box.colors {
+			total += c.count
+		}
+
+		median, acc := 1, 0
+		for i, c := range box.colors[:len(box.colors)-1] {
+			acc += c.count
+			if acc*2 >= total {
+				median = i + 1
+				break
+			}
+		}
+
+		boxes[splitIdx] = &colorBox{box.colors[:median]}
+		boxes = append(boxes, &colorBox{box.colors[median:]})
+	}
+
+	palette := make(color.Palette, len(boxes))
+	for i, box := range boxes {
+		palette[i] = box.mean()
+	}
+
+	return palette
+}
+
+type octreeNode struct {
+	children [8]*octreeNode
+	sum      [3]int
+	count    int
+}
+
+func (node *octreeNode) mean() [3]float64 {
+	return [3]float64{float64(node.sum[0]) / float64(node.count), float64(node.sum[1]) / float64(node.count), float64(node.sum[2]) / float64(node.count)}
+}
+
+// sorts the colors into a tree with one level per bit, the inner nodes with the fewest pixels on the deepest
+// level are merged into leaves until at most size leaves are left, every leaf is one palette color
+func octree(colors []colorCount, size int) color.Palette {
+	if len(colors) == 0 {
+		return color.Palette{}
+	}
+
+	root := &octreeNode{}
+	var levels [8][]*octreeNode
+	levels[0] = []*octreeNode{root}
+	leaves := 0
+
+	for _, c := range colors {
+		node := root
+		node.count += c.count
+		for level := range 8 {
+			shift := 7 - level
+			idx := (c.clr[0]>>shift&1)<<2 | (c.clr[1]>>shift&1)<<1 | c.clr[2]>>shift&1
+			if node.children[idx] == nil {
+				node.children[idx] = &octreeNode{}
+				if level < 7 {
+					levels[level+1] = append(levels[level+1], node.children[idx])
+				} else {
+					leaves++
+				}
+			}
+			node = node.children[idx]
+			node.count += c.count
+		}
+		for ch := range 3 {
+			node.sum[ch] += int(c.clr[ch]) * c.count
+		}
+	}
+
+	for level := 7; leaves > size && level >= 0; {
+		if len(levels[level]) == 0 {
+			level--
+			continue
+		}
+
+		i := 0
+		for j, node := range levels[level] {
+			if node.count < levels[level][i].count {
+				i = j
+			}
+		}
+		node := levels[level][i]
+
+		children := 0
+		for _, child := range node.children {
+			if child != nil {
+				children++
+			}
+		}
+		// merging the whole node would leave fewer colors than requested
+		if leaves-children+1 < size {
+			break
+		}
+		levels[level] = slices.Delete(levels[level], i, i+1)
+
+		for idx, child := range node.children {
+			if child != nil {
+				for ch := range 3 {
+					node.sum[ch] += child.sum[ch]
+				}
+				node.children[idx] = nil
+			}
+		}
+		leaves -= children - 1
+	}
+
+	var leafNodes []*octreeNode
+	var collect func(node *octreeNode)
+	collect = func(node *octreeNode) {
+		isLeaf := true
+		for _, child := range node.children {
+			if child != nil {
+				isLeaf = false
+				collect(child)
+			}
+		}
+		if isLeaf {
+			leafNodes = append(leafNodes, node)
+		}
+	}
+	collect(root)
+
+	// the remaining surplus is merged leaf by leaf, the leaf with the fewest pixels joins the leaf with the closest mean
+	for len(leafNodes) > size {
+		i := 0
+		for j, node := range leafNodes {
+			if node.count < leafNodes[i].count {
+				i = j
+			}
+		}
+		merged := leafNodes[i]
+		leafNodes = slices.Delete(leafNodes, i, i+1)
+
+		centers := make([][3]float64, len(leafNodes))
+		for j, node := range leafNodes {
+			centers[j] = node.mean()
+		}
+		target := leafNodes[nearestCenter(centers, merged.mean())]
+		for ch := range 3 {
+			target.sum[ch] += merged.sum[ch]
+		}
+		target.count += merged.count
+	}
+
+	palette := make(color.Palette, len(leafNodes))
+	for i, node := range leafNodes {
+		mean := node.mean()
+		palette[i] = color.RGBA{uint8(math.Round(mean[0])), uint8(math.Round(mean[1])), uint8(math.Round(mean[2])), 0xff}
+	}
+
+	return palette
+}
+
+// refines the median cut palette with lloyds algorithm, every center moves to the mean of the colors closest to it
+func kMeans(colors []colorCount, size int) color.Palette {
+	palette := medianCut(colors, size)
+	centers := make([][3]float64, len(palette))
+	for i, c := range palette {
+		rgba := c.(color.RGBA)
+		centers[i] = [3]float64{float64(rgba.R), float64(rgba.G), float64(rgba.B)}
+	}
+
+	for range KMEANS_MAX_ITERATIONS {
+		sums := make([][3]float64, len(centers))
+		counts := make([]float64, len(centers))
+		for _, c := range colors {
+			clr := [3]float64{float64(c.clr[0]), float64(c.clr[1]), float64(c.clr[2])}
+			i := nearestCenter(centers, clr)
+			for ch := range 3 {
+				sums[i][ch] += clr[ch] * float64(c.count)
+			}
+			counts[i] += float64(c.count)
+		}
+
+		var moved float64
+		for i := range centers {
+			// empty clusters keep their center
+			if counts[i] == 0 {
+				continue
+			}
+			for ch := range 3 {
+				mean := sums[i][ch] / counts[i]
+				moved = math.Max(moved, math.Abs(mean-centers[i][ch]))
+				centers[i][ch] = mean
+			}
+		}
+		if moved < KMEANS_CONVERGENCE {
+			break
+		}
+	}
+
+	for i, c := range centers {
+		palette[i] = color.RGBA{uint8(math.Round(c[0])), uint8(math.Round(c[1])), uint8(math.Round(c[2])), 0xff}
+	}
+	return palette
+}
+
+func nearestCenter(centers [][3]float64, c [3]float64) int {
+	best, bestDist := 0, math.Inf(1)
+	for i, center := range centers {
+		dr, dg, db := c[0]-center[0], c[1]-center[1], c[2]-center[2]
+		if dist := dr*dr + dg*dg + db*db; dist < bestDist {
+			best, bestDist = i, dist
+		}
+	}
+	return best
+}
+
+func ValidateQuantizer(name string) error {
+	if _, found := quantizers[name]; !found {
+		return errors.New("quantizer has to be mediancut, octree or kmeans")
+	}
+	return nil
+}
+
+func getQuantizer(name string) quantizer {
+	if q, found := quantizers[name]; found {
+		return q
+	}
+	return medianCut
+}
+
+// builds the palette with the named quantizer, median cut if the name is empty
+func BuildPalette(imgs []image.Image, size int, quantizerName string) color.Palette {
+	colors, hasTransparency := countColors(imgs)
+	if hasTransparency {
+		size--
+	}
+
+	palette := getQuantizer(quantizerName)(colors, size)
+	if hasTransparency {
+		palette = append(palette, color.RGBA{})
+	}
+	if len(palette) == 0 {
+		palette = append(palette, color.RGBA{0, 0, 0, 0xff})
+	}
+
+	return palette
+}
+
+func Palettize(img image.Image, palette color.Palette, dither bool) *image.Paletted {
+	paletted := image.NewPaletted(img.Bounds(), palette)
+
+	if dither {
+		draw.FloydSteinberg.Draw(paletted, img.Bounds(), img, img.Bounds().Min)
+		return paletted
+	}
+
+	cache := map[color.RGBA64]uint8{}
+	bnds := img.Bounds()
+	for y := bnds.Min.Y; y < bnds.Max.Y; y++ {
+		for x := bnds.Min.X; x < bnds.Max.X; x++ {
+			r, g, b, a := img.At(x, y).RGBA()
+			c := color.RGBA64{uint16(r), uint16(g), uint16(b), uint16(a)}
+
+			idx, found := cache[c]
+			if !found {
+				idx = uint8(palette.Index(c))
+				cache[c] = idx
+			}
+			paletted.SetColorIndex(x, y, idx)
+		}
+	}
+
+	return paletted
+}
+
+// returns the palette of the visible pixels with the share of the pixels closest to every color, most common first
+func ExtractPalette(imgs []image.Image, size int, quantizerName string) []PaletteEntry {
+	colors, _ := countColors(imgs)
+	palette := getQuantizer(quantizerName)(colors, size)
+
+	counts := make([]int, len(palette))
+	total := 0
+	for _, c := range colors {
+		counts[palette.Index(color.RGBA{c.clr[0], c.clr[1], c.clr[2], 0xff})] += c.count
+		total += c.count
+	}
+
+	entries := make([]PaletteEntry, len(palette))
+	for i, c := range palette {
+		entries[i] = PaletteEntry{c.(color.RGBA), float64(counts[i]) / float64(total)}
+	}
+	slices.SortStableFunc(entries, func(a, b PaletteEntry) int {
+		return cmp.Compare(b.Proportion, a.Proportion)
+	})
+
+	return entries
+}
+
+func (entry PaletteEntry) Hex() string {
+	return fmt.Sprintf("#%02x%02x%02x", entry.Color.R, entry.Color.G, entry.Color.B)
+}
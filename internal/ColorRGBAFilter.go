@@ -0,0 +1,91 @@
+package internal
+
+import (
+	"image"
+	"image/draw"
+)
+
+// maps a normalized, non premultiplied rgb color to its adjusted color
+type ColorFunc func(r, g, b float64) (float64, float64, float64)
+
+// per-pixel color adjustment, unlike ToneFunc the channels depend on each other, so there is no lookup table
+type ColorRGBA64Filter struct {
+	fn ColorFunc
+}
+
+type ColorRGBAFilter struct {
+	fn ColorFunc
+}
+
+// builds the color adjustment for the rgb values of a working space
+type SpaceColorFunc func(conv *colorConverter) ColorFunc
+
+// color adjustment in a device independent space like lab, rebuilt whenever the working space changes
+type SpaceColorRGBA64Filter struct {
+	ColorRGBA64Filter
+	build SpaceColorFunc
+}
+
+type SpaceColorRGBAFilter struct {
+	ColorRGBAFilter
+	build SpaceColorFunc
+}
+
+func NewColorRGBA64Filter(fn ColorFunc) *ColorRGBA64Filter {
+	return &ColorRGBA64Filter{fn}
+}
+
+func NewColorRGBAFilter(fn ColorFunc) *ColorRGBAFilter {
+	return &ColorRGBAFilter{fn}
+}
+
+func NewSpaceColorRGBA64Filter(build SpaceColorFunc) *SpaceColorRGBA64Filter {
+	return &SpaceColorRGBA64Filter{ColorRGBA64Filter{build(newColorConverter(nil))}, build}
+}
+
+func NewSpaceColorRGBAFilter(build SpaceColorFunc) *SpaceColorRGBAFilter {
+	return &SpaceColorRGBAFilter{ColorRGBAFilter{build(newColorConverter(nil))}, build}
+}
+
+func applyColorFunc(fn ColorFunc, img, filteredImg draw.RGBA64Image, startY, endY int, prgrsCh chan int) {
+	if iter, err := NewImageIterator(img, NONE, startY, endY, prgrsCh); err == nil {
+		for iter.HasNext() {
+			curr := iter.Next()
+
+			c := img.RGBA64At(curr.X, curr.Y)
+			if c.A == 0 {
+				filteredImg.SetRGBA64(curr.X, curr.Y, c)
+				continue
+			}
+
+			r, g, b, a := unpremultiply(c)
+			r, g, b = fn(r, g, b)
+			scale := a * 0xffff
+			setResampledPixel(filteredImg, curr.X, curr.Y, clampUnit(r)*scale, clampUnit(g)*scale, clampUnit(b)*scale, scale)
+		}
+	}
+}
+
+func (filter *ColorRGBA64Filter) Apply(img, filteredImg *image.RGBA64, startY, endY int, prgrsCh chan int) {
+	applyColorFunc(filter.fn, img, filteredImg, startY, endY, prgrsCh)
+}
+
+func (filter *ColorRGBAFilter) Apply(img, filteredImg *image.RGBA, startY, endY int, prgrsCh chan int) {
+	applyColorFunc(filter.fn, img, filteredImg, startY, endY, prgrsCh)
+}
+
+func (filter *ColorRGBA64Filter) PixelFunc() ColorFunc {
+	return filter.fn
+}
+
+func (filter *ColorRGBAFilter) PixelFunc() ColorFunc {
+	return filter.fn
+}
+
+func (filter *SpaceColorRGBA64Filter) SetWorkingSpace(profile *ColorProfile) {
+	filter.fn = filter.build(newColorConverter(profile))
+}
+
+func (filter *SpaceColorRGBAFilter) SetWorkingSpace(profile *ColorProfile) {
+	filter.fn = filter.build(newColorConverter(profile))
+}
@@ -0,0 +1,163 @@
+package internal
+
+import (
+	"math"
+)
+
+// conversions of normalized, non premultiplied rgb values, hues are in degrees between 0 and 360,
+// lab and lch use the d50 adapted primaries of the working space like the icc profiles, with l between 0 and 100
+
+const (
+	LAB_EPSILON = 216.0 / 24389.0
+	LAB_KAPPA   = 24389.0 / 27.0
+)
+
+// converts the rgb values of a working space to linear rgb and cie lab
+type colorConverter struct {
+	toXYZ, fromXYZ colorMatrix
+	trc            [3]toneCurve
+}
+
+// a nil profile converts srgb values
+func newColorConverter(profile *ColorProfile) *colorConverter {
+	if profile == nil {
+		profile = ColorProfileSRGB
+	}
+	return &colorConverter{profile.toXYZ, profile.toXYZ.inverse(), profile.trc}
+}
+
+// returns the hue, the chroma (max - min) and the maximum and minimum of the channels
+func hueChroma(r, g, b float64) (h, c, maxC, minC float64) {
+	maxC, minC = max(r, g, b), min(r, g, b)
+	c = maxC - minC
+	if c == 0 {
+		return 0, 0, maxC, minC
+	}
+
+	switch maxC {
+	case r:
+		h = math.Mod((g-b)/c+6, 6)
+	case g:
+		h = (b-r)/c + 2
+	default:
+		h = (r-g)/c + 4
+	}
+	return h * 60, c, maxC, minC
+}
+
+// rgb of the fully saturated hue with chroma c, shifted by m
+func hueToRgb(h, c, m float64) (r, g, b float64) {
+	h = math.Mod(math.Mod(h, 360)+360, 360) / 60
+	x := c * (1 - math.Abs(math.Mod(h, 2)-1))
+
+	switch int(h) {
+	case 0:
+		r, g, b = c, x, 0
+	case 1:
+		r, g, b = x, c, 0
+	case 2:
+		r, g, b = 0, c, x
+	case 3:
+		r, g, b = 0, x, c
+	case 4:
+		r, g, b = x, 0, c
+	default:
+		r, g, b = c, 0, x
+	}
+	return r + m, g + m, b + m
+}
+
+func rgbToHsl(r, g, b float64) (h, s, l float64) {
+	h, c, maxC, minC := hueChroma(r, g, b)
+	l = (maxC + minC) / 2
+	if c > 0 {
+		s = c / (1 - math.Abs(2*l-1))
+	}
+	return h, s, l
+}
+
+func hslToRgb(h, s, l float64) (r, g, b float64) {
+	c := (1 - math.Abs(2*l-1)) * s
+	return hueToRgb(h, c, l-c/2)
+}
+
+func rgbToHsv(r, g, b float64) (h, s, v float64) {
+	h, c, maxC, _ := hueChroma(r, g, b)
+	if maxC > 0 {
+		s = c / maxC
+	}
+	return h, s, maxC
+}
+
+func labF(t float64) float64 {
+	if t > LAB_EPSILON {
+		return math.Cbrt(t)
+	}
+	return (LAB_KAPPA*t + 16) / 116
+}
+
+func labFInverse(t float64) float64 {
+	if t3 := t * t * t; t3 > LAB_EPSILON {
+		return t3
+	}
+	return (116*t - 16) / LAB_KAPPA
+}
+
+func (conv *colorConverter) toLinear(r, g, b float64) [3]float64 {
+	return [3]float64{conv.trc[0].toLinear(r), conv.trc[1].toLinear(g), conv.trc[2].toLinear(b)}
+}
+
+// linear values outside of the rgb gamut are clipped
+func (conv *colorConverter) fromLinear(rgb [3]float64) (r, g, b float64) {
+	return conv.trc[0].fromLinear(clampUnit(rgb[0])), conv.trc[1].fromLinear(clampUnit(rgb[1])), conv.trc[2].fromLinear(clampUnit(rgb[2]))
+}
+
+// the matrix of the working space for a d65 white, which the rgb spaces define their primaries with
+func (conv *colorConverter) toXYZD65() colorMatrix {
+	return chromaticAdaptation(whitePointD50, whitePointD65).mul(conv.toXYZ)
+}
+
+// relative luminance of linear rgb values
+func (conv *colorConverter) luminance(rgb [3]float64) float64 {
+	m := conv.toXYZD65()
+	return m[1][0]*rgb[0] + m[1][1]*rgb[1] + m[1][2]*rgb[2]
+}
+
+func (conv *colorConverter) rgbToLab(r, g, b float64) (l, a, bb float64) {
+	xyz := conv.toXYZ.apply(conv.toLinear(r, g, b))
+	fx, fy, fz := labF(xyz[0]/whitePointD50[0]), labF(xyz[1]/whitePointD50[1]), labF(xyz[2]/whitePointD50[2])
+	return 116*fy - 16, 500 * (fx - fy), 200 * (fy - fz)
+}
+
+// colors outside of the rgb gamut are clipped
+func (conv *colorConverter) labToRgb(l, a, bb float64) (r, g, b float64) {
+	fy := (l + 16) / 116
+	fx, fz := fy+a/500, fy-bb/200
+	return conv.fromLinear(conv.fromXYZ.apply([3]float64{labFInverse(fx) * whitePointD50[0], labFInverse(fy) * whitePointD50[1], labFInverse(fz) * whitePointD50[2]}))
+}
+
+func labToLch(l, a, b float64) (float64, float64, float64) {
+	h := math.Atan2(b, a) * 180 / math.Pi
+	if h < 0 {
+		h += 360
+	}
+	return l, math.Hypot(a, b), h
+}
+
+func lchToLab(l, c, h float64) (float64, float64, float64) {
+	rad := h * math.Pi / 180
+	return l, c * math.Cos(rad), c * math.Sin(rad)
+}
+
+// full range ycbcr with the luminance weights of calcIntensity, cb and cr are between -0.5 and 0.5
+func rgbToYCbCr(r, g, b float64) (y, cb, cr float64) {
+	y = luminance(r, g, b)
+	return y, (b - y) / (2 * (1 - INTENSITY_BLUE_FACTOR)), (r - y) / (2 * (1 - INTENSITY_RED_FACTOR))
+}
+
+func yCbCrToRgb(y, cb, cr float64) (r, g, b float64) {
+	r = y + 2*(1-INTENSITY_RED_FACTOR)*cr
+	b = y + 2*(1-INTENSITY_BLUE_FACTOR)*cb
+	g = (y - INTENSITY_RED_FACTOR*r - INTENSITY_BLUE_FACTOR*b) / INTENSITY_GREEN_FACTOR
+	return r, g, b
+}
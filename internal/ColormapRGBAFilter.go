@@ -0,0 +1,272 @@
+package internal
+
+import (
+	"errors"
+	"image"
+	"image/draw"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	COLORMAP_REVERSE = "reverse"
+	COLORMAP_STEPS   = "steps"
+	COLORMAP_LEGEND  = "legend"
+
+	// default legend height in parts of the image height
+	COLORMAP_LEGEND_SHARE      = 16
+	COLORMAP_LEGEND_MIN_HEIGHT = 8
+)
+
+// maps t between 0 and 1 to a normalized rgb color
+type colorGradient func(t float64) [3]float64
+
+// 6th degree polynomial fits of the matplotlib maps, coefficients by increasing power
+var colormapPolynomials = map[string][7][3]float64{
+	"viridis": {
+		{0.2777273272234177, 0.005407344544966578, 0.3340998053353061},
+		{0.1050930431085774, 1.404613529898575, 1.384590162594685},
+		{-0.3308618287255563, 0.214847559468213, 0.09509516302823659},
+		{-4.634230498983486, -5.799100973351585, -19.33244095627987},
+		{6.228269936347081, 14.17993336680509, 56.69055260068105},
+		{4.776384997670288, -13.74514537774601, -65.35303263337234},
+		{-5.435455855934631, 4.645852612178535, 26.3124352495832},
+	},
+	"magma": {
+		{-0.002136485053939582, -0.000749655052795221, -0.005386127855323933},
+		{0.2516605407371642, 0.6775232436837668, 2.494026599312351},
+		{8.353717279216625, -3.577719514958484, 0.3144679030132573},
+		{-27.66873308576866, 14.26473078096533, -13.64921318813922},
+		{52.17613981234068, -27.94360607168351, 12.94416944238394},
+		{-50.76852536473588, 29.04658282127291, 4.23415299384598},
+		{18.65570506591883, -11.48977351997711, -5.601961508734096},
+	},
+	"inferno": {
+		{0.0002189403691192265, 0.001651004631001012, -0.01948089843709184},
+		{0.1065134194856116, 0.5639564367884091, 3.932712388889277},
+		{11.60249308247187, -3.972853965665698, -15.9423941062914},
+		{-41.70399613139459, 17.43639888205313, 44.35414519872813},
+		{77.162935699427, -33.40235894210092, -81.80730925738993},
+		{-71.31942824499214, 32.62606426397723, 73.20951985803202},
+		{25.13112622477341, -12.24266895238567, -23.07032500287172},
+	},
+	// polynomial approximation of google's turbo map
+	"turbo": {
+		{0.13572138, 0.09140261, 0.10667330},
+		{4.61539260, 2.19418839, 12.64194608},
+		{-42.66032258, 4.84296658, -60.58204836},
+		{132.13108234, -14.18503333, 110.36276771},
+		{-152.94239396, 4.27729857, -89.90310912},
+		{59.28637943, 2.82956604, 27.34824973},
+		{0, 0, 0},
+	},
+}
+
+// gradients interpolating between evenly spaced color stops
+var colormapStops = map[string][]string{
+	"jet":       {"#00007f", "#0000ff", "#007fff", "#00ffff", "#7fff7f", "#ffff00", "#ff7f00", "#ff0000", "#7f0000"},
+	"grayscale": {"black", "white"},
+	"heat":      {"black", "#0000ff", "#00ffff", "#00ff00", "#ffff00", "#ff0000"},
+}
+
+// maps the luminance of every pixel to the color of a gradient, optionally quantized to steps bands,
+// the legend strip below the image shows the gradient from left (black) to right (white)
+type colormapParams struct {
+	gradient colorGradient
+	reverse  bool
+	steps    int
+	legend   bool
+	// 0 derives the legend height from the image height
+	legendHeight int
+	imageHeight  int
+}
+
+type ColormapRGBA64Filter struct {
+	colormapParams
+}
+
+type ColormapRGBAFilter struct {
+	colormapParams
+}
+
+func polynomialGradient(coeffs [7][3]float64) colorGradient {
+	return func(t float64) [3]float64 {
+		var c [3]float64
+		for ch := range c {
+			for i := len(coeffs) - 1; i >= 0; i-- {
+				c[ch] = c[ch]*t + coeffs[i][ch]
+			}
+			c[ch] = clampUnit(c[ch])
+		}
+		return c
+	}
+}
+
+// interpolates linearly between the stops, which are sorted by their positions
+func stopGradient(positions []float64, colors [][3]float64) colorGradient {
+	return func(t float64) [3]float64 {
+		if t <= positions[0] {
+			return colors[0]
+		}
+		for i := 1; i < len(positions); i++ {
+			if t <= positions[i] {
+				w := (t - positions[i-1]) / (positions[i] - positions[i-1])
+				var c [3]float64
+				for ch := range c {
+					c[ch] = colors[i-1][ch] + (colors[i][ch]-colors[i-1][ch])*w
+				}
+				return c
+			}
+		}
+		return colors[len(colors)-1]
+	}
+}
+
+// parses color stops, either all as colors, which spreads them evenly, or all as position:color with positions between 0 and 1
+func parseColorStops(args []string) (colorGradient, error) {
+	if len(args) < 2 {
+		return nil, errors.New("colormap gradient needs at least 2 color stops")
+	}
+
+	positions := make([]float64, len(args))
+	colors := make([][3]float64, len(args))
+	withPositions := strings.Contains(args[0], ":")
+
+	for i, arg := range args {
+		posStr, colorStr, found := strings.Cut(arg, ":")
+		if found != withPositions {
+			return nil, errors.New("colormap stops need to be either all colors or all position:color")
+		}
+
+		if found {
+			pos, err := strconv.ParseFloat(posStr, 64)
+			if err != nil || pos < 0 || pos > 1 || (i > 0 && pos <= positions[i-1]) {
+				return nil, errors.New("colormap stop positions need to be increasing floats between 0 and 1, got " + arg)
+			}
+			positions[i] = pos
+		} else {
+			colorStr = arg
+			positions[i] = float64(i) / float64(len(args)-1)
+		}
+
+		c, err := parseColor(colorStr)
+		if err != nil {
+			return nil, err
+		}
+		r, g, b, _ := unpremultiply(c)
+		colors[i] = [3]float64{r, g, b}
+	}
+
+	return stopGradient(positions, colors), nil
+}
+
+// parses [map name | color stops] [reverse] [steps count] [legend [height]], viridis by default
+func parseColormapParams(args []string) (colormapParams, error) {
+	params := colormapParams{gradient: polynomialGradient(colormapPolynomials["viridis"])}
+	var stops []string
+
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == COLORMAP_REVERSE:
+			params.reverse = true
+		case arg == COLORMAP_STEPS:
+			if i+1 >= len(args) {
+				return params, errors.New("steps needs the band count (int >= 2)")
+			}
+			steps, err := strconv.Atoi(args[i+1])
+			if err != nil || steps < 2 {
+				return params, errors.New("steps needs the band count (int >= 2)")
+			}
+			params.steps = steps
+			i++
+		case arg == COLORMAP_LEGEND:
+			params.legend = true
+			if i+1 < len(args) {
+				if height, err := strconv.Atoi(args[i+1]); err == nil {
+					if height < 1 {
+						return params, errors.New("legend height needs to be at least 1")
+					}
+					params.legendHeight = height
+					i++
+				}
+			}
+		default:
+			if coeffs, found := colormapPolynomials[arg]; found {
+				params.gradient = polynomialGradient(coeffs)
+			} else if named, found := colormapStops[arg]; found {
+				stops = named
+			} else {
+				stops = append(stops, arg)
+			}
+		}
+	}
+
+	if stops != nil {
+		gradient, err := parseColorStops(stops)
+		if err != nil {
+			return params, errors.New("arguments need to be viridis, magma, inferno, turbo, jet, grayscale, heat or color stops, followed by reverse, steps and legend: " + err.Error())
+		}
+		params.gradient = gradient
+	}
+
+	return params, nil
+}
+
+// the former heat filter, six hard bands from black over blue, cyan, green and yellow to red
+func newHeatColormap() colormapParams {
+	params, _ := parseColormapParams([]string{"heat", COLORMAP_STEPS, "6"})
+	return params
+}
+
+func (params *colormapParams) color(t float64) [3]float64 {
+	t = clampUnit(t)
+	if params.steps > 0 {
+		t = math.Min(math.Floor(t*float64(params.steps)), float64(params.steps-1)) / float64(params.steps-1)
+	}
+	if params.reverse {
+		t = 1 - t
+	}
+	return params.gradient(t)
+}
+
+func (params *colormapParams) OutputBounds(bnds image.Rectangle) image.Rectangle {
+	params.imageHeight = bnds.Dy()
+	if !params.legend {
+		return bnds
+	}
+
+	height := params.legendHeight
+	if height == 0 {
+		height = max(COLORMAP_LEGEND_MIN_HEIGHT, bnds.Dy()/COLORMAP_LEGEND_SHARE)
+	}
+	return image.Rect(bnds.Min.X, bnds.Min.Y, bnds.Max.X, bnds.Max.Y+height)
+}
+
+func (params *colormapParams) apply(img, filteredImg draw.RGBA64Image, startY, endY int, prgrsCh chan int) {
+	bnds := filteredImg.Bounds()
+	legendY := bnds.Min.Y + params.imageHeight
+
+	progress := newRowProgressReporter(startY, endY, prgrsCh)
+	for y := startY; y < endY; y++ {
+		for x := bnds.Min.X; x < bnds.Max.X; x++ {
+			if y >= legendY {
+				c := params.color(float64(x-bnds.Min.X) / float64(max(1, bnds.Dx()-1)))
+				setDitheredPixel(filteredImg, x, y, c, 1)
+				continue
+			}
+
+			r, g, b, a := unpremultiply(img.RGBA64At(x, y))
+			setDitheredPixel(filteredImg, x, y, params.color(luminance(r, g, b)), a)
+		}
+		progress.RowDone()
+	}
+}
+
+func (filter *ColormapRGBA64Filter) Apply(img, filteredImg *image.RGBA64, startY, endY int, prgrsCh chan int) {
+	filter.apply(img, filteredImg, startY, endY, prgrsCh)
+}
+
+func (filter *ColormapRGBAFilter) Apply(img, filteredImg *image.RGBA, startY, endY int, prgrsCh chan int) {
+	filter.apply(img, filteredImg, startY, endY, prgrsCh)
+}
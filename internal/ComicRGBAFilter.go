@@ -27,7 +27,7 @@ func (filter *ComicRGBA64Filter) Apply(img, filteredImg *image.RGBA64, startY, e
 		for iter.HasNext() {
 			curr := iter.Next()
 
-			clr := uint16(math.Floor(calcIntensity(curr.Self, 16)/filter.colorStepF))*filter.colorStep + filter.colorOffset
+			clr := uint16(comicBand(calcIntensity(curr.Self, 0), filter.colorStepF, 0xffff))*filter.colorStep + filter.colorOffset
 
 			filteredImg.SetRGBA64(curr.X, curr.Y, color.RGBA64{clr, clr, clr, 0xffff})
 		}
@@ -39,13 +39,19 @@ func (filter *ComicRGBAFilter) Apply(img, filteredImg *image.RGBA, startY, endY
 		for iter.HasNext() {
 			curr := iter.Next()
 
-			clr := uint8(math.Floor(calcIntensity(curr.Self, 8)/filter.colorStepF))*filter.colorStep + filter.colorOffset
+			clr := uint8(comicBand(calcIntensity(curr.Self, 8), filter.colorStepF, 0xff))*filter.colorStep + filter.colorOffset
 
 			filteredImg.SetRGBA(curr.X, curr.Y, color.RGBA{clr, clr, clr, 0xff})
 		}
 	}
 }
 
+// returns the band of the intensity, the brightest intensities join the last full band instead of overflowing
+func comicBand(intensity, colorStep, cMax float64) float64 {
+	return math.Min(math.Floor(intensity/colorStep), math.Max(0, math.Floor(cMax/colorStep)-1))
+}
+
+// intensity of the color with the channels shifted right by bitSize, 8 for 8 bit values and 0 for 16 bit values
 func calcIntensity(c *color.Color, bitSize uint32) float64 {
 	var r, g, b, _ = (*c).RGBA()
 	r >>= bitSize
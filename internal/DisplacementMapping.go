@@ -0,0 +1,206 @@
+package internal
+
+import (
+	"errors"
+	"image"
+	"math"
+	"strconv"
+)
+
+// size of the image and distance of the farthest image corner from the center the displacements are normalized to
+type displacementFrame struct {
+	width, height, maxRadius float64
+}
+
+// moves every target pixel to a source position relative to the center point, keeping the image size
+type displacementMapping struct {
+	centerX, centerY int
+	// maps the offset of a target position from the center to the offset of its source position
+	displace func(dx, dy float64, frame displacementFrame) (float64, float64, bool)
+}
+
+func (mapping *displacementMapping) OutputBounds(src image.Rectangle) image.Rectangle {
+	return image.Rect(0, 0, src.Dx(), src.Dy())
+}
+
+func (mapping *displacementMapping) Inverse(src, dst image.Rectangle) func(x, y float64) (float64, float64, bool) {
+	cx, cy := float64(mapping.centerX), float64(mapping.centerY)
+	w, h := float64(src.Dx()), float64(src.Dy())
+	frame := displacementFrame{w, h, math.Sqrt(math.Max(cx*cx, (w-cx)*(w-cx)) + math.Max(cy*cy, (h-cy)*(h-cy)))}
+
+	return func(x, y float64) (float64, float64, bool) {
+		dx, dy, ok := mapping.displace(x-float64(dst.Min.X)-cx, y-float64(dst.Min.Y)-cy, frame)
+		return dx + cx + float64(src.Min.X), dy + cy + float64(src.Min.Y), ok
+	}
+}
+
+// corrects radial lens distortion with the brown model r_src = r * (1 + k1*r^2 + k2*r^4),
+// r is normalized to the farthest image corner, negative k1 corrects barrel, positive k1 pincushion distortion
+func NewLensMapping(centerX, centerY int, k1, k2 float64) InverseMapping {
+	return &displacementMapping{centerX, centerY, func(dx, dy float64, frame displacementFrame) (float64, float64, bool) {
+		r2 := (dx*dx + dy*dy) / (frame.maxRadius * frame.maxRadius)
+		f := 1 + k1*r2 + k2*r2*r2
+		return dx * f, dy * f, true
+	}}
+}
+
+// rotates the pixels inside radius by up to degrees, the rotation fades out towards the radius
+func NewSwirlMapping(centerX, centerY int, radius, degrees float64) InverseMapping {
+	angle := degrees * math.Pi / 180
+
+	return &displacementMapping{centerX, centerY, func(dx, dy float64, frame displacementFrame) (float64, float64, bool) {
+		d := math.Sqrt(dx*dx + dy*dy)
+		if d >= radius {
+			return dx, dy, true
+		}
+
+		sin, cos := math.Sincos(angle * (1 - d/radius) * (1 - d/radius))
+		return dx*cos - dy*sin, dx*sin + dy*cos, true
+	}}
+}
+
+// moves the pixels along concentric waves of the given amplitude and wavelength in pixels
+func NewRippleMapping(centerX, centerY int, amplitude, wavelength float64) InverseMapping {
+	return &displacementMapping{centerX, centerY, func(dx, dy float64, frame displacementFrame) (float64, float64, bool) {
+		d := math.Sqrt(dx*dx + dy*dy)
+		if d == 0 {
+			return dx, dy, true
+		}
+
+		f := (d + amplitude*math.Sin(2*math.Pi*d/wavelength)) / d
+		return dx * f, dy * f, true
+	}}
+}
+
+// magnifies the pixels inside radius, strength 0 keeps the image unchanged
+func NewFisheyeMapping(centerX, centerY int, radius, strength float64) InverseMapping {
+	return &displacementMapping{centerX, centerY, func(dx, dy float64, frame displacementFrame) (float64, float64, bool) {
+		d := math.Sqrt(dx*dx + dy*dy)
+		if d >= radius || d == 0 {
+			return dx, dy, true
+		}
+
+		f := math.Pow(d/radius, strength)
+		return dx * f, dy * f, true
+	}}
+}
+
+// unrolls the image around the center, x becomes the clockwise angle starting at 3 o'clock and y the distance,
+// inverse rolls an unrolled image back up
+func NewPolarMapping(centerX, centerY int, inverse bool) InverseMapping {
+	cx, cy := float64(centerX), float64(centerY)
+
+	if inverse {
+		return &displacementMapping{centerX, centerY, func(dx, dy float64, frame displacementFrame) (float64, float64, bool) {
+			angle := math.Atan2(dy, dx)
+			if angle < 0 {
+				angle += 2 * math.Pi
+			}
+			d := math.Sqrt(dx*dx + dy*dy)
+			return angle/(2*math.Pi)*frame.width - cx, d/frame.maxRadius*frame.height - cy, true
+		}}
+	}
+
+	return &displacementMapping{centerX, centerY, func(dx, dy float64, frame displacementFrame) (float64, float64, bool) {
+		sin, cos := math.Sincos((dx + cx) / frame.width * 2 * math.Pi)
+		d := (dy + cy) / frame.height * frame.maxRadius
+		return d * cos, d * sin, true
+	}}
+}
+
+// parses the center point and count float parameters, the remaining arguments are returned
+func parseDisplacementArgs(args []string, count int, usage string) (int, int, []float64, []string, error) {
+	if len(args) < 2+count {
+		return 0, 0, nil, nil, errors.New(usage)
+	}
+
+	centerX, errX := strconv.Atoi(args[0])
+	centerY, errY := strconv.Atoi(args[1])
+	if errX != nil || errY != nil {
+		return 0, 0, nil, nil, errors.New(usage)
+	}
+
+	vals := make([]float64, count)
+	for i := range vals {
+		v, err := strconv.ParseFloat(args[2+i], 64)
+		if err != nil {
+			return 0, 0, nil, nil, errors.New(usage)
+		}
+		vals[i] = v
+	}
+
+	return centerX, centerY, vals, args[2+count:], nil
+}
+
+func parseLensParams(args []string) (inverseMapParams, error) {
+	centerX, centerY, vals, rest, err := parseDisplacementArgs(args, 1, "filter needs the center x, y (int, int) and k1 (float) as non-flag arguments, optionally followed by k2 (float), the interpolation and a background color")
+	if err != nil {
+		return inverseMapParams{}, err
+	}
+
+	k2 := 0.0
+	if len(rest) >= 1 {
+		if v, err := strconv.ParseFloat(rest[0], 64); err == nil {
+			k2, rest = v, rest[1:]
+		}
+	}
+
+	return parseSamplingArgs(rest, NewLensMapping(centerX, centerY, vals[0], k2), BilinearKernel)
+}
+
+func parseSwirlParams(args []string) (inverseMapParams, error) {
+	centerX, centerY, vals, rest, err := parseDisplacementArgs(args, 2, "filter needs the center x, y (int, int), a radius and an angle in degrees (float, float) as non-flag arguments, optionally followed by the interpolation and a background color")
+	if err != nil {
+		return inverseMapParams{}, err
+	}
+	if vals[0] <= 0 {
+		return inverseMapParams{}, errors.New("swirl radius needs to be greater than 0")
+	}
+
+	return parseSamplingArgs(rest, NewSwirlMapping(centerX, centerY, vals[0], vals[1]), BilinearKernel)
+}
+
+func parseRippleParams(args []string) (inverseMapParams, error) {
+	centerX, centerY, vals, rest, err := parseDisplacementArgs(args, 2, "filter needs the center x, y (int, int), an amplitude and a wavelength in pixels (float, float) as non-flag arguments, optionally followed by the interpolation and a background color")
+	if err != nil {
+		return inverseMapParams{}, err
+	}
+	if vals[1] <= 0 {
+		return inverseMapParams{}, errors.New("ripple wavelength needs to be greater than 0")
+	}
+
+	return parseSamplingArgs(rest, NewRippleMapping(centerX, centerY, vals[0], vals[1]), BilinearKernel)
+}
+
+func parseFisheyeParams(args []string) (inverseMapParams, error) {
+	centerX, centerY, vals, rest, err := parseDisplacementArgs(args, 1, "filter needs the center x, y (int, int) and a radius (float) as non-flag arguments, optionally followed by the strength (float), the interpolation and a background color")
+	if err != nil {
+		return inverseMapParams{}, err
+	}
+	if vals[0] <= 0 {
+		return inverseMapParams{}, errors.New("fisheye radius needs to be greater than 0")
+	}
+
+	strength := 0.5
+	if len(rest) >= 1 {
+		if v, err := strconv.ParseFloat(rest[0], 64); err == nil {
+			strength, rest = v, rest[1:]
+		}
+	}
+
+	return parseSamplingArgs(rest, NewFisheyeMapping(centerX, centerY, vals[0], strength), BilinearKernel)
+}
+
+func parsePolarParams(args []string) (inverseMapParams, error) {
+	centerX, centerY, _, rest, err := parseDisplacementArgs(args, 0, "filter needs the center x, y (int, int) as non-flag arguments, optionally followed by inverse, the interpolation and a background color")
+	if err != nil {
+		return inverseMapParams{}, err
+	}
+
+	inverse := len(rest) >= 1 && rest[0] == "inverse"
+	if inverse {
+		rest = rest[1:]
+	}
+
+	return parseSamplingArgs(rest, NewPolarMapping(centerX, centerY, inverse), BilinearKernel)
+}
@@ -0,0 +1,157 @@
+package internal
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+)
+
+const (
+	BAYER_MATRIX_SIZE      = 8
+	BLUE_NOISE_MATRIX_SIZE = 32
+
+	// share of the initial points and spread of the energy function of the void and cluster method
+	BLUE_NOISE_INITIAL_SHARE = 0.1
+	BLUE_NOISE_SIGMA         = 1.5
+)
+
+// thresholds in [0, 1) tiled over the image by ordered dithering
+type ditherMatrix struct {
+	size       int
+	thresholds []float64
+}
+
+var (
+	blueNoiseMatrix     ditherMatrix
+	blueNoiseMatrixOnce sync.Once
+)
+
+func (matrix *ditherMatrix) threshold(x, y int) float64 {
+	return matrix.thresholds[(y&(matrix.size-1))*matrix.size+(x&(matrix.size-1))]
+}
+
+// builds the bayer matrix of a power of two size recursively from the 2x2 matrix
+func newBayerMatrix(size int) ditherMatrix {
+	ranks := []int{0}
+	for n := 1; n < size; n *= 2 {
+		next := make([]int, 4*n*n)
+		for y := range n {
+			for x := range n {
+				r := ranks[y*n+x] * 4
+				next[y*2*n+x] = r
+				next[y*2*n+x+n] = r + 2
+				next[(y+n)*2*n+x] = r + 3
+				next[(y+n)*2*n+x+n] = r + 1
+			}
+		}
+		ranks = next
+	}
+
+	return rankedMatrix(size, ranks)
+}
+
+func rankedMatrix(size int, ranks []int) ditherMatrix {
+	thresholds := make([]float64, len(ranks))
+	for i, r := range ranks {
+		thresholds[i] = (float64(r) + 0.5) / float64(len(ranks))
+	}
+	return ditherMatrix{size, thresholds}
+}
+
+// the blue noise matrix is generated once with the void and cluster method
+func getBlueNoiseMatrix() *ditherMatrix {
+	blueNoiseMatrixOnce.Do(func() {
+		blueNoiseMatrix = newBlueNoiseMatrix(BLUE_NOISE_MATRIX_SIZE)
+	})
+	return &blueNoiseMatrix
+}
+
+// the energy of a pixel sums a gaussian of its wrapped distance to all set pixels,
+// points are ranked by repeatedly removing the tightest cluster and filling the largest void
+type voidAndCluster struct {
+	size   int
+	points []bool
+	energy []float64
+	kernel []float64
+}
+
+func newBlueNoiseMatrix(size int) ditherMatrix {
+	vc := &voidAndCluster{size, make([]bool, size*size), make([]float64, size*size), make([]float64, size*size)}
+	for dy := range size {
+		for dx := range size {
+			wx, wy := float64(min(dx, size-dx)), float64(min(dy, size-dy))
+			vc.kernel[dy*size+dx] = math.Exp(-(wx*wx + wy*wy) / (2 * BLUE_NOISE_SIGMA * BLUE_NOISE_SIGMA))
+		}
+	}
+
+	// fixed seed, the matrix is the same for every run
+	rnd := rand.New(rand.NewSource(1))
+	initial := int(float64(size*size) * BLUE_NOISE_INITIAL_SHARE)
+	for count := 0; count < initial; {
+		if i := rnd.Intn(size * size); !vc.points[i] {
+			vc.toggle(i)
+			count++
+		}
+	}
+
+	// spreads the initial points evenly
+	for {
+		cluster := vc.extreme(true)
+		vc.toggle(cluster)
+		void := vc.extreme(false)
+		if void == cluster {
+			vc.toggle(cluster)
+			break
+		}
+		vc.toggle(void)
+	}
+
+	ranks := make([]int, size*size)
+	initialPoints := append([]bool(nil), vc.points...)
+	initialEnergy := append([]float64(nil), vc.energy...)
+
+	for r := initial - 1; r >= 0; r-- {
+		cluster := vc.extreme(true)
+		vc.toggle(cluster)
+		ranks[cluster] = r
+	}
+
+	vc.points, vc.energy = initialPoints, initialEnergy
+	for r := initial; r < size*size; r++ {
+		void := vc.extreme(false)
+		vc.toggle(void)
+		ranks[void] = r
+	}
+
+	return rankedMatrix(size, ranks)
+}
+
+func (vc *voidAndCluster) toggle(i int) {
+	sign := 1.0
+	if vc.points[i] {
+		sign = -1
+	}
+	vc.points[i] = !vc.points[i]
+
+	px, py := i%vc.size, i/vc.size
+	for y := range vc.size {
+		for x := range vc.size {
+			dx, dy := (x-px+vc.size)%vc.size, (y-py+vc.size)%vc.size
+			vc.energy[y*vc.size+x] += sign * vc.kernel[dy*vc.size+dx]
+		}
+	}
+}
+
+// returns the set pixel with the highest energy (tightest cluster) or the empty pixel with the lowest energy (largest void)
+func (vc *voidAndCluster) extreme(cluster bool) int {
+	best := -1
+	for i, set := range vc.points {
+		if set != cluster {
+			continue
+		}
+		if best < 0 || (cluster && vc.energy[i] > vc.energy[best]) || (!cluster && vc.energy[i] < vc.energy[best]) {
+			best = i
+		}
+	}
+	return best
+}
@@ -0,0 +1,334 @@
+package internal
+
+import (
+	"errors"
+	"image"
+	"image/draw"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	DITHER_FLOYD     = "floyd"
+	DITHER_ATKINSON  = "atkinson"
+	DITHER_JARVIS    = "jarvis"
+	DITHER_SIERRA    = "sierra"
+	DITHER_BAYER     = "bayer"
+	DITHER_BLUENOISE = "bluenoise"
+)
+
+// spreads the quantization error of a pixel to the pixels right of and below it
+type diffusionKernel struct {
+	taps    [][3]int
+	divisor float64
+}
+
+var diffusionKernels = map[string]diffusionKernel{
+	DITHER_FLOYD: {[][3]int{
+		{1, 0, 7},
+		{-1, 1, 3}, {0, 1, 5}, {1, 1, 1},
+	}, 16},
+	DITHER_ATKINSON: {[][3]int{
+		{1, 0, 1}, {2, 0, 1},
+		{-1, 1, 1}, {0, 1, 1}, {1, 1, 1},
+		{0, 2, 1},
+	}, 8},
+	DITHER_JARVIS: {[][3]int{
+		{1, 0, 7}, {2, 0, 5},
+		{-2, 1, 3}, {-1, 1, 5}, {0, 1, 7}, {1, 1, 5}, {2, 1, 3},
+		{-2, 2, 1}, {-1, 2, 3}, {0, 2, 5}, {1, 2, 3}, {2, 2, 1},
+	}, 48},
+	DITHER_SIERRA: {[][3]int{
+		{1, 0, 5}, {2, 0, 3},
+		{-2, 1, 2}, {-1, 1, 4}, {0, 1, 5}, {1, 1, 4}, {2, 1, 2},
+		{-1, 2, 2}, {0, 2, 3}, {1, 2, 2},
+	}, 32},
+}
+
+// quantizes the non premultiplied colors either to levels values per channel or to the nearest palette color
+type ditherQuantizer struct {
+	levels  int
+	palette [][3]float64
+}
+
+// reduces the colors with error diffusion, every pixel passes its quantization error on to its neighbors
+type ditherParams struct {
+	ditherQuantizer
+	kernel diffusionKernel
+	// quantization errors of every channel of every pixel
+	errs   []float32
+	width  int
+	serial *ditherSerialPass
+}
+
+// guards the serial fallback, the first band of a pass diffuses the whole image
+// and the other bands of the pass only report their progress
+type ditherSerialPass struct {
+	mu sync.Mutex
+	// rows of the current pass no band has claimed yet
+	pending int
+}
+
+type DitherRGBA64Filter struct {
+	ditherParams
+}
+
+type DitherRGBAFilter struct {
+	ditherParams
+}
+
+// reduces the colors with ordered dithering, every pixel is offset by the threshold of its position in the matrix,
+// without a matrix every pixel is just quantized
+type orderedDitherParams struct {
+	ditherQuantizer
+	matrix *ditherMatrix
+}
+
+type OrderedDitherRGBA64Filter struct {
+	orderedDitherParams
+}
+
+type OrderedDitherRGBAFilter struct {
+	orderedDitherParams
+}
+
+// parses floyd, atkinson, jarvis, sierra, bayer or bluenoise followed by the levels per channel or palette colors
+func parseDitherArgs(args []string) (string, ditherQuantizer, error) {
+	quantizer := ditherQuantizer{levels: 2}
+	if len(args) == 0 {
+		return "", quantizer, errors.New("filter needs the method floyd, atkinson, jarvis, sierra, bayer or bluenoise, optionally followed by the levels per channel (int >= 2) or palette colors (#rrggbb, ...)")
+	}
+
+	method := args[0]
+	if _, found := diffusionKernels[method]; !found && method != DITHER_BAYER && method != DITHER_BLUENOISE {
+		return "", quantizer, errors.New("first non-flag argument needs to be floyd, atkinson, jarvis, sierra, bayer or bluenoise")
+	}
+	if len(args) < 2 {
+		return method, quantizer, nil
+	}
+
+	if levels, err := strconv.Atoi(args[1]); err == nil {
+		if levels < 2 || levels > 0x10000 {
+			return method, quantizer, errors.New("dither levels per channel need to be between 2 and 65536")
+		}
+		quantizer.levels = levels
+		return method, quantizer, nil
+	}
+
+	for _, arg := range args[1:] {
+		for _, s := range strings.Split(arg, ",") {
+			if s == "" {
+				continue
+			}
+			c, err := parseColor(s)
+			if err != nil {
+				return method, quantizer, err
+			}
+			r, g, b, _ := unpremultiply(c)
+			quantizer.palette = append(quantizer.palette, [3]float64{r, g, b})
+		}
+	}
+	if len(quantizer.palette) < 2 {
+		return method, quantizer, errors.New("dither palette needs at least 2 colors")
+	}
+
+	return method, quantizer, nil
+}
+
+func parseDitherParams(args []string) (ditherParams, error) {
+	method, quantizer, err := parseDitherArgs(args)
+	return ditherParams{ditherQuantizer: quantizer, kernel: diffusionKernels[method], serial: &ditherSerialPass{}}, err
+}
+
+func parseOrderedDitherParams(args []string) (orderedDitherParams, error) {
+	method, quantizer, err := parseDitherArgs(args)
+	return orderedDitherParams{quantizer, orderedDitherMatrix(method)}, err
+}
+
+// returns the threshold matrix of bayer or bluenoise, nil for all other methods
+func orderedDitherMatrix(method string) *ditherMatrix {
+	switch method {
+	case DITHER_BAYER:
+		bayer := newBayerMatrix(BAYER_MATRIX_SIZE)
+		return &bayer
+	case DITHER_BLUENOISE:
+		return getBlueNoiseMatrix()
+	default:
+		return nil
+	}
+}
+
+// returns if the dither method diffuses the quantization error
+func isErrorDiffusion(args []string) bool {
+	if len(args) == 0 {
+		return true
+	}
+	_, found := diffusionKernels[args[0]]
+	return found
+}
+
+func (quantizer *ditherQuantizer) quantize(c [3]float64) [3]float64 {
+	if quantizer.palette == nil {
+		steps := float64(quantizer.levels - 1)
+		for ch, v := range c {
+			c[ch] = math.Round(clampUnit(v)*steps) / steps
+		}
+		return c
+	}
+
+	best, bestDist := 0, math.Inf(1)
+	for i, p := range quantizer.palette {
+		dr, dg, db := c[0]-p[0], c[1]-p[1], c[2]-p[2]
+		if dist := dr*dr + dg*dg + db*db; dist < bestDist {
+			best, bestDist = i, dist
+		}
+	}
+	return quantizer.palette[best]
+}
+
+// distance between neighboring output values, which ordered dithering spreads the thresholds over
+func (quantizer *ditherQuantizer) spread() float64 {
+	if quantizer.palette == nil {
+		return 1 / float64(quantizer.levels-1)
+	}
+	return math.Min(1, 1/(math.Cbrt(float64(len(quantizer.palette)))-1))
+}
+
+func setDitheredPixel(img draw.RGBA64Image, x, y int, c [3]float64, a float64) {
+	scale := a * 0xffff
+	setResampledPixel(img, x, y, c[0]*scale, c[1]*scale, c[2]*scale, scale)
+}
+
+// the error can travel as far right as the kernel reaches, so the row above has to be twice that plus one pixel ahead
+func (params *ditherParams) Lag() int {
+	reach := 0
+	for _, tap := range params.kernel.taps {
+		reach = max(reach, tap[0], -tap[0])
+	}
+	return 2*reach + 1
+}
+
+func (params *ditherParams) begin(img draw.RGBA64Image) {
+	bnds := img.Bounds()
+	params.width = bnds.Dx()
+	params.errs = make([]float32, bnds.Dx()*bnds.Dy()*3)
+}
+
+func (params *ditherParams) applyRow(img, filteredImg draw.RGBA64Image, y int, sync func(x int)) {
+	bnds := img.Bounds()
+	row := y - bnds.Min.Y
+
+	for x := bnds.Min.X; x < bnds.Max.X; x++ {
+		sync(x - bnds.Min.X)
+
+		r, g, b, a := unpremultiply(img.RGBA64At(x, y))
+		if a == 0 {
+			filteredImg.SetRGBA64(x, y, img.RGBA64At(x, y))
+			continue
+		}
+
+		col := x - bnds.Min.X
+		i := (row*params.width + col) * 3
+		c := [3]float64{
+			clampUnit(r + float64(params.errs[i])),
+			clampUnit(g + float64(params.errs[i+1])),
+			clampUnit(b + float64(params.errs[i+2])),
+		}
+		quantized := params.quantize(c)
+		setDitheredPixel(filteredImg, x, y, quantized, a)
+
+		for _, tap := range params.kernel.taps {
+			tx, ty := col+tap[0], row+tap[1]
+			if tx < 0 || tx >= params.width || ty >= bnds.Dy() {
+				continue
+			}
+
+			weight := float64(tap[2]) / params.kernel.divisor
+			j := (ty*params.width + tx) * 3
+			for ch := range c {
+				params.errs[j+ch] += float32((c[ch] - quantized[ch]) * weight)
+			}
+		}
+	}
+}
+
+// serial fallback for callers applying the filter in row bands, the error of a row reaches the rows below it,
+// so the first band of a pass diffuses the whole image one row after another while the other bands wait
+func (params *ditherParams) apply(img, filteredImg draw.RGBA64Image, startY, endY int, prgrsCh chan int) {
+	bnds := img.Bounds()
+	params.serial.mu.Lock()
+	defer params.serial.mu.Unlock()
+
+	progress := newRowProgressReporter(startY, endY, prgrsCh)
+	if params.serial.pending <= 0 {
+		params.begin(img)
+		params.serial.pending = bnds.Dy()
+		for y := bnds.Min.Y; y < bnds.Max.Y; y++ {
+			params.applyRow(img, filteredImg, y, func(x int) {})
+			if y >= startY && y < endY {
+				progress.RowDone()
+			}
+		}
+	} else {
+		for range endY - startY {
+			progress.RowDone()
+		}
+	}
+	params.serial.pending -= endY - startY
+}
+
+func (filter *DitherRGBA64Filter) Begin(img *image.RGBA64) {
+	filter.begin(img)
+}
+
+func (filter *DitherRGBAFilter) Begin(img *image.RGBA) {
+	filter.begin(img)
+}
+
+func (filter *DitherRGBA64Filter) ApplyRow(img, filteredImg *image.RGBA64, y int, sync func(x int)) {
+	filter.applyRow(img, filteredImg, y, sync)
+}
+
+func (filter *DitherRGBAFilter) ApplyRow(img, filteredImg *image.RGBA, y int, sync func(x int)) {
+	filter.applyRow(img, filteredImg, y, sync)
+}
+
+func (filter *DitherRGBA64Filter) Apply(img, filteredImg *image.RGBA64, startY, endY int, prgrsCh chan int) {
+	filter.apply(img, filteredImg, startY, endY, prgrsCh)
+}
+
+func (filter *DitherRGBAFilter) Apply(img, filteredImg *image.RGBA, startY, endY int, prgrsCh chan int) {
+	filter.apply(img, filteredImg, startY, endY, prgrsCh)
+}
+
+func (params *orderedDitherParams) apply(img, filteredImg draw.RGBA64Image, startY, endY int, prgrsCh chan int) {
+	spread := params.spread()
+
+	if iter, err := NewImageIterator(img, NONE, startY, endY, prgrsCh); err == nil {
+		for iter.HasNext() {
+			curr := iter.Next()
+
+			r, g, b, a := unpremultiply(img.RGBA64At(curr.X, curr.Y))
+			if a == 0 {
+				filteredImg.SetRGBA64(curr.X, curr.Y, img.RGBA64At(curr.X, curr.Y))
+				continue
+			}
+
+			var offset float64
+			if params.matrix != nil {
+				offset = (params.matrix.threshold(curr.X, curr.Y) - 0.5) * spread
+			}
+			setDitheredPixel(filteredImg, curr.X, curr.Y, params.quantize([3]float64{r + offset, g + offset, b + offset}), a)
+		}
+	}
+}
+
+func (filter *OrderedDitherRGBA64Filter) Apply(img, filteredImg *image.RGBA64, startY, endY int, prgrsCh chan int) {
+	filter.apply(img, filteredImg, startY, endY, prgrsCh)
+}
+
+func (filter *OrderedDitherRGBAFilter) Apply(img, filteredImg *image.RGBA, startY, endY int, prgrsCh chan int) {
+	filter.apply(img, filteredImg, startY, endY, prgrsCh)
+}
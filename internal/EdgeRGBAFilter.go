@@ -19,14 +19,14 @@ func (filter *EdgeRGBA64Filter) Apply(img, filteredImg *image.RGBA64, startY, en
 			curr := iter.Next()
 
 			var iv, ih int64
-			addIntensity(&iv, curr.North, 16)
-			subIntensity(&iv, curr.South, 16)
+			addIntensity(&iv, curr.North, 0)
+			subIntensity(&iv, curr.South, 0)
 			if iv < 0 {
 				iv = -iv
 			}
 
-			addIntensity(&ih, curr.West, 16)
-			subIntensity(&ih, curr.East, 16)
+			addIntensity(&ih, curr.West, 0)
+			subIntensity(&ih, curr.East, 0)
 			if ih < 0 {
 				ih = -ih
 			}
@@ -34,9 +34,9 @@ func (filter *EdgeRGBA64Filter) Apply(img, filteredImg *image.RGBA64, startY, en
 			iv = min((iv+ih)*filter.amp, 0xffff)
 
 			filteredImg.SetRGBA64(curr.X, curr.Y, color.RGBA64{
-				uint16(iv >> 16),
-				uint16(iv >> 16),
-				uint16(iv >> 16),
+				uint16(iv),
+				uint16(iv),
+				uint16(iv),
 				0xffff,
 			})
 		}
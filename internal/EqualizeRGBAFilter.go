@@ -0,0 +1,313 @@
+package internal
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"strconv"
+)
+
+const (
+	CLAHE_BINS = 256
+)
+
+// global histogram equalization of the luminance
+type equalizeParams struct {
+	bins int
+	// maps the luminance bins to their equalized luminance
+	mapping []float64
+}
+
+type EqualizeRGBA64Filter struct {
+	equalizeParams
+}
+
+type EqualizeRGBAFilter struct {
+	equalizeParams
+}
+
+// contrast limited adaptive histogram equalization, every tile of the grid is equalized on its own
+// with the bin counts clipped at clipLimit times the average, pixels interpolate between the four nearest tiles
+type claheParams struct {
+	clipLimit    float64
+	gridX, gridY int
+	// luminance mappings of the tiles, row by row
+	tiles [][]float64
+}
+
+type ClaheRGBA64Filter struct {
+	claheParams
+}
+
+type ClaheRGBAFilter struct {
+	claheParams
+}
+
+func parseClaheParams(args []string) (claheParams, error) {
+	params := claheParams{2, 8, 8, nil}
+	usage := errors.New("filter takes the clip limit (float > 0) and the grid size x, y (int, int) as optional non-flag arguments")
+
+	if len(args) >= 1 {
+		clipLimit, err := strconv.ParseFloat(args[0], 64)
+		if err != nil || clipLimit <= 0 {
+			return params, usage
+		}
+		params.clipLimit = clipLimit
+	}
+	if len(args) >= 2 {
+		gridX, err := strconv.Atoi(args[1])
+		if err != nil || gridX < 1 {
+			return params, usage
+		}
+		params.gridX, params.gridY = gridX, gridX
+	}
+	if len(args) >= 3 {
+		gridY, err := strconv.Atoi(args[2])
+		if err != nil || gridY < 1 {
+			return params, usage
+		}
+		params.gridY = gridY
+	}
+
+	return params, nil
+}
+
+// returns the non premultiplied channels and the alpha normalized to [0, 1]
+func unpremultiply(c color.RGBA64) (r, g, b, a float64) {
+	if c.A == 0 {
+		return 0, 0, 0, 0
+	}
+	a = float64(c.A)
+	return float64(c.R) / a, float64(c.G) / a, float64(c.B) / a, a / 0xffff
+}
+
+// luminance with the same weights as calcIntensity
+func luminance(r, g, b float64) float64 {
+	return r*INTENSITY_RED_FACTOR + g*INTENSITY_GREEN_FACTOR + b*INTENSITY_BLUE_FACTOR
+}
+
+func luminanceBin(lum float64, bins int) int {
+	return clampInt(int(math.Round(lum*float64(bins-1))), 0, bins-1)
+}
+
+// counts the luminance of the visible pixels of rect
+func luminanceHistogram(img draw.RGBA64Image, rect image.Rectangle, bins int) []float64 {
+	hist := make([]float64, bins)
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			r, g, b, a := unpremultiply(img.RGBA64At(x, y))
+			if a > 0 {
+				hist[luminanceBin(luminance(r, g, b), bins)]++
+			}
+		}
+	}
+	return hist
+}
+
+// sets the luminance of the pixel to lum by shifting all channels, which keeps the chroma unchanged
+func setLuminance(img draw.RGBA64Image, x, y int, c color.RGBA64, lum func(float64) float64) {
+	r, g, b, a := unpremultiply(c)
+	if a == 0 {
+		img.SetRGBA64(x, y, c)
+		return
+	}
+
+	shift := lum(luminance(r, g, b)) - luminance(r, g, b)
+	scale := a * 0xffff
+	setResampledPixel(img, x, y, clampUnit(r+shift)*scale, clampUnit(g+shift)*scale, clampUnit(b+shift)*scale, scale)
+}
+
+func (params *equalizeParams) analyze(img draw.RGBA64Image, startY, endY int) interface{} {
+	bnds := img.Bounds()
+	return luminanceHistogram(img, image.Rect(bnds.Min.X, startY, bnds.Max.X, endY), params.bins)
+}
+
+func (params *equalizeParams) Merge(a, b interface{}) interface{} {
+	return addHistograms(a.([]float64), b.([]float64))
+}
+
+func addHistograms(a, b []float64) []float64 {
+	for i := range a {
+		a[i] += b[i]
+	}
+	return a
+}
+
+func (params *equalizeParams) SetStats(stats interface{}) {
+	hist := stats.([]float64)
+
+	params.mapping = make([]float64, params.bins)
+	var cdf, cdfMin, total float64
+	for _, count := range hist {
+		total += count
+	}
+
+	for i, count := range hist {
+		cdf += count
+		if cdfMin == 0 {
+			cdfMin = cdf
+		}
+		if total > cdfMin {
+			params.mapping[i] = math.Max(0, (cdf-cdfMin)/(total-cdfMin))
+		} else {
+			params.mapping[i] = float64(i) / float64(params.bins-1)
+		}
+	}
+}
+
+func (params *equalizeParams) apply(img, filteredImg draw.RGBA64Image, startY, endY int, prgrsCh chan int) {
+	if iter, err := NewImageIterator(img, NONE, startY, endY, prgrsCh); err == nil {
+		for iter.HasNext() {
+			curr := iter.Next()
+
+			setLuminance(filteredImg, curr.X, curr.Y, img.RGBA64At(curr.X, curr.Y), func(lum float64) float64 {
+				return params.mapping[luminanceBin(lum, params.bins)]
+			})
+		}
+	}
+}
+
+func (filter *EqualizeRGBA64Filter) Analyze(img *image.RGBA64, startY, endY int) interface{} {
+	return filter.analyze(img, startY, endY)
+}
+
+func (filter *EqualizeRGBAFilter) Analyze(img *image.RGBA, startY, endY int) interface{} {
+	return filter.analyze(img, startY, endY)
+}
+
+func (filter *EqualizeRGBA64Filter) Apply(img, filteredImg *image.RGBA64, startY, endY int, prgrsCh chan int) {
+	filter.apply(img, filteredImg, startY, endY, prgrsCh)
+}
+
+func (filter *EqualizeRGBAFilter) Apply(img, filteredImg *image.RGBA, startY, endY int, prgrsCh chan int) {
+	filter.apply(img, filteredImg, startY, endY, prgrsCh)
+}
+
+// returns the pixel rectangle of tile tx, ty
+func (params *claheParams) tileRect(bnds image.Rectangle, tx, ty int) image.Rectangle {
+	return image.Rect(
+		bnds.Min.X+tx*bnds.Dx()/params.gridX, bnds.Min.Y+ty*bnds.Dy()/params.gridY,
+		bnds.Min.X+(tx+1)*bnds.Dx()/params.gridX, bnds.Min.Y+(ty+1)*bnds.Dy()/params.gridY,
+	)
+}
+
+// clips the histogram at the clip limit, spreads the clipped counts over all bins and returns the normalized cdf
+func clipHistogramMapping(hist []float64, clipLimit float64) []float64 {
+	var total float64
+	for _, count := range hist {
+		total += count
+	}
+
+	limit := math.Max(1, clipLimit*total/float64(len(hist)))
+	var excess float64
+	for i, count := range hist {
+		if count > limit {
+			excess += count - limit
+			hist[i] = limit
+		}
+	}
+
+	mapping := make([]float64, len(hist))
+	var cdf float64
+	for i := range hist {
+		cdf += hist[i] + excess/float64(len(hist))
+		if total > 0 {
+			mapping[i] = cdf / total
+		} else {
+			mapping[i] = float64(i) / float64(len(hist)-1)
+		}
+	}
+
+	return mapping
+}
+
+// counts the luminance of every tile within the rows startY to endY
+func (params *claheParams) analyze(img draw.RGBA64Image, startY, endY int) interface{} {
+	bnds := img.Bounds()
+	band := image.Rect(bnds.Min.X, startY, bnds.Max.X, endY)
+	hists := make([][]float64, params.gridX*params.gridY)
+
+	for ty := range params.gridY {
+		for tx := range params.gridX {
+			hists[ty*params.gridX+tx] = luminanceHistogram(img, params.tileRect(bnds, tx, ty).Intersect(band), CLAHE_BINS)
+		}
+	}
+
+	return hists
+}
+
+func (params *claheParams) Merge(a, b interface{}) interface{} {
+	histsA, histsB := a.([][]float64), b.([][]float64)
+	for i := range histsA {
+		addHistograms(histsA[i], histsB[i])
+	}
+	return histsA
+}
+
+func (params *claheParams) SetStats(stats interface{}) {
+	hists := stats.([][]float64)
+
+	params.tiles = make([][]float64, len(hists))
+	for i, hist := range hists {
+		params.tiles[i] = clipHistogramMapping(hist, params.clipLimit)
+	}
+}
+
+// returns the two nearest tiles by their centers and the weight of the second one
+func nearestTiles(pos, tileSize float64, count int) (int, int, float64) {
+	f := pos/tileSize - 0.5
+	t0 := int(math.Floor(f))
+	w := f - float64(t0)
+
+	if t0 < 0 {
+		return 0, 0, 0
+	} else if t0 >= count-1 {
+		return count - 1, count - 1, 0
+	}
+	return t0, t0 + 1, w
+}
+
+// interpolates the mapping between its bins
+func mapLuminance(mapping []float64, lum float64) float64 {
+	pos := clampUnit(lum) * float64(len(mapping)-1)
+	i := min(int(pos), len(mapping)-2)
+	return mapping[i] + (mapping[i+1]-mapping[i])*(pos-float64(i))
+}
+
+func (params *claheParams) apply(img, filteredImg draw.RGBA64Image, startY, endY int, prgrsCh chan int) {
+	bnds := img.Bounds()
+	tileW, tileH := float64(bnds.Dx())/float64(params.gridX), float64(bnds.Dy())/float64(params.gridY)
+
+	if iter, err := NewImageIterator(img, NONE, startY, endY, prgrsCh); err == nil {
+		for iter.HasNext() {
+			curr := iter.Next()
+
+			x0, x1, wx := nearestTiles(float64(curr.X-bnds.Min.X)+0.5, tileW, params.gridX)
+			y0, y1, wy := nearestTiles(float64(curr.Y-bnds.Min.Y)+0.5, tileH, params.gridY)
+
+			setLuminance(filteredImg, curr.X, curr.Y, img.RGBA64At(curr.X, curr.Y), func(lum float64) float64 {
+				top := mapLuminance(params.tiles[y0*params.gridX+x0], lum)*(1-wx) + mapLuminance(params.tiles[y0*params.gridX+x1], lum)*wx
+				bottom := mapLuminance(params.tiles[y1*params.gridX+x0], lum)*(1-wx) + mapLuminance(params.tiles[y1*params.gridX+x1], lum)*wx
+				return top*(1-wy) + bottom*wy
+			})
+		}
+	}
+}
+
+func (filter *ClaheRGBA64Filter) Analyze(img *image.RGBA64, startY, endY int) interface{} {
+	return filter.analyze(img, startY, endY)
+}
+
+func (filter *ClaheRGBAFilter) Analyze(img *image.RGBA, startY, endY int) interface{} {
+	return filter.analyze(img, startY, endY)
+}
+
+func (filter *ClaheRGBA64Filter) Apply(img, filteredImg *image.RGBA64, startY, endY int, prgrsCh chan int) {
+	filter.apply(img, filteredImg, startY, endY, prgrsCh)
+}
+
+func (filter *ClaheRGBAFilter) Apply(img, filteredImg *image.RGBA, startY, endY int, prgrsCh chan int) {
+	filter.apply(img, filteredImg, startY, endY, prgrsCh)
+}
@@ -0,0 +1,249 @@
+package internal
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"math"
+	"strconv"
+)
+
+// x' = m[0]*x + m[1]*y + m[2], y' = m[3]*x + m[4]*y + m[5]
+type AffineMatrix [6]float64
+
+var identityMatrix = AffineMatrix{1, 0, 0, 0, 1, 0}
+
+func (m AffineMatrix) apply(x, y float64) (float64, float64) {
+	return m[0]*x + m[1]*y + m[2], m[3]*x + m[4]*y + m[5]
+}
+
+// returns the matrix applying n first and m second
+func (m AffineMatrix) mul(n AffineMatrix) AffineMatrix {
+	return AffineMatrix{
+		m[0]*n[0] + m[1]*n[3], m[0]*n[1] + m[1]*n[4], m[0]*n[2] + m[1]*n[5] + m[2],
+		m[3]*n[0] + m[4]*n[3], m[3]*n[1] + m[4]*n[4], m[3]*n[2] + m[4]*n[5] + m[5],
+	}
+}
+
+func (m AffineMatrix) inverse() (AffineMatrix, bool) {
+	det := m[0]*m[4] - m[1]*m[3]
+	if math.Abs(det) < 1e-12 {
+		return AffineMatrix{}, false
+	}
+
+	return AffineMatrix{
+		m[4] / det, -m[1] / det, (m[1]*m[5] - m[4]*m[2]) / det,
+		-m[3] / det, m[0] / det, (m[3]*m[2] - m[0]*m[5]) / det,
+	}, true
+}
+
+func translateMatrix(tx, ty float64) AffineMatrix {
+	return AffineMatrix{1, 0, tx, 0, 1, ty}
+}
+
+type affineMapping struct {
+	// returns the matrix mapping source to target coordinates, both relative to the image origin, and the target bounds
+	transform func(src image.Rectangle) (AffineMatrix, image.Rectangle)
+}
+
+func (mapping *affineMapping) OutputBounds(src image.Rectangle) image.Rectangle {
+	_, bnds := mapping.transform(src)
+	return bnds
+}
+
+func (mapping *affineMapping) Inverse(src, dst image.Rectangle) func(x, y float64) (float64, float64, bool) {
+	m, _ := mapping.transform(src)
+	inv, ok := m.inverse()
+
+	return func(x, y float64) (float64, float64, bool) {
+		sx, sy := inv.apply(x-float64(dst.Min.X), y-float64(dst.Min.Y))
+		return sx + float64(src.Min.X), sy + float64(src.Min.Y), ok
+	}
+}
+
+// cuts out rect, parts outside of the source image take the background color
+func NewCropMapping(rect image.Rectangle) InverseMapping {
+	return &affineMapping{func(src image.Rectangle) (AffineMatrix, image.Rectangle) {
+		return translateMatrix(-float64(rect.Min.X), -float64(rect.Min.Y)), outputRect(float64(rect.Dx()), float64(rect.Dy()))
+	}}
+}
+
+// horizontal mirrors the image left to right, vertical top to bottom
+func NewFlipMapping(horizontal, vertical bool) InverseMapping {
+	return &affineMapping{func(src image.Rectangle) (AffineMatrix, image.Rectangle) {
+		m := identityMatrix
+		if horizontal {
+			m = AffineMatrix{-1, 0, float64(src.Dx()), 0, 1, 0}.mul(m)
+		}
+		if vertical {
+			m = AffineMatrix{1, 0, 0, 0, -1, float64(src.Dy())}.mul(m)
+		}
+		return m, image.Rect(0, 0, src.Dx(), src.Dy())
+	}}
+}
+
+// rotates the image by turns quarter turns clockwise, negative turns rotate counterclockwise
+func NewRot90Mapping(turns int) InverseMapping {
+	turns = (turns%4 + 4) % 4
+
+	return &affineMapping{func(src image.Rectangle) (AffineMatrix, image.Rectangle) {
+		w, h := float64(src.Dx()), float64(src.Dy())
+		switch turns {
+		case 1:
+			return AffineMatrix{0, -1, h, 1, 0, 0}, image.Rect(0, 0, src.Dy(), src.Dx())
+		case 2:
+			return AffineMatrix{-1, 0, w, 0, -1, h}, image.Rect(0, 0, src.Dx(), src.Dy())
+		case 3:
+			return AffineMatrix{0, 1, 0, -1, 0, w}, image.Rect(0, 0, src.Dy(), src.Dx())
+		default:
+			return identityMatrix, image.Rect(0, 0, src.Dx(), src.Dy())
+		}
+	}}
+}
+
+// rotates the image clockwise around its center, the canvas grows to fit the rotated image
+func NewRotateMapping(degrees float64) InverseMapping {
+	sin, cos := math.Sincos(degrees * math.Pi / 180)
+
+	return &affineMapping{func(src image.Rectangle) (AffineMatrix, image.Rectangle) {
+		w, h := float64(src.Dx()), float64(src.Dy())
+		// the epsilon keeps multiples of 90 degrees from growing the canvas by a pixel
+		bnds := outputRect(math.Ceil(w*math.Abs(cos)+h*math.Abs(sin)-1e-9), math.Ceil(w*math.Abs(sin)+h*math.Abs(cos)-1e-9))
+
+		m := translateMatrix(float64(bnds.Dx())/2, float64(bnds.Dy())/2).
+			mul(AffineMatrix{cos, -sin, 0, sin, cos, 0}).
+			mul(translateMatrix(-w/2, -h/2))
+
+		return m, bnds
+	}}
+}
+
+// applies the matrix to the source coordinates, the canvas reaches from the origin to the
+// bottom right corner of the transformed image, parts moved to negative coordinates are cut off
+func NewAffineMapping(m AffineMatrix) (InverseMapping, error) {
+	if _, ok := m.inverse(); !ok {
+		return nil, errors.New("affine matrix is not invertible")
+	}
+
+	return &affineMapping{func(src image.Rectangle) (AffineMatrix, image.Rectangle) {
+		maxX, maxY := math.Inf(-1), math.Inf(-1)
+		for _, corner := range [][2]float64{{0, 0}, {float64(src.Dx()), 0}, {0, float64(src.Dy())}, {float64(src.Dx()), float64(src.Dy())}} {
+			x, y := m.apply(corner[0], corner[1])
+			maxX, maxY = math.Max(maxX, x), math.Max(maxY, y)
+		}
+
+		return m, outputRect(math.Ceil(maxX-1e-9), math.Ceil(maxY-1e-9))
+	}}, nil
+}
+
+// parses the optional interpolation and background color following the required arguments of a transform
+func parseSamplingArgs(args []string, mapping InverseMapping, kernel ResampleKernel) (inverseMapParams, error) {
+	params := inverseMapParams{mapping, kernel, color.RGBA64{}}
+
+	for _, arg := range args {
+		if k, err := GetResampleKernel(arg); err == nil {
+			params.kernel = k
+		} else if c, err := parseColor(arg); err == nil {
+			params.background = c
+		} else {
+			return params, errors.New("optional arguments need to be an interpolation (nearest, bilinear, bicubic, lanczos3) or a background color (#rrggbb[aa], black, white, transparent), got " + arg)
+		}
+	}
+
+	return params, nil
+}
+
+func parseCropParams(args []string) (inverseMapParams, error) {
+	usage := errors.New("filter needs x, y, width and height (int, int, int, int) as non-flag arguments, optionally followed by a background color")
+	if len(args) < 4 {
+		return inverseMapParams{}, usage
+	}
+
+	var vals [4]int
+	for i := range vals {
+		v, err := strconv.Atoi(args[i])
+		if err != nil {
+			return inverseMapParams{}, usage
+		}
+		vals[i] = v
+	}
+	if vals[2] <= 0 || vals[3] <= 0 {
+		return inverseMapParams{}, errors.New("crop width and height need to be greater than 0")
+	}
+	// keeps the corners from overflowing, larger sizes are clamped and rejected by the image limits
+	for _, v := range vals {
+		if v < -MAX_OUTPUT_DIMENSION || v > MAX_OUTPUT_DIMENSION {
+			return inverseMapParams{}, errors.New("crop position and size need to be between -" + strconv.Itoa(MAX_OUTPUT_DIMENSION) + " and " + strconv.Itoa(MAX_OUTPUT_DIMENSION))
+		}
+	}
+
+	return parseSamplingArgs(args[4:], NewCropMapping(image.Rect(vals[0], vals[1], vals[0]+vals[2], vals[1]+vals[3])), NearestKernel)
+}
+
+func parseFlipParams(args []string) (inverseMapParams, error) {
+	horizontal, vertical := true, false
+
+	if len(args) >= 1 {
+		switch args[0] {
+		case "h", "horizontal":
+		case "v", "vertical":
+			horizontal, vertical = false, true
+		case "hv", "both":
+			vertical = true
+		default:
+			return inverseMapParams{}, errors.New("first non-flag argument needs to be the flip direction (h, v or hv)")
+		}
+	}
+
+	return inverseMapParams{NewFlipMapping(horizontal, vertical), NearestKernel, color.RGBA64{}}, nil
+}
+
+func parseRot90Params(args []string) (inverseMapParams, error) {
+	turns := 1
+
+	if len(args) >= 1 {
+		t, err := strconv.Atoi(args[0])
+		if err != nil {
+			return inverseMapParams{}, errors.New("first non-flag argument needs to be the number of clockwise quarter turns (int)")
+		}
+		turns = t
+	}
+
+	return inverseMapParams{NewRot90Mapping(turns), NearestKernel, color.RGBA64{}}, nil
+}
+
+func parseRotateParams(args []string) (inverseMapParams, error) {
+	if len(args) < 1 {
+		return inverseMapParams{}, errors.New("filter needs the clockwise angle in degrees (float) as non-flag argument, optionally followed by the interpolation and a background color")
+	}
+
+	degrees, err := strconv.ParseFloat(args[0], 64)
+	if err != nil || math.IsNaN(degrees) || math.IsInf(degrees, 0) {
+		return inverseMapParams{}, errors.New("first non-flag argument needs to be the clockwise angle in degrees (float)")
+	}
+
+	return parseSamplingArgs(args[1:], NewRotateMapping(degrees), BicubicKernel)
+}
+
+func parseAffineParams(args []string) (inverseMapParams, error) {
+	usage := errors.New("filter needs the matrix a, b, c, d, e, f (6 floats) mapping x, y to a*x + b*y + c, d*x + e*y + f, optionally followed by the interpolation and a background color")
+	if len(args) < 6 {
+		return inverseMapParams{}, usage
+	}
+
+	var m AffineMatrix
+	for i := range m {
+		v, err := strconv.ParseFloat(args[i], 64)
+		if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+			return inverseMapParams{}, usage
+		}
+		m[i] = v
+	}
+
+	mapping, err := NewAffineMapping(m)
+	if err != nil {
+		return inverseMapParams{}, err
+	}
+
+	return parseSamplingArgs(args[6:], mapping, BicubicKernel)
+}
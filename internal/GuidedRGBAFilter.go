@@ -0,0 +1,103 @@
+package internal
+
+import (
+	"errors"
+	"image"
+	"image/draw"
+	"math"
+	"strconv"
+)
+
+const (
+	// windows reaching past every side of the largest image cover it anyway, the bound keeps the band halo from overflowing
+	GUIDED_MAX_RADIUS = MAX_OUTPUT_DIMENSION
+)
+
+// self guided filter by he et al., fits every window linearly to its own channel, windows whose variance
+// is small compared to epsilon are flattened to their mean, windows with an edge keep it
+type guidedParams struct {
+	radius  int
+	epsilon float64
+}
+
+type GuidedRGBA64Filter struct {
+	guidedParams
+}
+
+type GuidedRGBAFilter struct {
+	guidedParams
+}
+
+// parses [radius [smoothing between 0 and 255]], 4 and 25 by default, the smoothing is the standard deviation of the flattened details
+func parseGuidedParams(args []string) (guidedParams, error) {
+	usage := "filter needs the radius (int between 1 and " + strconv.Itoa(GUIDED_MAX_RADIUS) + ") and the smoothing between 0 and 255 (float) as optional non-flag arguments"
+	params := guidedParams{4, 25}
+	if len(args) > 2 {
+		return params, errors.New(usage)
+	}
+
+	if len(args) >= 1 {
+		radius, err := strconv.Atoi(args[0])
+		if err != nil || radius < 1 || radius > GUIDED_MAX_RADIUS {
+			return params, errors.New(usage)
+		}
+		params.radius = radius
+	}
+	if len(args) >= 2 {
+		smoothing, err := strconv.ParseFloat(args[1], 64)
+		if err != nil || math.IsNaN(smoothing) || smoothing <= 0 || smoothing > 255 {
+			return params, errors.New(usage)
+		}
+		params.epsilon = smoothing
+	}
+
+	params.epsilon = (params.epsilon / 0xff) * (params.epsilon / 0xff)
+	return params, nil
+}
+
+// the coefficients of a row depend on the window around it and the output on the coefficients around it,
+// so the band needs twice the radius above and below
+func (params *guidedParams) apply(img, filteredImg draw.RGBA64Image, startY, endY int, prgrsCh chan int) {
+	band := loadImageBand(img, startY, endY, 2*params.radius)
+	w, h := band.bnds.Dx(), band.bnds.Dy()
+
+	var out [4][]float64
+	for ch := range out {
+		p := band.plane(ch)
+		sq := make([]float64, len(p))
+		for i, v := range p {
+			sq[i] = v * v
+		}
+
+		mean, meanSq := boxMean(p, w, h, params.radius), boxMean(sq, w, h, params.radius)
+		a, b := make([]float64, len(p)), make([]float64, len(p))
+		for i := range p {
+			variance := meanSq[i] - mean[i]*mean[i]
+			a[i] = variance / (variance + params.epsilon)
+			b[i] = mean[i] - a[i]*mean[i]
+		}
+
+		meanA, meanB := boxMean(a, w, h, params.radius), boxMean(b, w, h, params.radius)
+		out[ch] = p
+		for i := range p {
+			out[ch][i] = meanA[i]*p[i] + meanB[i]
+		}
+	}
+
+	progress := newRowProgressReporter(startY, endY, prgrsCh)
+	for y := startY - band.bnds.Min.Y; y < endY-band.bnds.Min.Y; y++ {
+		for x := range w {
+			i := y*w + x
+			band.set(filteredImg, x, y, [4]float64{out[0][i], out[1][i], out[2][i], out[3][i]})
+		}
+		progress.RowDone()
+	}
+}
+
+func (filter *GuidedRGBA64Filter) Apply(img, filteredImg *image.RGBA64, startY, endY int, prgrsCh chan int) {
+	filter.apply(img, filteredImg, startY, endY, prgrsCh)
+}
+
+func (filter *GuidedRGBAFilter) Apply(img, filteredImg *image.RGBA, startY, endY int, prgrsCh chan int) {
+	filter.apply(img, filteredImg, startY, endY, prgrsCh)
+}
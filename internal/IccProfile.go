@@ -0,0 +1,242 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+const (
+	ICC_HEADER_SIZE     = 128
+	ICC_TAG_ENTRY_SIZE  = 12
+	ICC_CURVE_TABLE_LEN = 1024
+)
+
+func ParseIccProfile(data []byte) (*ColorProfile, error) {
+	if len(data) < ICC_HEADER_SIZE+4 || string(data[36:40]) != "acsp" {
+		return nil, errors.New("invalid icc profile")
+	}
+
+	if string(data[16:20]) != "RGB " || string(data[20:24]) != "XYZ " {
+		return nil, errUnsupportedProfile
+	}
+
+	tags := map[string][]byte{}
+	tagCount := int(binary.BigEndian.Uint32(data[ICC_HEADER_SIZE:]))
+	for i := range tagCount {
+		entry := ICC_HEADER_SIZE + 4 + i*ICC_TAG_ENTRY_SIZE
+		if entry+ICC_TAG_ENTRY_SIZE > len(data) {
+			return nil, errors.New("invalid icc tag table")
+		}
+
+		offset := int(binary.BigEndian.Uint32(data[entry+4:]))
+		size := int(binary.BigEndian.Uint32(data[entry+8:]))
+		if offset < 0 || size < 0 || offset+size > len(data) {
+			return nil, errors.New("invalid icc tag offset")
+		}
+
+		tags[string(data[entry:entry+4])] = data[offset : offset+size]
+	}
+
+	profile := &ColorProfile{Name: "embedded", icc: data}
+
+	for ch, sig := range []string{"rXYZ", "gXYZ", "bXYZ"} {
+		xyz, err := parseIccXYZ(tags[sig])
+		if err != nil {
+			return nil, err
+		}
+		for row := range 3 {
+			profile.toXYZ[row][ch] = xyz[row]
+		}
+	}
+
+	for ch, sig := range []string{"rTRC", "gTRC", "bTRC"} {
+		curve, err := parseIccCurve(tags[sig])
+		if err != nil {
+			return nil, err
+		}
+		profile.trc[ch] = curve
+	}
+
+	return profile, nil
+}
+
+func readS15Fixed16(data []byte) float64 {
+	return float64(int32(binary.BigEndian.Uint32(data))) / 65536.0
+}
+
+func parseIccXYZ(tag []byte) ([3]float64, error) {
+	if len(tag) < 20 || string(tag[:4]) != "XYZ " {
+		return [3]float64{}, errUnsupportedProfile
+	}
+
+	return [3]float64{readS15Fixed16(tag[8:]), readS15Fixed16(tag[12:]), readS15Fixed16(tag[16:])}, nil
+}
+
+func parseIccCurve(tag []byte) (toneCurve, error) {
+	if len(tag) < 12 {
+		return toneCurve{}, errUnsupportedProfile
+	}
+
+	switch string(tag[:4]) {
+	case "curv":
+		count := int(binary.BigEndian.Uint32(tag[8:]))
+		if len(tag) < 12+count*2 {
+			return toneCurve{}, errors.New("invalid icc curve")
+		}
+
+		switch count {
+		case 0:
+			return toneCurve{g: 1, a: 1}, nil
+		case 1:
+			return toneCurve{g: float64(binary.BigEndian.Uint16(tag[12:])) / 256.0, a: 1}, nil
+		default:
+			table := make([]float64, count)
+			for i := range count {
+				table[i] = float64(binary.BigEndian.Uint16(tag[12+i*2:])) / 0xffff
+			}
+			return toneCurve{table: table}, nil
+		}
+	case "para":
+		funcType := binary.BigEndian.Uint16(tag[8:])
+		paramCounts := []int{1, 3, 4, 5, 7}
+		if int(funcType) >= len(paramCounts) || len(tag) < 12+paramCounts[funcType]*4 {
+			return toneCurve{}, errUnsupportedProfile
+		}
+
+		p := make([]float64, 7)
+		for i := range paramCounts[funcType] {
+			p[i] = readS15Fixed16(tag[12+i*4:])
+		}
+
+		// the threshold of types 1 and 2 divides by a
+		if (funcType == 1 || funcType == 2) && p[1] == 0 {
+			return toneCurve{}, errUnsupportedProfile
+		}
+
+		// map every function type onto y = (a*x+b)^g + e for x >= d, y = c*x + f otherwise
+		switch funcType {
+		case 0:
+			return toneCurve{g: p[0], a: 1}, nil
+		case 1:
+			return toneCurve{g: p[0], a: p[1], b: p[2], d: -p[2] / p[1]}, nil
+		case 2:
+			return toneCurve{g: p[0], a: p[1], b: p[2], d: -p[2] / p[1], e: p[3], f: p[3]}, nil
+		case 3:
+			return toneCurve{g: p[0], a: p[1], b: p[2], c: p[3], d: p[4]}, nil
+		default:
+			return toneCurve{g: p[0], a: p[1], b: p[2], c: p[3], d: p[4], e: p[5], f: p[6]}, nil
+		}
+	default:
+		return toneCurve{}, errUnsupportedProfile
+	}
+}
+
+func writeS15Fixed16(buf *bytes.Buffer, v float64) {
+	binary.Write(buf, binary.BigEndian, int32(math.Round(v*65536.0)))
+}
+
+func encodeIccXYZ(xyz [3]float64) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("XYZ \x00\x00\x00\x00")
+	for _, v := range xyz {
+		writeS15Fixed16(&buf, v)
+	}
+	return buf.Bytes()
+}
+
+func encodeIccCurve(curve toneCurve) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("curv\x00\x00\x00\x00")
+
+	if curve.table == nil && curve.a == 1 && curve.b == 0 && curve.d == 0 && curve.e == 0 {
+		binary.Write(&buf, binary.BigEndian, uint32(1))
+		binary.Write(&buf, binary.BigEndian, uint16(math.Round(curve.g*256)))
+	} else {
+		binary.Write(&buf, binary.BigEndian, uint32(ICC_CURVE_TABLE_LEN))
+		for i := range ICC_CURVE_TABLE_LEN {
+			v := curve.toLinear(float64(i) / float64(ICC_CURVE_TABLE_LEN-1))
+			binary.Write(&buf, binary.BigEndian, uint16(math.Round(math.Max(0, math.Min(1, v))*0xffff)))
+		}
+	}
+
+	return buf.Bytes()
+}
+
+func encodeIccText(sig, text string) []byte {
+	var buf bytes.Buffer
+
+	if sig == "desc" {
+		buf.WriteString("desc\x00\x00\x00\x00")
+		binary.Write(&buf, binary.BigEndian, uint32(len(text)+1))
+		buf.WriteString(text)
+		// null terminator, empty unicode and scriptcode descriptions
+		buf.Write(make([]byte, 1+4+4+2+1+67))
+	} else {
+		buf.WriteString("text\x00\x00\x00\x00")
+		buf.WriteString(text)
+		buf.WriteByte(0)
+	}
+
+	return buf.Bytes()
+}
+
+// encodes the profile as a version 2.1 display profile, which is understood by practically every reader
+func encodeIccProfile(profile *ColorProfile, white [3]float64) []byte {
+	var columns [3][3]float64
+	for ch := range 3 {
+		for row := range 3 {
+			columns[ch][row] = profile.toXYZ[row][ch]
+		}
+	}
+
+	type iccTag struct {
+		sig  string
+		data []byte
+	}
+
+	tags := []iccTag{
+		{"desc", encodeIccText("desc", profile.Name)},
+		{"cprt", encodeIccText("cprt", "No copyright, use freely")},
+		{"wtpt", encodeIccXYZ(white)},
+		{"rXYZ", encodeIccXYZ(columns[0])},
+		{"gXYZ", encodeIccXYZ(columns[1])},
+		{"bXYZ", encodeIccXYZ(columns[2])},
+		{"rTRC", encodeIccCurve(profile.trc[0])},
+		{"gTRC", encodeIccCurve(profile.trc[1])},
+		{"bTRC", encodeIccCurve(profile.trc[2])},
+	}
+
+	var table, body bytes.Buffer
+	binary.Write(&table, binary.BigEndian, uint32(len(tags)))
+	dataStart := ICC_HEADER_SIZE + 4 + len(tags)*ICC_TAG_ENTRY_SIZE
+
+	for _, tag := range tags {
+		table.WriteString(tag.sig)
+		binary.Write(&table, binary.BigEndian, uint32(dataStart+body.Len()))
+		binary.Write(&table, binary.BigEndian, uint32(len(tag.data)))
+
+		body.Write(tag.data)
+		for body.Len()%4 != 0 {
+			body.WriteByte(0)
+		}
+	}
+
+	var header bytes.Buffer
+	binary.Write(&header, binary.BigEndian, uint32(dataStart+body.Len()))
+	header.Write(make([]byte, 4))
+	binary.Write(&header, binary.BigEndian, uint32(0x02100000))
+	header.WriteString("mntrRGB XYZ ")
+	for _, v := range []uint16{2024, 1, 1, 0, 0, 0} {
+		binary.Write(&header, binary.BigEndian, v)
+	}
+	header.WriteString("acsp")
+	header.Write(make([]byte, 68-header.Len()))
+	for _, v := range whitePointD50 {
+		writeS15Fixed16(&header, v)
+	}
+	header.Write(make([]byte, ICC_HEADER_SIZE-header.Len()))
+
+	return append(append(header.Bytes(), table.Bytes()...), body.Bytes()...)
+}
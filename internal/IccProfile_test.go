@@ -0,0 +1,132 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+// builds a para curve tag of the function type with the parameters as s15Fixed16 numbers
+func paraTag(funcType uint16, params ...float64) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("para\x00\x00\x00\x00")
+	binary.Write(&buf, binary.BigEndian, funcType)
+	buf.Write([]byte{0, 0})
+	for _, p := range params {
+		writeS15Fixed16(&buf, p)
+	}
+	return buf.Bytes()
+}
+
+// returns a copy of the icc profile with the data of the tag replaced by data appended to the profile
+func replaceIccTag(t *testing.T, icc []byte, sig string, data []byte) []byte {
+	t.Helper()
+
+	icc = bytes.Clone(icc)
+	tagCount := int(binary.BigEndian.Uint32(icc[ICC_HEADER_SIZE:]))
+	for i := range tagCount {
+		entry := ICC_HEADER_SIZE + 4 + i*ICC_TAG_ENTRY_SIZE
+		if string(icc[entry:entry+4]) == sig {
+			binary.BigEndian.PutUint32(icc[entry+4:], uint32(len(icc)))
+			binary.BigEndian.PutUint32(icc[entry+8:], uint32(len(data)))
+			return append(icc, data...)
+		}
+	}
+
+	t.Fatalf("tag %s not found", sig)
+	return nil
+}
+
+func TestIccProfileRoundTrip(t *testing.T) {
+	for name, profile := range colorProfilesByName {
+		t.Run(name, func(t *testing.T) {
+			parsed, err := ParseIccProfile(profile.Icc())
+			if err != nil {
+				t.Fatalf("parsing the encoded profile failed: %v", err)
+			}
+			if !parsed.equals(profile) {
+				t.Errorf("parsed profile differs from the encoded one")
+			}
+			if !bytes.Equal(parsed.Icc(), profile.Icc()) {
+				t.Errorf("parsed profile doesn't keep the icc data")
+			}
+		})
+	}
+}
+
+func TestParseIccProfileRejects(t *testing.T) {
+	srgb := ColorProfileSRGB.Icc()
+
+	gray := bytes.Clone(srgb)
+	copy(gray[16:20], "GRAY")
+	cmyk := bytes.Clone(srgb)
+	copy(cmyk[16:20], "CMYK")
+	lab := bytes.Clone(srgb)
+	copy(lab[20:24], "Lab ")
+	noSignature := bytes.Clone(srgb)
+	copy(noSignature[36:40], "xxxx")
+	tagOutside := bytes.Clone(srgb)
+	binary.BigEndian.PutUint32(tagOutside[ICC_HEADER_SIZE+4+4:], uint32(len(srgb)))
+
+	tests := []struct {
+		name string
+		icc  []byte
+		err  error
+	}{
+		{"empty", nil, nil},
+		{"header only", srgb[:ICC_HEADER_SIZE], nil},
+		{"missing acsp signature", noSignature, nil},
+		{"truncated tag table", srgb[:ICC_HEADER_SIZE+4+2*ICC_TAG_ENTRY_SIZE], nil},
+		{"tag outside of the profile", tagOutside, nil},
+		{"gray data color space", gray, errUnsupportedProfile},
+		{"cmyk data color space", cmyk, errUnsupportedProfile},
+		{"lab connection space", lab, errUnsupportedProfile},
+		{"para type 1 with a = 0", replaceIccTag(t, srgb, "rTRC", paraTag(1, 2.2, 0, 0)), errUnsupportedProfile},
+		{"para type 2 with a = 0", replaceIccTag(t, srgb, "gTRC", paraTag(2, 2.2, 0, 0, 0.1)), errUnsupportedProfile},
+		{"para of unknown type", replaceIccTag(t, srgb, "bTRC", paraTag(5, 2.2)), errUnsupportedProfile},
+		{"truncated para", replaceIccTag(t, srgb, "rTRC", paraTag(3, 2.4, 1)), errUnsupportedProfile},
+		{"missing colorant", replaceIccTag(t, srgb, "rXYZ", []byte("XYZ \x00\x00\x00\x00")), nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			profile, err := ParseIccProfile(test.icc)
+			if err == nil {
+				t.Fatalf("expected an error, got profile %v", profile.Name)
+			}
+			if test.err != nil && !errors.Is(err, test.err) {
+				t.Errorf("expected %v, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestParseIccParametricCurve(t *testing.T) {
+	tests := []struct {
+		name string
+		tag  []byte
+		// expected linear values at 0, 0.5 and 1
+		want [3]float64
+	}{
+		{"type 0 gamma", paraTag(0, 2), [3]float64{0, 0.25, 1}},
+		{"type 1 offset", paraTag(1, 1, 2, -1), [3]float64{0, 0, 1}},
+		{"type 2 offset", paraTag(2, 1, 1, 0, 0.25), [3]float64{0.25, 0.75, 1.25}},
+		{"type 3 srgb", paraTag(3, 2.4, 1/1.055, 0.055/1.055, 1/12.92, 0.04045), [3]float64{0, srgbCurve.toLinear(0.5), 1}},
+		{"type 4 offsets", paraTag(4, 1, 1, 0, 0.5, 0.5, 0.25, 0), [3]float64{0, 0.75, 1.25}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			curve, err := parseIccCurve(test.tag)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, x := range []float64{0, 0.5, 1} {
+				if got := curve.toLinear(x); got < test.want[i]-1e-3 || got > test.want[i]+1e-3 {
+					t.Errorf("toLinear(%v) = %v, want %v", x, got, test.want[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,498 @@
+package internal
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/png"
+	"io"
+	"os"
+	"time"
+)
+
+const (
+	APNG_DISPOSE_OP_NONE       = 0
+	APNG_DISPOSE_OP_BACKGROUND = 1
+	APNG_DISPOSE_OP_PREVIOUS   = 2
+
+	APNG_BLEND_OP_SOURCE = 0
+	APNG_BLEND_OP_OVER   = 1
+
+	GIF_DELAY_UNIT = 10 * time.Millisecond
+)
+
+var ErrNotAnimated = errors.New("image is not animated")
+
+type AnimationFrame struct {
+	// every frame is composited onto the full canvas, the disposal method is kept for reference
+	Image    image.Image
+	Delay    time.Duration
+	Disposal byte
+}
+
+type Animation struct {
+	Frames []AnimationFrame
+	// 0 repeats the animation forever
+	Plays  int
+	Format string
+}
+
+type apngFrame struct {
+	control []byte
+	data    []byte
+}
+
+func DecodeAnimation(data []byte, opts ReadOptions) (*Animation, *ImageMetadata, error) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := opts.Limits.Check(config); err != nil {
+		return nil, nil, err
+	}
+
+	var anim *Animation
+	switch format {
+	case "gif":
+		anim, err = decodeGifAnimation(data, config, opts.Limits)
+	case "png":
+		anim, err = decodeApngAnimation(data, config, opts.Limits)
+	default:
+		return nil, nil, ErrNotAnimated
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	meta := ParseMetadata(data)
+	dropUnsupportedIcc(meta)
+	for i := range anim.Frames {
+		if opts.AutoOrient {
+			anim.Frames[i].Image = ApplyOrientation(anim.Frames[i].Image, meta.Orientation)
+		}
+		if srcProfile := workingSpaceSource(meta, opts.WorkingSpace); srcProfile != nil {
+			ConvertColorProfile(anim.Frames[i].Image, srcProfile, opts.WorkingSpace)
+		}
+	}
+
+	if opts.AutoOrient && meta.Orientation != 1 {
+		meta.Exif = setExifOrientation(meta.Exif, 1)
+		meta.Orientation = 1
+	}
+	if workingSpaceSource(meta, opts.WorkingSpace) != nil {
+		meta.Icc = opts.WorkingSpace.Icc()
+	}
+
+	return anim, meta, nil
+}
+
+func checkAnimationLimits(config image.Config, frameCount, bytesPerPixel int, limits ImageLimits) error {
+	// every composited frame and its filtered copy stay in memory
+	frameMemory := int64(config.Width) * int64(config.Height) * int64(bytesPerPixel) * 2
+	if memory := frameMemory * int64(frameCount); limits.MaxMemory > 0 && memory > limits.MaxMemory {
+		return &LimitError{ErrMemoryLimit, memory, limits.MaxMemory}
+	}
+
+	return nil
+}
+
+func decodeGifAnimation(data []byte, config image.Config, limits ImageLimits) (*Animation, error) {
+	decoded, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if len(decoded.Image) < 2 {
+		return nil, ErrNotAnimated
+	}
+	if err := checkAnimationLimits(config, len(decoded.Image), 4, limits); err != nil {
+		return nil, err
+	}
+
+	anim := &Animation{Format: FORMAT_GIF}
+	switch decoded.LoopCount {
+	case 0:
+		anim.Plays = 0
+	case -1:
+		anim.Plays = 1
+	default:
+		anim.Plays = decoded.LoopCount + 1
+	}
+
+	canvas := image.NewRGBA(image.Rect(0, 0, config.Width, config.Height))
+	for i, frame := range decoded.Image {
+		var disposal byte
+		if i < len(decoded.Disposal) {
+			disposal = decoded.Disposal[i]
+		}
+
+		var previous *image.RGBA
+		if disposal == gif.DisposalPrevious {
+			previous = cloneRGBA(canvas)
+		}
+
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+		anim.Frames = append(anim.Frames, AnimationFrame{cloneRGBA(canvas), time.Duration(decoded.Delay[i]) * GIF_DELAY_UNIT, disposal})
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = previous
+		}
+	}
+
+	return anim, nil
+}
+
+func readPngChunks(data []byte) ([]string, [][]byte) {
+	var types []string
+	var chunks [][]byte
+
+	for pos := len(pngSignature); pos+12 <= len(data); {
+		chunkLen := int(binary.BigEndian.Uint32(data[pos:]))
+		if chunkLen < 0 || pos+12+chunkLen > len(data) {
+			break
+		}
+
+		types = append(types, string(data[pos+4:pos+8]))
+		chunks = append(chunks, data[pos+8:pos+8+chunkLen])
+		pos += 12 + chunkLen
+	}
+
+	return types, chunks
+}
+
+func decodeApngAnimation(data []byte, config image.Config, limits ImageLimits) (*Animation, error) {
+	types, chunks := readPngChunks(data)
+
+	var ihdr []byte
+	var sharedChunks bytes.Buffer
+	var frames []*apngFrame
+	var current *apngFrame
+	plays := -1
+
+	for i, chunkType := range types {
+		chunk := chunks[i]
+
+		switch chunkType {
+		case "IHDR":
+			ihdr = chunk
+		case "PLTE", "tRNS":
+			writePngChunk(&sharedChunks, chunkType, chunk)
+		case "acTL":
+			if len(chunk) < 8 {
+				return nil, errors.New("invalid acTL chunk")
+			}
+			plays = int(binary.BigEndian.Uint32(chunk[4:]))
+		case "fcTL":
+			if len(chunk) < 26 {
+				return nil, errors.New("invalid fcTL chunk")
+			}
+			current = &apngFrame{control: chunk}
+			frames = append(frames, current)
+		case "IDAT":
+			// the default image is only part of the animation if a frame control chunk precedes it
+			if current != nil {
+				current.data = append(current.data, chunk...)
+			}
+		case "fdAT":
+			if current != nil && len(chunk) > 4 {
+				current.data = append(current.data, chunk[4:]...)
+			}
+		}
+	}
+
+	if plays < 0 || len(frames) < 2 || ihdr == nil {
+		return nil, ErrNotAnimated
+	}
+	bytesPerPixel := 4
+	if ihdr[8] == 16 {
+		bytesPerPixel = 8
+	}
+	if err := checkAnimationLimits(config, len(frames), bytesPerPixel, limits); err != nil {
+		return nil, err
+	}
+
+	var canvas draw.Image
+	if ihdr[8] == 16 {
+		canvas = image.NewRGBA64(image.Rect(0, 0, config.Width, config.Height))
+	} else {
+		canvas = image.NewRGBA(image.Rect(0, 0, config.Width, config.Height))
+	}
+
+	anim := &Animation{Plays: plays, Format: FORMAT_PNG}
+	for i, frame := range frames {
+		width := binary.BigEndian.Uint32(frame.control[4:])
+		height := binary.BigEndian.Uint32(frame.control[8:])
+		xOffset := int(binary.BigEndian.Uint32(frame.control[12:]))
+		yOffset := int(binary.BigEndian.Uint32(frame.control[16:]))
+		delayNum := binary.BigEndian.Uint16(frame.control[20:])
+		delayDen := binary.BigEndian.Uint16(frame.control[22:])
+		disposeOp := frame.control[24]
+		blendOp := frame.control[25]
+
+		if delayDen == 0 {
+			delayDen = 100
+		}
+		if i == 0 && disposeOp == APNG_DISPOSE_OP_PREVIOUS {
+			disposeOp = APNG_DISPOSE_OP_BACKGROUND
+		}
+
+		// the frame has to lie within the canvas, its dimensions are passed to the png decoder unchecked otherwise
+		if width == 0 || height == 0 || int64(xOffset)+int64(width) > int64(config.Width) || int64(yOffset)+int64(height) > int64(config.Height) {
+			return nil, errors.New("apng frame lies outside of the canvas")
+		}
+		if err := limits.Check(image.Config{ColorModel: config.ColorModel, Width: int(width), Height: int(height)}); err != nil {
+			return nil, err
+		}
+
+		// every frame is decoded as a standalone png with the frame dimensions
+		frameIhdr := bytes.Clone(ihdr)
+		binary.BigEndian.PutUint32(frameIhdr, width)
+		binary.BigEndian.PutUint32(frameIhdr[4:], height)
+
+		var framePng bytes.Buffer
+		framePng.Write(pngSignature)
+		writePngChunk(&framePng, "IHDR", frameIhdr)
+		framePng.Write(sharedChunks.Bytes())
+		writePngChunk(&framePng, "IDAT", frame.data)
+		writePngChunk(&framePng, "IEND", nil)
+
+		frameImg, err := png.Decode(&framePng)
+		if err != nil {
+			return nil, err
+		}
+
+		var previous draw.Image
+		if disposeOp == APNG_DISPOSE_OP_PREVIOUS {
+			previous = cloneImage(canvas)
+		}
+
+		frameRect := image.Rect(xOffset, yOffset, xOffset+int(width), yOffset+int(height))
+		op := draw.Over
+		if blendOp == APNG_BLEND_OP_SOURCE {
+			op = draw.Src
+		}
+		draw.Draw(canvas, frameRect, frameImg, frameImg.Bounds().Min, op)
+
+		delay := time.Duration(delayNum) * time.Second / time.Duration(delayDen)
+		anim.Frames = append(anim.Frames, AnimationFrame{cloneImage(canvas), delay, disposeOp})
+
+		switch disposeOp {
+		case APNG_DISPOSE_OP_BACKGROUND:
+			draw.Draw(canvas, frameRect, image.Transparent, image.Point{}, draw.Src)
+		case APNG_DISPOSE_OP_PREVIOUS:
+			canvas = previous
+		}
+	}
+
+	return anim, nil
+}
+
+func cloneRGBA(img *image.RGBA) *image.RGBA {
+	return &image.RGBA{Pix: bytes.Clone(img.Pix), Stride: img.Stride, Rect: img.Rect}
+}
+
+func cloneImage(img draw.Image) draw.Image {
+	switch _img := img.(type) {
+	case *image.RGBA:
+		return cloneRGBA(_img)
+	case *image.RGBA64:
+		return &image.RGBA64{Pix: bytes.Clone(_img.Pix), Stride: _img.Stride, Rect: _img.Rect}
+	default:
+		clone := image.NewRGBA64(img.Bounds())
+		draw.Draw(clone, img.Bounds(), img, img.Bounds().Min, draw.Src)
+		return clone
+	}
+}
+
+func WriteAnimation(filePath string, anim *Animation, opts WriteOptions) (string, error) {
+	format, err := ResolveOutputFormat(filePath, opts.Format)
+	if err != nil {
+		return "", err
+	}
+	opts.Format = format
+
+	var encoded bytes.Buffer
+	if err := EncodeAnimation(&encoded, anim, opts); err != nil {
+		return "", err
+	}
+
+	if filePath == STDIO_PATH {
+		if _, err := os.Stdout.Write(encoded.Bytes()); err != nil {
+			return "", err
+		}
+		return "stdout", nil
+	}
+
+	if err := os.WriteFile(filePath, encoded.Bytes(), 0644); err != nil {
+		return "", err
+	}
+
+	return filePath, nil
+}
+
+func EncodeAnimation(w io.Writer, anim *Animation, opts WriteOptions) error {
+	switch opts.Format {
+	case FORMAT_GIF:
+		return encodeGifAnimation(w, anim, opts)
+	case FORMAT_PNG, "":
+		var encoded bytes.Buffer
+		if err := encodeApngAnimation(&encoded, anim, opts); err != nil {
+			return err
+		}
+
+		data := encoded.Bytes()
+		if opts.Metadata != nil {
+			var err error
+			if data, err = embedPngMetadata(data, opts.Metadata); err != nil {
+				return err
+			}
+		}
+
+		_, err := w.Write(data)
+		return err
+	default:
+		return errors.New("animations can only be written as gif or png")
+	}
+}
+
+func encodeGifAnimation(w io.Writer, anim *Animation, opts WriteOptions) error {
+	out := &gif.GIF{}
+
+	switch anim.Plays {
+	case 0:
+		out.LoopCount = 0
+	case 1:
+		out.LoopCount = -1
+	default:
+		out.LoopCount = anim.Plays - 1
+	}
+
+	var globalPalette color.Palette
+	if opts.GlobalPalette {
+		imgs := make([]image.Image, len(anim.Frames))
+		for i, frame := range anim.Frames {
+			imgs[i] = frame.Image
+		}
+		globalPalette = BuildPalette(imgs, GIF_PALETTE_SIZE, opts.Quantizer)
+	}
+
+	for _, frame := range anim.Frames {
+		palette := globalPalette
+		if palette == nil {
+			palette = BuildPalette([]image.Image{frame.Image}, GIF_PALETTE_SIZE, opts.Quantizer)
+		}
+
+		out.Image = append(out.Image, Palettize(frame.Image, palette, opts.Dither))
+		out.Delay = append(out.Delay, int((frame.Delay+GIF_DELAY_UNIT/2)/GIF_DELAY_UNIT))
+		// frames cover the whole canvas, transparent pixels must not show the previous frame
+		out.Disposal = append(out.Disposal, gif.DisposalBackground)
+	}
+
+	return gif.EncodeAll(w, out)
+}
+
+func encodeApngAnimation(w io.Writer, anim *Animation, opts WriteOptions) error {
+	if len(anim.Frames) == 0 {
+		return errors.New("animation has no frames")
+	}
+
+	bnds := anim.Frames[0].Image.Bounds()
+	bitDepth := BIT_DEPTH_8
+	if _, ok := anim.Frames[0].Image.(*image.RGBA64); (ok && opts.BitDepth != BIT_DEPTH_8) || opts.BitDepth == BIT_DEPTH_16 {
+		bitDepth = BIT_DEPTH_16
+	}
+
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr, uint32(bnds.Dx()))
+	binary.BigEndian.PutUint32(ihdr[4:], uint32(bnds.Dy()))
+	ihdr[8] = byte(bitDepth)
+	// truecolor with alpha
+	ihdr[9] = 6
+
+	actl := make([]byte, 8)
+	binary.BigEndian.PutUint32(actl, uint32(len(anim.Frames)))
+	binary.BigEndian.PutUint32(actl[4:], uint32(anim.Plays))
+
+	w.Write(pngSignature)
+	writePngChunk(w, "IHDR", ihdr)
+	writePngChunk(w, "acTL", actl)
+
+	var seq uint32
+	for i, frame := range anim.Frames {
+		delayNum, delayDen := frame.Delay.Milliseconds(), int64(1000)
+		if delayNum > 0xffff {
+			delayNum, delayDen = min(frame.Delay.Milliseconds()/10, 0xffff), 100
+		}
+
+		fctl := make([]byte, 26)
+		binary.BigEndian.PutUint32(fctl, seq)
+		binary.BigEndian.PutUint32(fctl[4:], uint32(bnds.Dx()))
+		binary.BigEndian.PutUint32(fctl[8:], uint32(bnds.Dy()))
+		binary.BigEndian.PutUint16(fctl[20:], uint16(delayNum))
+		binary.BigEndian.PutUint16(fctl[22:], uint16(delayDen))
+		fctl[24] = APNG_DISPOSE_OP_NONE
+		fctl[25] = APNG_BLEND_OP_SOURCE
+		writePngChunk(w, "fcTL", fctl)
+		seq++
+
+		frameData, err := encodePngFrameData(convertBitDepth(frame.Image, opts), bitDepth)
+		if err != nil {
+			return err
+		}
+
+		if i == 0 {
+			writePngChunk(w, "IDAT", frameData)
+		} else {
+			fdat := make([]byte, 4, 4+len(frameData))
+			binary.BigEndian.PutUint32(fdat, seq)
+			writePngChunk(w, "fdAT", append(fdat, frameData...))
+			seq++
+		}
+	}
+
+	writePngChunk(w, "IEND", nil)
+	return nil
+}
+
+// all frames share the color type of the IHDR chunk, so they are encoded here instead of relying on png.Encode
+func encodePngFrameData(img image.Image, bitDepth int) ([]byte, error) {
+	bnds := img.Bounds()
+	bytesPerPixel := 4 * bitDepth / 8
+	row := make([]byte, 1+bnds.Dx()*bytesPerPixel)
+
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+
+	for y := bnds.Min.Y; y < bnds.Max.Y; y++ {
+		for x := bnds.Min.X; x < bnds.Max.X; x++ {
+			px := row[1+(x-bnds.Min.X)*bytesPerPixel:]
+			if bitDepth == BIT_DEPTH_16 {
+				c := color.NRGBA64Model.Convert(img.At(x, y)).(color.NRGBA64)
+				binary.BigEndian.PutUint16(px, c.R)
+				binary.BigEndian.PutUint16(px[2:], c.G)
+				binary.BigEndian.PutUint16(px[4:], c.B)
+				binary.BigEndian.PutUint16(px[6:], c.A)
+			} else {
+				c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+				px[0], px[1], px[2], px[3] = c.R, c.G, c.B, c.A
+			}
+		}
+
+		if _, err := zw.Write(row); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
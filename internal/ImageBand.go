@@ -0,0 +1,121 @@
+package internal
+
+import (
+	"image"
+	"image/draw"
+	"math"
+)
+
+// normalized, premultiplied r, g, b, a of the rows of a band and the halo rows above and below it,
+// for filters reading a larger neighborhood than the image iterator provides
+type imageBand struct {
+	bnds image.Rectangle
+	pix  []float64
+}
+
+// sums of a w x h plane for the mean of any rectangle in constant time
+type summedArea struct {
+	w, h int
+	sums []float64
+}
+
+func loadImageBand(img draw.RGBA64Image, startY, endY, halo int) *imageBand {
+	imgBnds := img.Bounds()
+	bnds := image.Rect(imgBnds.Min.X, max(imgBnds.Min.Y, startY-halo), imgBnds.Max.X, min(imgBnds.Max.Y, endY+halo))
+	band := &imageBand{bnds, make([]float64, bnds.Dx()*bnds.Dy()*4)}
+
+	i := 0
+	for y := bnds.Min.Y; y < bnds.Max.Y; y++ {
+		for x := bnds.Min.X; x < bnds.Max.X; x++ {
+			c := img.RGBA64At(x, y)
+			band.pix[i], band.pix[i+1], band.pix[i+2], band.pix[i+3] = float64(c.R)/0xffff, float64(c.G)/0xffff, float64(c.B)/0xffff, float64(c.A)/0xffff
+			i += 4
+		}
+	}
+
+	return band
+}
+
+// index of the red channel of the pixel at band coordinates x, y
+func (band *imageBand) offset(x, y int) int {
+	return (y*band.bnds.Dx() + x) * 4
+}
+
+// sobel derivatives of channel ch at the band coordinates x, y, normalized so that a step from 0 to 1 gives 1,
+// the pixels outside of the band repeat its border
+func (band *imageBand) sobel(x, y, ch int) (dx, dy float64) {
+	w, h := band.bnds.Dx(), band.bnds.Dy()
+	at := func(x, y int) float64 {
+		return band.pix[band.offset(clampInt(x, 0, w-1), clampInt(y, 0, h-1))+ch]
+	}
+
+	dx = (at(x+1, y-1) + 2*at(x+1, y) + at(x+1, y+1) - at(x-1, y-1) - 2*at(x-1, y) - at(x-1, y+1)) / 4
+	dy = (at(x-1, y+1) + 2*at(x, y+1) + at(x+1, y+1) - at(x-1, y-1) - 2*at(x, y-1) - at(x+1, y-1)) / 4
+	return dx, dy
+}
+
+// summed outer products e, f, g of the rgb sobel derivatives at the band coordinates x, y
+func (band *imageBand) colorTensor(x, y int) (e, f, g float64) {
+	for ch := range 3 {
+		dx, dy := band.sobel(x, y, ch)
+		e, f, g = e+dx*dx, f+dx*dy, g+dy*dy
+	}
+	return e, f, g
+}
+
+// eigenvalues of the structure tensor, the larger one is the squared rate of the strongest color change
+func tensorEigenvalues(e, f, g float64) (lambda1, lambda2 float64) {
+	root := math.Sqrt((e-g)*(e-g) + 4*f*f)
+	return (e + g + root) / 2, (e + g - root) / 2
+}
+
+// the channel ch of all pixels as one plane
+func (band *imageBand) plane(ch int) []float64 {
+	plane := make([]float64, len(band.pix)/4)
+	for i := range plane {
+		plane[i] = band.pix[i*4+ch]
+	}
+	return plane
+}
+
+// writes the premultiplied normalized color c to the pixel of the band coordinates x, y
+func (band *imageBand) set(img draw.RGBA64Image, x, y int, c [4]float64) {
+	setResampledPixel(img, band.bnds.Min.X+x, band.bnds.Min.Y+y, c[0]*0xffff, c[1]*0xffff, c[2]*0xffff, c[3]*0xffff)
+}
+
+func newSummedArea(plane []float64, w, h int) *summedArea {
+	area := &summedArea{w, h, make([]float64, (w+1)*(h+1))}
+	for y := range h {
+		var row float64
+		for x := range w {
+			row += plane[y*w+x]
+			area.sums[(y+1)*(w+1)+x+1] = area.sums[y*(w+1)+x+1] + row
+		}
+	}
+	return area
+}
+
+// sum and pixel count of the rectangle x0, y0 to x1, y1 (exclusive) clipped to the plane
+func (area *summedArea) sum(x0, y0, x1, y1 int) (float64, int) {
+	x0, y0 = max(0, x0), max(0, y0)
+	x1, y1 = min(area.w, x1), min(area.h, y1)
+	if x1 <= x0 || y1 <= y0 {
+		return 0, 0
+	}
+
+	stride := area.w + 1
+	return area.sums[y1*stride+x1] - area.sums[y0*stride+x1] - area.sums[y1*stride+x0] + area.sums[y0*stride+x0], (x1 - x0) * (y1 - y0)
+}
+
+// mean of every pixel of the plane over the square window of radius r
+func boxMean(plane []float64, w, h, r int) []float64 {
+	area := newSummedArea(plane, w, h)
+	mean := make([]float64, len(plane))
+	for y := range h {
+		for x := range w {
+			sum, n := area.sum(x-r, y-r, x+r+1, y+r+1)
+			mean[y*w+x] = sum / float64(n)
+		}
+	}
+	return mean
+}
@@ -0,0 +1,112 @@
+package internal
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+const (
+	BIT_DEPTH_SOURCE = 0
+	BIT_DEPTH_1      = 1
+	BIT_DEPTH_8      = 8
+	BIT_DEPTH_16     = 16
+)
+
+// 4x4 bayer matrix used for ordered dithering when reducing 16 bit channels to 8 bit
+var bitDepthDitherMatrix = newBayerMatrix(4)
+
+type WriteOptions struct {
+	Format   string
+	BitDepth int
+	Dither   bool
+	Metadata *ImageMetadata
+	// gif only, one palette for all frames instead of one per frame
+	GlobalPalette bool
+	// gif only, mediancut, octree or kmeans
+	Quantizer string
+}
+
+func ValidateBitDepth(bitDepth int) error {
+	switch bitDepth {
+	case BIT_DEPTH_SOURCE, BIT_DEPTH_1, BIT_DEPTH_8, BIT_DEPTH_16:
+		return nil
+	default:
+		return errors.New("bit depth has to be 1, 8 or 16")
+	}
+}
+
+func ToRGBA64(img image.Image) *image.RGBA64 {
+	if rgba64, ok := img.(*image.RGBA64); ok {
+		return rgba64
+	}
+
+	rgba64 := image.NewRGBA64(img.Bounds())
+	draw.Draw(rgba64, img.Bounds(), img, img.Bounds().Min, draw.Src)
+	return rgba64
+}
+
+func ToRGBA(img image.Image, dither bool) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok {
+		return rgba
+	}
+
+	bnds := img.Bounds()
+	rgba := image.NewRGBA(bnds)
+
+	for y := bnds.Min.Y; y < bnds.Max.Y; y++ {
+		for x := bnds.Min.X; x < bnds.Max.X; x++ {
+			var threshold uint32 = 0xffff / 2
+			if dither {
+				threshold = uint32(bitDepthDitherMatrix.threshold(x, y) * 0xffff)
+			}
+
+			// the same threshold is used for every channel so premultiplied colors never exceed alpha
+			r, g, b, a := img.At(x, y).RGBA()
+			rgba.SetRGBA(x, y, color.RGBA{
+				reduceChannel(r, threshold),
+				reduceChannel(g, threshold),
+				reduceChannel(b, threshold),
+				reduceChannel(a, threshold),
+			})
+		}
+	}
+
+	return rgba
+}
+
+// reduces the image to black and white pixels, pixels at least half as bright as white turn white,
+// png stores the two color palette with one bit per pixel
+func ToBilevel(img image.Image) *image.Paletted {
+	bnds := img.Bounds()
+	bilevel := image.NewPaletted(bnds, color.Palette{color.Black, color.White})
+
+	for y := bnds.Min.Y; y < bnds.Max.Y; y++ {
+		for x := bnds.Min.X; x < bnds.Max.X; x++ {
+			c := img.At(x, y)
+			if calcIntensity(&c, 8) >= 0x80 {
+				bilevel.SetColorIndex(x, y, 1)
+			}
+		}
+	}
+
+	return bilevel
+}
+
+func reduceChannel(c, threshold uint32) uint8 {
+	return uint8((c*0xff + threshold) / 0xffff)
+}
+
+func convertBitDepth(img image.Image, opts WriteOptions) image.Image {
+	switch opts.BitDepth {
+	case BIT_DEPTH_1:
+		return ToBilevel(img)
+	case BIT_DEPTH_8:
+		return ToRGBA(img, opts.Dither)
+	case BIT_DEPTH_16:
+		return ToRGBA64(img)
+	default:
+		return img
+	}
+}
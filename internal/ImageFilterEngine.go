@@ -3,16 +3,21 @@ package internal
 import (
 	"errors"
 	"fmt"
+	"image"
 	"image/draw"
 	"math"
-	"path/filepath"
+	"os"
 	"runtime"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 )
 
 const (
 	CLEAR_LINE = "\033[u\033[K"
+
+	PIPELINE_SEPARATOR = "+"
 )
 
 type ImageFilterEngineInterface interface {
@@ -21,91 +26,230 @@ type ImageFilterEngineInterface interface {
 	GetOutput() *draw.Image
 	GetOutputFilePath() (string, error)
 	SetOutputFilePath(string)
+	SetWriteOptions(WriteOptions)
+	SetLimits(ImageLimits)
+	SetWorkingSpace(*ColorProfile)
 	WriteOutputFile() (string, error)
 }
 
 type imageFilterEngine[T draw.Image] struct {
 	filePath       string
-	filter         *ImageFilterer[T]
+	filters        []ImageFilterer[T]
 	filterName     string
 	outputFilePath string
 	imgA           *T
 	imgB           *T
 	outputImg      *T
-	wg             sync.WaitGroup
 	switchBuffer   bool
 	coreCount      int
+	writeOptions   WriteOptions
+	limits         ImageLimits
+	workingSpace   *ColorProfile
+}
+
+func NewImageFilterEngineFromImage(filePath, outputFilePath string, img image.Image, coreCount int) (ImageFilterEngineInterface, error) {
+	switch _img := img.(type) {
+	case *image.RGBA64:
+		return NewImageFilterEngine(filePath, outputFilePath, _img, image.NewRGBA64(img.Bounds()), coreCount), nil
+	case *image.RGBA:
+		return NewImageFilterEngine(filePath, outputFilePath, _img, image.NewRGBA(img.Bounds()), coreCount), nil
+	default:
+		return nil, errors.New("unsupported image type")
+	}
 }
 
 func NewImageFilterEngine[T draw.Image](filePath, outputFilePath string, imgA, imgB T, coreCount int) *imageFilterEngine[T] {
-	return &imageFilterEngine[T]{filePath, nil, "", outputFilePath, &imgA, &imgB, &imgB, sync.WaitGroup{}, false, coreCount}
+	return &imageFilterEngine[T]{filePath, nil, "", outputFilePath, &imgA, &imgB, &imgB, false, coreCount, WriteOptions{}, DefaultImageLimits, nil}
 }
 
 func (engine *imageFilterEngine[T]) Run(iterations int) error {
-	if engine.filter == nil {
+	if engine.filters == nil {
 		return errors.New("filter not set")
 	}
 
-	var currMaxProcs int
-	if engine.coreCount == 0 {
-		currMaxProcs = runtime.GOMAXPROCS(0)
-	} else {
-		currMaxProcs = engine.coreCount
-	}
-
+	currMaxProcs := getProcessorCount(engine.coreCount)
 	totalRows := (*engine.imgA).Bounds().Max.Y
 	rowsPerProc := int(math.Ceil(float64(totalRows) / float64(currMaxProcs)))
 
-	fmt.Println("ROWS", totalRows)
-	fmt.Println("PRCS", currMaxProcs)
-	fmt.Println("RPP", rowsPerProc)
+	fmt.Fprintln(os.Stderr, "ROWS", totalRows)
+	fmt.Fprintln(os.Stderr, "PRCS", currMaxProcs)
+	fmt.Fprintln(os.Stderr, "RPP", rowsPerProc)
 
-	prgrsCh := make(chan int, currMaxProcs)
+	// every iteration applies the whole pipeline
+	passes := iterations * len(engine.filters)
 
-	for it := range iterations {
+	for it := range passes {
 		if engine.switchBuffer {
 			engine.switchOutputBuffer()
 		}
 
-		for i := 0; (i+1)*rowsPerProc <= ((*engine.imgA).Bounds().Max.Y - 1 + rowsPerProc); i++ {
-			engine.wg.Add(1)
-			go func(id int) {
-				defer engine.wg.Done()
+		filter := engine.filters[it%len(engine.filters)]
+
+		outputBnds := (*engine.imgA).Bounds()
+		if resizer, ok := filter.(ImageResizer); ok {
+			outputBnds = resizer.OutputBounds(outputBnds)
+		}
+		if (*engine.imgB).Bounds() != outputBnds {
+			// resized images are checked against the same limits as the input image
+			config := image.Config{ColorModel: (*engine.imgA).ColorModel(), Width: outputBnds.Dx(), Height: outputBnds.Dy()}
+			if err := engine.limits.Check(config); err != nil {
+				return err
+			}
+			*engine.imgB = newImage[T](outputBnds)
+		}
 
-				//fmt.Println("STARTED GR", id, "FOR (i,j)", id*rowsPerProc, min((id+1)*rowsPerProc, totalRows))
+		if analyzer, ok := filter.(ImageAnalyzer[T]); ok {
+			runAnalysis(analyzer, *engine.imgA, currMaxProcs)
+		}
 
-				(*engine.filter).Apply(*engine.imgA, *engine.imgB, id*rowsPerProc, min((id+1)*rowsPerProc, totalRows), prgrsCh)
-			}(i)
+		if wavefront, ok := filter.(WavefrontImageFilterer[T]); ok {
+			runWavefront(wavefront, *engine.imgA, *engine.imgB, currMaxProcs, it, passes)
+		} else {
+			runRowBands(outputBnds.Max.Y, currMaxProcs, it, passes, func(startY, endY int, prgrsCh chan int) {
+				filter.Apply(*engine.imgA, *engine.imgB, startY, endY, prgrsCh)
+			})
 		}
 
-		engine.wg.Add(1)
-		go func() {
-			defer engine.wg.Done()
+		engine.switchBuffer = true
+	}
+
+	return nil
+}
+
+func getProcessorCount(coreCount int) int {
+	if coreCount == 0 {
+		return runtime.GOMAXPROCS(0)
+	}
+	return coreCount
+}
+
+// splits the rows into one band per processor, runs apply for every band in parallel and prints the combined progress
+func runRowBands(totalRows, procs, it, iterations int, apply func(startY, endY int, prgrsCh chan int)) {
+	rowsPerProc := max(1, int(math.Ceil(float64(totalRows)/float64(procs))))
+	bands := int(math.Ceil(float64(totalRows) / float64(rowsPerProc)))
+
+	runWorkers(totalRows, bands, it, iterations, func(id int, prgrsCh chan int) {
+		apply(id*rowsPerProc, min((id+1)*rowsPerProc, totalRows), prgrsCh)
+	})
+}
 
-			processedRows := 0
-			for workProgressUpdate := range prgrsCh {
-				processedRows += workProgressUpdate
-				fmt.Print("\r")
-				prgrs := (processedRows * 100) / totalRows
-				fmt.Printf("PRGRS: %3d%%, IT: %d / %d", prgrs, it+1, iterations)
+// hands the rows to the processors round robin, every row waits in sync until the row above
+// is the lag of the filter ahead of it, so the rows are filtered in parallel like a wavefront
+func runWavefront[T draw.Image](filter WavefrontImageFilterer[T], img, filteredImg T, procs, it, iterations int) {
+	bnds := filteredImg.Bounds()
+	totalRows, width, lag := bnds.Max.Y, bnds.Dx(), filter.Lag()
+	progress := make([]atomic.Int64, totalRows)
 
-				if processedRows == totalRows {
-					fmt.Print("\r")
+	filter.Begin(img)
 
-					if it+1 == iterations {
-						fmt.Println()
-					}
+	runWorkers(totalRows, procs, it, iterations, func(id int, prgrsCh chan int) {
+		progressStep := max(1, totalRows/(procs*WORK_PROGRESS_STEP_MULT))
+		doneRows := 0
 
+		for y := id; y < totalRows; y += procs {
+			filter.ApplyRow(img, filteredImg, y, func(x int) {
+				progress[y].Store(int64(x))
+				if y == 0 {
 					return
 				}
+
+				needed := int64(min(x+lag, width))
+				for progress[y-1].Load() < needed {
+					runtime.Gosched()
+				}
+			})
+			progress[y].Store(math.MaxInt64)
+
+			if doneRows++; doneRows == progressStep {
+				prgrsCh <- doneRows
+				doneRows = 0
 			}
-		}()
-		engine.wg.Wait()
+		}
 
-		engine.switchBuffer = true
+		if doneRows > 0 {
+			prgrsCh <- doneRows
+		}
+	})
+}
+
+// runs work for every worker in parallel and prints the combined progress
+func runWorkers(totalRows, workers, it, iterations int, work func(id int, prgrsCh chan int)) {
+	if totalRows == 0 {
+		return
 	}
 
-	return nil
+	var wg sync.WaitGroup
+	prgrsCh := make(chan int, workers)
+
+	for i := range workers {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+
+			work(id, prgrsCh)
+		}(i)
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		processedRows := 0
+		for workProgressUpdate := range prgrsCh {
+			processedRows += workProgressUpdate
+			fmt.Fprint(os.Stderr, "\r")
+			prgrs := (processedRows * 100) / totalRows
+			fmt.Fprintf(os.Stderr, "PRGRS: %3d%%, IT: %d / %d", prgrs, it+1, iterations)
+
+			if processedRows == totalRows {
+				fmt.Fprint(os.Stderr, "\r")
+
+				if it+1 == iterations {
+					fmt.Fprintln(os.Stderr)
+				}
+
+				return
+			}
+		}
+	}()
+	wg.Wait()
+}
+
+// analyzes one row band per processor in parallel and reduces the band results in band order
+func runAnalysis[T draw.Image](analyzer ImageAnalyzer[T], img T, procs int) {
+	totalRows := img.Bounds().Max.Y
+	rowsPerProc := max(1, int(math.Ceil(float64(totalRows)/float64(procs))))
+	results := make([]interface{}, max(1, int(math.Ceil(float64(totalRows)/float64(rowsPerProc)))))
+
+	var wg sync.WaitGroup
+	for i := range results {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+
+			results[id] = analyzer.Analyze(img, id*rowsPerProc, min((id+1)*rowsPerProc, totalRows))
+		}(i)
+	}
+	wg.Wait()
+
+	stats := results[0]
+	for _, result := range results[1:] {
+		stats = analyzer.Merge(stats, result)
+	}
+	analyzer.SetStats(stats)
+}
+
+func newImage[T draw.Image](bnds image.Rectangle) T {
+	var img T
+
+	switch any(img).(type) {
+	case *image.RGBA64:
+		img = any(image.NewRGBA64(bnds)).(T)
+	case *image.RGBA:
+		img = any(image.NewRGBA(bnds)).(T)
+	}
+
+	return img
 }
 
 func (engine *imageFilterEngine[T]) switchOutputBuffer() {
@@ -136,10 +280,10 @@ func (engine *imageFilterEngine[T]) GetOutputFilePath() (string, error) {
 		return engine.outputFilePath, nil
 	}
 
-	if engine.filter == nil {
+	if engine.filters == nil {
 		return "", errors.New("filter not set")
 	} else {
-		return strings.TrimSuffix(engine.filePath, filepath.Ext(engine.filePath)) + "_" + engine.filterName + ".png", nil
+		return DefaultOutputFilePath(engine.filePath, engine.filterName, engine.writeOptions.Format), nil
 	}
 }
 
@@ -147,20 +291,77 @@ func (engine *imageFilterEngine[T]) SetOutputFilePath(outputFilePath string) {
 	engine.outputFilePath = outputFilePath
 }
 
+func (engine *imageFilterEngine[T]) SetWriteOptions(opts WriteOptions) {
+	engine.writeOptions = opts
+}
+
+func (engine *imageFilterEngine[T]) SetLimits(limits ImageLimits) {
+	engine.limits = limits
+}
+
+// the color space the pixels of the image are in, nil for images without one
+func (engine *imageFilterEngine[T]) SetWorkingSpace(profile *ColorProfile) {
+	engine.workingSpace = profile
+	setWorkingSpace(engine.filters, profile)
+}
+
 func (engine *imageFilterEngine[T]) WriteOutputFile() (string, error) {
 	if fileName, err := engine.GetOutputFilePath(); err != nil {
 		return "", err
 	} else {
-		return WriteImage(fileName, engine.outputImg)
+		return WriteImage(fileName, engine.outputImg, engine.writeOptions)
 	}
 }
 
+// filters can be chained to a pipeline by separating them with +, e.g. resize 50% + blur + invert
 func (engine *imageFilterEngine[T]) SetFilter(filterName string, args []string) error {
-	if tmp, err := GetFilter[T](filterName, args); err != nil {
+	filters, names, err := parsePipeline[T](filterName, args)
+	if err != nil {
 		return err
-	} else {
-		engine.filter = &tmp
-		engine.filterName = filterName
-		return nil
 	}
+
+	setWorkingSpace(filters, engine.workingSpace)
+	engine.filters = filters
+	engine.filterName = strings.Join(names, "_")
+	return nil
+}
+
+// passes the working space to the filters of the pipeline which depend on it
+func setWorkingSpace[T draw.Image](filters []ImageFilterer[T], profile *ColorProfile) {
+	for _, filter := range filters {
+		if spaceFilter, ok := filter.(ColorSpaceFilterer); ok {
+			spaceFilter.SetWorkingSpace(profile)
+		}
+	}
+}
+
+// builds the filters of the pipeline stages separated by PIPELINE_SEPARATOR, every stage after the first starts with its filter name
+func parsePipeline[T draw.Image](filterName string, args []string) ([]ImageFilterer[T], []string, error) {
+	var filters []ImageFilterer[T]
+	var names []string
+
+	for len(names) == 0 || len(args) > 0 {
+		stageArgs := args
+		if idx := slices.Index(args, PIPELINE_SEPARATOR); idx >= 0 {
+			stageArgs, args = args[:idx], args[idx+1:]
+		} else {
+			args = nil
+		}
+
+		if len(names) > 0 {
+			if len(stageArgs) == 0 {
+				return nil, nil, errors.New("pipeline stage is missing a filter name after " + PIPELINE_SEPARATOR)
+			}
+			filterName, stageArgs = stageArgs[0], stageArgs[1:]
+		}
+
+		if tmp, err := GetFilter[T](filterName, stageArgs); err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", filterName, err)
+		} else {
+			filters = append(filters, tmp)
+			names = append(names, filterName)
+		}
+	}
+
+	return filters, names, nil
 }
@@ -3,16 +3,136 @@ package internal
 import (
 	"errors"
 	"image"
+	"image/color"
 	"image/draw"
+	"math"
 	"strconv"
+	"strings"
 )
 
 type FilterConstructor func(args []string) (interface{}, error)
 
 var rgba64FilterConstructors = map[string]FilterConstructor{
+	"equalize": func(args []string) (interface{}, error) {
+		return &EqualizeRGBA64Filter{equalizeParams{bins: 0x10000}}, nil
+	},
+	"clahe": func(args []string) (interface{}, error) {
+		params, err := parseClaheParams(args)
+		return &ClaheRGBA64Filter{params}, err
+	},
+	"autolevels": func(args []string) (interface{}, error) {
+		params, err := parseAutoLevelsParams(args)
+		return &AutoLevelsRGBA64Filter{params, nil}, err
+	},
+	"whitebalance": func(args []string) (interface{}, error) {
+		params, err := parseWhiteBalanceParams(args)
+		return &WhiteBalanceRGBA64Filter{params, nil}, err
+	},
+	"threshold": func(args []string) (interface{}, error) {
+		params, err := parseThresholdParams(args)
+		return &ThresholdRGBA64Filter{params}, err
+	},
+	"dither": func(args []string) (interface{}, error) {
+		if isErrorDiffusion(args) {
+			params, err := parseDitherParams(args)
+			return &DitherRGBA64Filter{params}, err
+		}
+		params, err := parseOrderedDitherParams(args)
+		return &OrderedDitherRGBA64Filter{params}, err
+	},
+	"quantize":    newQuantizeRGBA64Filter,
+	"levels":      rgba64ToneConstructor(parseLevels),
+	"brightness":  rgba64ToneConstructor(parseBrightness),
+	"contrast":    rgba64ToneConstructor(parseContrast),
+	"exposure":    rgba64ToneConstructor(parseExposure),
+	"gamma":       rgba64ToneConstructor(parseGamma),
+	"curves":      rgba64ToneConstructor(parseCurves),
+	"hue":         rgba64SpaceColorConstructor(parseHue),
+	"saturation":  rgba64ColorConstructor(parseSaturation),
+	"vibrance":    rgba64ColorConstructor(parseVibrance),
+	"lightness":   rgba64SpaceColorConstructor(parseLightness),
+	"selective":   rgba64ColorConstructor(parseSelectiveColor),
+	"lut":         rgba64ColorConstructor(parseLUT),
+	"grayscale":   rgba64ColorConstructor(parseGrayscale),
+	"sepia":       rgba64ColorConstructor(parseSepia),
+	"duotone":     rgba64ColorConstructor(parseDuotone),
+	"tritone":     rgba64ColorConstructor(parseTritone),
+	"mixer":       rgba64ColorConstructor(parseChannelMixer),
+	"temperature": rgba64SpaceColorConstructor(parseTemperature),
+	"erode":       rgba64MorphologyConstructor(MORPH_ERODE),
+	"dilate":      rgba64MorphologyConstructor(MORPH_DILATE),
+	"open":        rgba64MorphologyConstructor(MORPH_OPEN),
+	"close":       rgba64MorphologyConstructor(MORPH_CLOSE),
+	"gradient":    rgba64MorphologyConstructor(MORPH_GRADIENT),
+	"tophat":      rgba64MorphologyConstructor(MORPH_TOP_HAT),
+	"blackhat":    rgba64MorphologyConstructor(MORPH_BLACK_HAT),
+	"bilateral": func(args []string) (interface{}, error) {
+		params, err := parseBilateralParams(args)
+		return &BilateralRGBA64Filter{params}, err
+	},
+	"guided": func(args []string) (interface{}, error) {
+		params, err := parseGuidedParams(args)
+		return &GuidedRGBA64Filter{params}, err
+	},
+	"kuwahara": func(args []string) (interface{}, error) {
+		params, err := parseKuwaharaParams(args)
+		return &KuwaharaRGBA64Filter{params}, err
+	},
+	"outline": func(args []string) (interface{}, error) {
+		params, err := parseOutlineParams(args)
+		return &OutlineRGBA64Filter{params}, err
+	},
 	"invert": func(args []string) (interface{}, error) {
 		return &InvertRGBA64Filter{}, nil
 	},
+	"resize": func(args []string) (interface{}, error) {
+		params, err := parseResizeParams(args)
+		return &ResizeRGBA64Filter{params}, err
+	},
+	"crop": func(args []string) (interface{}, error) {
+		params, err := parseCropParams(args)
+		return &InverseMapRGBA64Filter{params}, err
+	},
+	"flip": func(args []string) (interface{}, error) {
+		params, err := parseFlipParams(args)
+		return &InverseMapRGBA64Filter{params}, err
+	},
+	"rot90": func(args []string) (interface{}, error) {
+		params, err := parseRot90Params(args)
+		return &InverseMapRGBA64Filter{params}, err
+	},
+	"rotate": func(args []string) (interface{}, error) {
+		params, err := parseRotateParams(args)
+		return &InverseMapRGBA64Filter{params}, err
+	},
+	"affine": func(args []string) (interface{}, error) {
+		params, err := parseAffineParams(args)
+		return &InverseMapRGBA64Filter{params}, err
+	},
+	"perspective": func(args []string) (interface{}, error) {
+		params, err := parsePerspectiveParams(args)
+		return &InverseMapRGBA64Filter{params}, err
+	},
+	"lens": func(args []string) (interface{}, error) {
+		params, err := parseLensParams(args)
+		return &InverseMapRGBA64Filter{params}, err
+	},
+	"swirl": func(args []string) (interface{}, error) {
+		params, err := parseSwirlParams(args)
+		return &InverseMapRGBA64Filter{params}, err
+	},
+	"ripple": func(args []string) (interface{}, error) {
+		params, err := parseRippleParams(args)
+		return &InverseMapRGBA64Filter{params}, err
+	},
+	"fisheye": func(args []string) (interface{}, error) {
+		params, err := parseFisheyeParams(args)
+		return &InverseMapRGBA64Filter{params}, err
+	},
+	"polar": func(args []string) (interface{}, error) {
+		params, err := parsePolarParams(args)
+		return &InverseMapRGBA64Filter{params}, err
+	},
 	"blur": func(args []string) (interface{}, error) {
 		return &BlurRGBA64Filter{}, nil
 	},
@@ -20,10 +140,10 @@ var rgba64FilterConstructors = map[string]FilterConstructor{
 		var colorStep uint16
 		if len(args) >= 1 {
 			ui32, err := strconv.ParseUint(args[0], 10, 32)
-			if err != nil {
-				return nil, errors.New("filter requires color-step count (uint32) as first non-flag argument")
+			if err != nil || ui32 == 0 || ui32 > 0xff {
+				return nil, errors.New("filter requires color-step count (int between 1 and 255) as first non-flag argument")
 			}
-			colorStep = uint16(ui32)
+			colorStep = 0xffff / uint16(ui32)
 		} else {
 			colorStep = 0xffff / 3
 		}
@@ -45,7 +165,14 @@ var rgba64FilterConstructors = map[string]FilterConstructor{
 		if err != nil {
 			return nil, err
 		}
-		return &SpotRGBA64Filter{spotX, spotY, spotR}, nil
+		if !(spotR > 0) || math.IsInf(spotR, 0) {
+			return nil, errors.New("spot radius needs to be greater than 0")
+		}
+		return &VignetteRGBA64Filter{newSpotVignette(spotX, spotY, spotR)}, nil
+	},
+	"vignette": func(args []string) (interface{}, error) {
+		params, err := parseVignetteParams(args)
+		return &VignetteRGBA64Filter{params}, err
 	},
 	"edge": func(args []string) (interface{}, error) {
 		var amp int64
@@ -62,7 +189,11 @@ var rgba64FilterConstructors = map[string]FilterConstructor{
 		return &EdgeRGBA64Filter{amp}, nil
 	},
 	"heat": func(args []string) (interface{}, error) {
-		return &HeatRGBA64Filter{}, nil
+		return &ColormapRGBA64Filter{newHeatColormap()}, nil
+	},
+	"colormap": func(args []string) (interface{}, error) {
+		params, err := parseColormapParams(args)
+		return &ColormapRGBA64Filter{params}, err
 	},
 	"gaussianblur": func(args []string) (interface{}, error) {
 		if len(args) >= 2 {
@@ -89,9 +220,126 @@ var rgba64FilterConstructors = map[string]FilterConstructor{
 }
 
 var rgbaFilterConstructors = map[string]FilterConstructor{
+	"equalize": func(args []string) (interface{}, error) {
+		return &EqualizeRGBAFilter{equalizeParams{bins: 0x100}}, nil
+	},
+	"clahe": func(args []string) (interface{}, error) {
+		params, err := parseClaheParams(args)
+		return &ClaheRGBAFilter{params}, err
+	},
+	"autolevels": func(args []string) (interface{}, error) {
+		params, err := parseAutoLevelsParams(args)
+		return &AutoLevelsRGBAFilter{params, nil}, err
+	},
+	"whitebalance": func(args []string) (interface{}, error) {
+		params, err := parseWhiteBalanceParams(args)
+		return &WhiteBalanceRGBAFilter{params, nil}, err
+	},
+	"threshold": func(args []string) (interface{}, error) {
+		params, err := parseThresholdParams(args)
+		return &ThresholdRGBAFilter{params}, err
+	},
+	"dither": func(args []string) (interface{}, error) {
+		if isErrorDiffusion(args) {
+			params, err := parseDitherParams(args)
+			return &DitherRGBAFilter{params}, err
+		}
+		params, err := parseOrderedDitherParams(args)
+		return &OrderedDitherRGBAFilter{params}, err
+	},
+	"quantize":    newQuantizeRGBAFilter,
+	"levels":      rgbaToneConstructor(parseLevels),
+	"brightness":  rgbaToneConstructor(parseBrightness),
+	"contrast":    rgbaToneConstructor(parseContrast),
+	"exposure":    rgbaToneConstructor(parseExposure),
+	"gamma":       rgbaToneConstructor(parseGamma),
+	"curves":      rgbaToneConstructor(parseCurves),
+	"hue":         rgbaSpaceColorConstructor(parseHue),
+	"saturation":  rgbaColorConstructor(parseSaturation),
+	"vibrance":    rgbaColorConstructor(parseVibrance),
+	"lightness":   rgbaSpaceColorConstructor(parseLightness),
+	"selective":   rgbaColorConstructor(parseSelectiveColor),
+	"lut":         rgbaColorConstructor(parseLUT),
+	"grayscale":   rgbaColorConstructor(parseGrayscale),
+	"sepia":       rgbaColorConstructor(parseSepia),
+	"duotone":     rgbaColorConstructor(parseDuotone),
+	"tritone":     rgbaColorConstructor(parseTritone),
+	"mixer":       rgbaColorConstructor(parseChannelMixer),
+	"temperature": rgbaSpaceColorConstructor(parseTemperature),
+	"erode":       rgbaMorphologyConstructor(MORPH_ERODE),
+	"dilate":      rgbaMorphologyConstructor(MORPH_DILATE),
+	"open":        rgbaMorphologyConstructor(MORPH_OPEN),
+	"close":       rgbaMorphologyConstructor(MORPH_CLOSE),
+	"gradient":    rgbaMorphologyConstructor(MORPH_GRADIENT),
+	"tophat":      rgbaMorphologyConstructor(MORPH_TOP_HAT),
+	"blackhat":    rgbaMorphologyConstructor(MORPH_BLACK_HAT),
+	"bilateral": func(args []string) (interface{}, error) {
+		params, err := parseBilateralParams(args)
+		return &BilateralRGBAFilter{params}, err
+	},
+	"guided": func(args []string) (interface{}, error) {
+		params, err := parseGuidedParams(args)
+		return &GuidedRGBAFilter{params}, err
+	},
+	"kuwahara": func(args []string) (interface{}, error) {
+		params, err := parseKuwaharaParams(args)
+		return &KuwaharaRGBAFilter{params}, err
+	},
+	"outline": func(args []string) (interface{}, error) {
+		params, err := parseOutlineParams(args)
+		return &OutlineRGBAFilter{params}, err
+	},
 	"invert": func(args []string) (interface{}, error) {
 		return &InvertRGBAFilter{}, nil
 	},
+	"resize": func(args []string) (interface{}, error) {
+		params, err := parseResizeParams(args)
+		return &ResizeRGBAFilter{params}, err
+	},
+	"crop": func(args []string) (interface{}, error) {
+		params, err := parseCropParams(args)
+		return &InverseMapRGBAFilter{params}, err
+	},
+	"flip": func(args []string) (interface{}, error) {
+		params, err := parseFlipParams(args)
+		return &InverseMapRGBAFilter{params}, err
+	},
+	"rot90": func(args []string) (interface{}, error) {
+		params, err := parseRot90Params(args)
+		return &InverseMapRGBAFilter{params}, err
+	},
+	"rotate": func(args []string) (interface{}, error) {
+		params, err := parseRotateParams(args)
+		return &InverseMapRGBAFilter{params}, err
+	},
+	"affine": func(args []string) (interface{}, error) {
+		params, err := parseAffineParams(args)
+		return &InverseMapRGBAFilter{params}, err
+	},
+	"perspective": func(args []string) (interface{}, error) {
+		params, err := parsePerspectiveParams(args)
+		return &InverseMapRGBAFilter{params}, err
+	},
+	"lens": func(args []string) (interface{}, error) {
+		params, err := parseLensParams(args)
+		return &InverseMapRGBAFilter{params}, err
+	},
+	"swirl": func(args []string) (interface{}, error) {
+		params, err := parseSwirlParams(args)
+		return &InverseMapRGBAFilter{params}, err
+	},
+	"ripple": func(args []string) (interface{}, error) {
+		params, err := parseRippleParams(args)
+		return &InverseMapRGBAFilter{params}, err
+	},
+	"fisheye": func(args []string) (interface{}, error) {
+		params, err := parseFisheyeParams(args)
+		return &InverseMapRGBAFilter{params}, err
+	},
+	"polar": func(args []string) (interface{}, error) {
+		params, err := parsePolarParams(args)
+		return &InverseMapRGBAFilter{params}, err
+	},
 	"blur": func(args []string) (interface{}, error) {
 		return &BlurRGBAFilter{}, nil
 	},
@@ -99,8 +347,8 @@ var rgbaFilterConstructors = map[string]FilterConstructor{
 		var colorStep uint8
 		if len(args) >= 1 {
 			ui32, err := strconv.ParseUint(args[0], 10, 32)
-			if err != nil {
-				return nil, errors.New("filter requires color-step count (uint32) as first non-flag argument")
+			if err != nil || ui32 == 0 || ui32 > 0xff {
+				return nil, errors.New("filter requires color-step count (int between 1 and 255) as first non-flag argument")
 			}
 			colorStep = 0xff / uint8(ui32)
 		} else {
@@ -124,7 +372,14 @@ var rgbaFilterConstructors = map[string]FilterConstructor{
 		if err != nil {
 			return nil, err
 		}
-		return &SpotRGBAFilter{spotX, spotY, spotR}, nil
+		if !(spotR > 0) || math.IsInf(spotR, 0) {
+			return nil, errors.New("spot radius needs to be greater than 0")
+		}
+		return &VignetteRGBAFilter{newSpotVignette(spotX, spotY, spotR)}, nil
+	},
+	"vignette": func(args []string) (interface{}, error) {
+		params, err := parseVignetteParams(args)
+		return &VignetteRGBAFilter{params}, err
 	},
 	"edge": func(args []string) (interface{}, error) {
 		var amp int64
@@ -141,7 +396,11 @@ var rgbaFilterConstructors = map[string]FilterConstructor{
 		return &EdgeRGBAFilter{amp}, nil
 	},
 	"heat": func(args []string) (interface{}, error) {
-		return &HeatRGBAFilter{}, nil
+		return &ColormapRGBAFilter{newHeatColormap()}, nil
+	},
+	"colormap": func(args []string) (interface{}, error) {
+		params, err := parseColormapParams(args)
+		return &ColormapRGBAFilter{params}, err
 	},
 	"gaussianblur": func(args []string) (interface{}, error) {
 		if len(args) >= 2 {
@@ -167,6 +426,80 @@ var rgbaFilterConstructors = map[string]FilterConstructor{
 	},
 }
 
+func rgba64ToneConstructor(parse func([]string) (ToneFunc, error)) FilterConstructor {
+	return func(args []string) (interface{}, error) {
+		fn, err := parse(args)
+		if err != nil {
+			return nil, err
+		}
+		return NewToneRGBA64Filter(fn), nil
+	}
+}
+
+func rgbaToneConstructor(parse func([]string) (ToneFunc, error)) FilterConstructor {
+	return func(args []string) (interface{}, error) {
+		fn, err := parse(args)
+		if err != nil {
+			return nil, err
+		}
+		return NewToneRGBAFilter(fn), nil
+	}
+}
+
+func rgba64ColorConstructor(parse func([]string) (ColorFunc, error)) FilterConstructor {
+	return func(args []string) (interface{}, error) {
+		fn, err := parse(args)
+		if err != nil {
+			return nil, err
+		}
+		return NewColorRGBA64Filter(fn), nil
+	}
+}
+
+func rgbaColorConstructor(parse func([]string) (ColorFunc, error)) FilterConstructor {
+	return func(args []string) (interface{}, error) {
+		fn, err := parse(args)
+		if err != nil {
+			return nil, err
+		}
+		return NewColorRGBAFilter(fn), nil
+	}
+}
+
+func rgba64SpaceColorConstructor(parse func([]string) (SpaceColorFunc, error)) FilterConstructor {
+	return func(args []string) (interface{}, error) {
+		build, err := parse(args)
+		if err != nil {
+			return nil, err
+		}
+		return NewSpaceColorRGBA64Filter(build), nil
+	}
+}
+
+func rgbaSpaceColorConstructor(parse func([]string) (SpaceColorFunc, error)) FilterConstructor {
+	return func(args []string) (interface{}, error) {
+		build, err := parse(args)
+		if err != nil {
+			return nil, err
+		}
+		return NewSpaceColorRGBAFilter(build), nil
+	}
+}
+
+func rgba64MorphologyConstructor(op string) FilterConstructor {
+	return func(args []string) (interface{}, error) {
+		params, err := parseMorphologyParams(op, args)
+		return &MorphologyRGBA64Filter{params}, err
+	}
+}
+
+func rgbaMorphologyConstructor(op string) FilterConstructor {
+	return func(args []string) (interface{}, error) {
+		params, err := parseMorphologyParams(op, args)
+		return &MorphologyRGBAFilter{params}, err
+	}
+}
+
 func GetFilter[T draw.Image](filterName string, args []string) (ImageFilterer[T], error) {
 	var img T
 	var constructor FilterConstructor
@@ -195,3 +528,117 @@ func GetFilter[T draw.Image](filterName string, args []string) (ImageFilterer[T]
 
 	return nil, errors.New("filter type mismatch")
 }
+
+// parses #rrggbb, #rrggbbaa or a color name into a premultiplied color
+func parseColor(s string) (color.RGBA64, error) {
+	switch strings.ToLower(s) {
+	case "transparent":
+		return color.RGBA64{}, nil
+	case "black":
+		return color.RGBA64{0, 0, 0, 0xffff}, nil
+	case "white":
+		return color.RGBA64{0xffff, 0xffff, 0xffff, 0xffff}, nil
+	}
+
+	hex := strings.TrimPrefix(s, "#")
+	if len(hex) != 6 && len(hex) != 8 {
+		return color.RGBA64{}, errors.New("color needs to be #rrggbb, #rrggbbaa, black, white or transparent")
+	}
+	if len(hex) == 6 {
+		hex += "ff"
+	}
+
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return color.RGBA64{}, errors.New("color needs to be #rrggbb, #rrggbbaa, black, white or transparent")
+	}
+
+	c := color.NRGBA{uint8(v >> 24), uint8(v >> 16), uint8(v >> 8), uint8(v)}
+	return color.RGBA64Model.Convert(c).(color.RGBA64), nil
+}
+
+func parseTemporalRadius(args []string) (int, error) {
+	if len(args) >= 1 {
+		radius, err := strconv.Atoi(args[0])
+		if err != nil || radius < 1 {
+			return 0, errors.New("first non-flag argument needs to be the frame radius of the window (int >= 1)")
+		}
+		return radius, nil
+	}
+	return 1, nil
+}
+
+func parseEmaAlpha(args []string) (float64, error) {
+	if len(args) >= 1 {
+		alpha, err := strconv.ParseFloat(args[0], 64)
+		if err != nil || alpha <= 0 || alpha > 1 {
+			return 0, errors.New("first non-flag argument needs to be the weight of the current frame (float in (0, 1])")
+		}
+		return alpha, nil
+	}
+	return 0.3, nil
+}
+
+var rgba64TemporalFilterConstructors = map[string]FilterConstructor{
+	"tmedian": func(args []string) (interface{}, error) {
+		radius, err := parseTemporalRadius(args)
+		return &TemporalMedianRGBA64Filter{radius}, err
+	},
+	"tavg": func(args []string) (interface{}, error) {
+		radius, err := parseTemporalRadius(args)
+		return &TemporalAverageRGBA64Filter{radius}, err
+	},
+	"ema": func(args []string) (interface{}, error) {
+		alpha, err := parseEmaAlpha(args)
+		return &TemporalEmaRGBA64Filter{alpha}, err
+	},
+}
+
+var rgbaTemporalFilterConstructors = map[string]FilterConstructor{
+	"tmedian": func(args []string) (interface{}, error) {
+		radius, err := parseTemporalRadius(args)
+		return &TemporalMedianRGBAFilter{radius}, err
+	},
+	"tavg": func(args []string) (interface{}, error) {
+		radius, err := parseTemporalRadius(args)
+		return &TemporalAverageRGBAFilter{radius}, err
+	},
+	"ema": func(args []string) (interface{}, error) {
+		alpha, err := parseEmaAlpha(args)
+		return &TemporalEmaRGBAFilter{alpha}, err
+	},
+}
+
+func IsTemporalFilter(filterName string) bool {
+	_, found := rgbaTemporalFilterConstructors[filterName]
+	return found
+}
+
+func GetTemporalFilter[T draw.Image](filterName string, args []string) (TemporalImageFilterer[T], error) {
+	var img T
+	var constructor FilterConstructor
+	var found bool
+
+	switch any(img).(type) {
+	case *image.RGBA64:
+		constructor, found = rgba64TemporalFilterConstructors[filterName]
+	case *image.RGBA:
+		constructor, found = rgbaTemporalFilterConstructors[filterName]
+	default:
+		return nil, errors.New("unsupported image type")
+	}
+
+	if !found {
+		return nil, errors.New("unknown temporal filter type")
+	}
+
+	filter, err := constructor(args)
+	if err != nil {
+		return nil, err
+	}
+	if tf, ok := filter.(TemporalImageFilterer[T]); ok {
+		return tf, nil
+	}
+
+	return nil, errors.New("filter type mismatch")
+}
@@ -1,9 +1,53 @@
 package internal
 
 import (
+	"image"
 	"image/draw"
 )
 
 type ImageFilterer[T draw.Image] interface {
 	Apply(T, T, int, int, chan int)
 }
+
+// filters working on a window of neighbouring frames of an image sequence
+// the window holds up to Radius() frames before and after the current one,
+// previous is the filtered output of the previous frame (the current input frame for the first frame)
+type TemporalImageFilterer[T draw.Image] interface {
+	Radius() int
+	Apply(window []T, current int, previous, filteredImg T, startY, endY int, prgrsCh chan int)
+}
+
+// filters whose output size differs from their input, the engine allocates the output buffer
+// with the returned bounds and splits the rows of the output image between the processors
+type ImageResizer interface {
+	OutputBounds(image.Rectangle) image.Rectangle
+}
+
+// filters that need image wide statistics before their rows can be filtered, the engine runs Analyze for
+// one row band of the input image per processor in parallel, reduces the band results in order with Merge
+// and hands the statistics of the whole image to SetStats before it calls Apply
+type ImageAnalyzer[T draw.Image] interface {
+	Analyze(img T, startY, endY int) interface{}
+	Merge(a, b interface{}) interface{}
+	SetStats(interface{})
+}
+
+// filters whose pixels depend on the filtered pixels before them, like error diffusion dithering,
+// the engine calls Begin once per pass and filters the rows in parallel as a wavefront instead of in bands,
+// ApplyRow has to call sync before it filters pixel x, which waits until the row above is Lag pixels ahead
+type WavefrontImageFilterer[T draw.Image] interface {
+	Lag() int
+	Begin(img T)
+	ApplyRow(img, filteredImg T, y int, sync func(x int))
+}
+
+// filters whose output pixel only depends on the color of the input pixel, a pipeline of them can be exported as a color lut
+type PixelFilterer interface {
+	PixelFunc() ColorFunc
+}
+
+// filters whose colors depend on the primaries and transfer curve of the image, the engine passes the working space
+// before it applies the filter, nil for images without a color space, until then the filters assume srgb
+type ColorSpaceFilterer interface {
+	SetWorkingSpace(*ColorProfile)
+}
@@ -1,43 +1,234 @@
 package internal
 
 import (
+	"bytes"
 	"errors"
 	"image"
 	"image/draw"
-	_ "image/jpeg"
+	"image/gif"
+	"image/jpeg"
 	"image/png"
+	"io"
 	"os"
+	"path/filepath"
+	"strings"
 )
 
+const (
+	JPEG_QUALITY = 90
+
+	STDIO_PATH = "-"
+
+	FORMAT_PNG  = "png"
+	FORMAT_JPEG = "jpeg"
+	FORMAT_GIF  = "gif"
+)
+
+var errUnknownFormat = errors.New("unknown output format, available: png, jpeg, gif")
+
+type ReadOptions struct {
+	AutoOrient   bool
+	WorkingSpace *ColorProfile
+	Limits       ImageLimits
+}
+
 func ReadImage(filepath string) (image.Image, error) {
-	if file, err := os.Open(filepath); err != nil {
-		return nil, err
+	img, _, err := ReadImageWithMetadata(filepath, ReadOptions{AutoOrient: true, WorkingSpace: ColorProfileSRGB, Limits: DefaultImageLimits})
+	return img, err
+}
+
+func ReadImageWithMetadata(filepath string, opts ReadOptions) (image.Image, *ImageMetadata, error) {
+	if data, err := ReadInputFile(filepath); err != nil {
+		return nil, nil, err
 	} else {
-		defer file.Close()
-		img, _, err := image.Decode(file)
-
-		switch img.(type) {
-		case *image.RGBA64:
-		case *image.RGBA:
-		case *image.YCbCr:
-			rgbaImg := image.NewRGBA(img.Bounds())
-			draw.Draw(rgbaImg, img.Bounds(), img, image.Point{}, draw.Src)
-			img = rgbaImg
-		default:
-			return nil, errors.New("unsupported image type")
+		return DecodeImage(data, opts)
+	}
+}
+
+// the whole input is buffered, the metadata has to be parsed from the same bytes as the image
+func ReadInputFile(filepath string) ([]byte, error) {
+	if filepath == STDIO_PATH {
+		return io.ReadAll(os.Stdin)
+	}
+
+	return os.ReadFile(filepath)
+}
+
+func DecodeImage(data []byte, opts ReadOptions) (image.Image, *ImageMetadata, error) {
+	// the header is checked first, so a crafted image can't allocate huge buffers during decoding
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := opts.Limits.Check(config); err != nil {
+		return nil, nil, err
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch img.(type) {
+	case *image.RGBA64:
+	case *image.RGBA:
+	case *image.NRGBA64, *image.Gray16:
+		img = ToRGBA64(img)
+	default:
+		rgbaImg := image.NewRGBA(img.Bounds())
+		draw.Draw(rgbaImg, img.Bounds(), img, img.Bounds().Min, draw.Src)
+		img = rgbaImg
+	}
+
+	meta := ParseMetadata(data)
+	dropUnsupportedIcc(meta)
+	if opts.AutoOrient && meta.Orientation != 1 {
+		img = ApplyOrientation(img, meta.Orientation)
+		meta.Exif = setExifOrientation(meta.Exif, 1)
+		meta.Orientation = 1
+	}
+
+	if srcProfile := workingSpaceSource(meta, opts.WorkingSpace); srcProfile != nil {
+		ConvertColorProfile(img, srcProfile, opts.WorkingSpace)
+		meta.Icc = opts.WorkingSpace.Icc()
+	}
+
+	return img, meta, nil
+}
+
+// drops embedded profiles that aren't parseable matrix/TRC based rgb profiles, gray, cmyk and lut based
+// profiles don't describe the decoded rgb pixels and would tag the output with the wrong color space
+func dropUnsupportedIcc(meta *ImageMetadata) {
+	if meta.Icc == nil {
+		return
+	}
+	if _, err := ParseIccProfile(meta.Icc); err != nil {
+		meta.Icc = nil
+	}
+}
+
+// returns the profile the pixels have to be converted from, nil if they stay untouched
+func workingSpaceSource(meta *ImageMetadata, workingSpace *ColorProfile) *ColorProfile {
+	if workingSpace == nil {
+		return nil
+	}
+
+	// untagged images are treated as sRGB and stay untagged if that is the working space
+	if meta.Icc == nil {
+		if workingSpace == ColorProfileSRGB {
+			return nil
 		}
+		return ColorProfileSRGB
+	}
 
-		return img, err
+	// unsupported profiles are dropped while decoding, a failing profile keeps the pixels as they are
+	if profile, err := ParseIccProfile(meta.Icc); err != nil {
+		return nil
+	} else {
+		return profile
 	}
 }
 
-func WriteImage[T draw.Image](filepath string, img *T) (string, error) {
-	if ofs, err := os.Create(filepath); err != nil {
+func WriteImage[T draw.Image](filePath string, img *T, opts WriteOptions) (string, error) {
+	format, err := ResolveOutputFormat(filePath, opts.Format)
+	if err != nil {
 		return "", err
-	} else {
-		defer ofs.Close()
-		png.Encode(ofs, *img)
+	}
+	opts.Format = format
+
+	if filePath == STDIO_PATH {
+		if err := EncodeImage(os.Stdout, *img, opts); err != nil {
+			return "", err
+		}
+		return "stdout", nil
+	}
+
+	var encoded bytes.Buffer
+	if err := EncodeImage(&encoded, *img, opts); err != nil {
+		return "", err
+	}
 
-		return ofs.Name(), nil
+	if err := os.WriteFile(filePath, encoded.Bytes(), 0644); err != nil {
+		return "", err
+	}
+
+	return filePath, nil
+}
+
+func EncodeImage(w io.Writer, img image.Image, opts WriteOptions) error {
+	var encoded bytes.Buffer
+	var err error
+
+	outputImg := convertBitDepth(img, opts)
+
+	switch opts.Format {
+	case FORMAT_GIF:
+		palette := BuildPalette([]image.Image{outputImg}, GIF_PALETTE_SIZE, opts.Quantizer)
+		return gif.Encode(w, Palettize(outputImg, palette, opts.Dither), nil)
+	case FORMAT_JPEG:
+		err = jpeg.Encode(&encoded, outputImg, &jpeg.Options{Quality: JPEG_QUALITY})
+	case FORMAT_PNG, "":
+		err = png.Encode(&encoded, outputImg)
+	default:
+		err = errUnknownFormat
+	}
+	if err != nil {
+		return err
 	}
+
+	data := encoded.Bytes()
+	if opts.Metadata != nil {
+		if opts.Format == FORMAT_JPEG {
+			data, err = embedJpegMetadata(data, opts.Metadata)
+		} else {
+			data, err = embedPngMetadata(data, opts.Metadata)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = w.Write(data)
+	return err
+}
+
+func ResolveOutputFormat(filePath, format string) (string, error) {
+	switch format {
+	case FORMAT_PNG, FORMAT_JPEG, FORMAT_GIF:
+		return format, nil
+	case "jpg":
+		return FORMAT_JPEG, nil
+	case "":
+	default:
+		return "", errUnknownFormat
+	}
+
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".jpg", ".jpeg":
+		return FORMAT_JPEG, nil
+	case ".gif":
+		return FORMAT_GIF, nil
+	default:
+		return FORMAT_PNG, nil
+	}
+}
+
+func FormatExtension(format string) string {
+	switch format {
+	case FORMAT_JPEG, "jpg":
+		return ".jpg"
+	case FORMAT_GIF:
+		return ".gif"
+	default:
+		return ".png"
+	}
+}
+
+func DefaultOutputFilePath(filePath, filterName, format string) string {
+	// images read from stdin are written to stdout unless an output path is given
+	if filePath == STDIO_PATH {
+		return STDIO_PATH
+	}
+
+	return strings.TrimSuffix(filePath, filepath.Ext(filePath)) + "_" + filterName + FormatExtension(format)
 }
@@ -131,3 +131,25 @@ func (iter *imageIterator[T]) Next() *imageIteratorYield {
 
 	return &iter.current
 }
+
+// reports finished rows of filters that don't use an image iterator in the same steps as the iterator does
+type rowProgressReporter struct {
+	prgrsCh            chan int
+	startY, endY, curY int
+	workProgressStep   int
+}
+
+func newRowProgressReporter(startY, endY int, prgrsCh chan int) *rowProgressReporter {
+	workProgressStep := int(math.Max(float64((endY-startY)/WORK_PROGRESS_STEP_MULT), 1))
+	return &rowProgressReporter{prgrsCh, startY, endY, startY, workProgressStep}
+}
+
+func (reporter *rowProgressReporter) RowDone() {
+	reporter.curY++
+
+	if (reporter.curY-reporter.startY)%reporter.workProgressStep == 0 {
+		reporter.prgrsCh <- reporter.workProgressStep
+	} else if reporter.curY == reporter.endY {
+		reporter.prgrsCh <- (reporter.endY - reporter.startY) % reporter.workProgressStep
+	}
+}
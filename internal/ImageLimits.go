@@ -0,0 +1,101 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+)
+
+const (
+	// computed output sizes are clamped to this width and height, so huge sizes reach the limits check without overflowing
+	MAX_OUTPUT_DIMENSION = 1 << 24
+)
+
+var (
+	ErrImageLimitExceeded = errors.New("image exceeds resource limits")
+	ErrPixelLimit         = errors.New("pixel count limit exceeded")
+	ErrDimensionLimit     = errors.New("dimension limit exceeded")
+	ErrMemoryLimit        = errors.New("estimated memory limit exceeded")
+
+	DefaultImageLimits = ImageLimits{MaxPixels: 200_000_000, MaxMemory: 4 << 30}
+)
+
+// a zero value disables the corresponding limit
+type ImageLimits struct {
+	MaxPixels    int64
+	MaxDimension int
+	MaxMemory    int64
+}
+
+type LimitError struct {
+	Kind  error
+	Value int64
+	Limit int64
+}
+
+func (err *LimitError) Error() string {
+	return fmt.Sprintf("%v: %v (%d > %d)", ErrImageLimitExceeded, err.Kind, err.Value, err.Limit)
+}
+
+func (err *LimitError) Unwrap() []error {
+	return []error{err.Kind, ErrImageLimitExceeded}
+}
+
+func (limits ImageLimits) Check(config image.Config) error {
+	width, height := int64(config.Width), int64(config.Height)
+
+	if limits.MaxDimension > 0 {
+		if width > int64(limits.MaxDimension) {
+			return &LimitError{ErrDimensionLimit, width, int64(limits.MaxDimension)}
+		}
+		if height > int64(limits.MaxDimension) {
+			return &LimitError{ErrDimensionLimit, height, int64(limits.MaxDimension)}
+		}
+	}
+
+	pixels := width * height
+	if limits.MaxPixels > 0 && pixels > limits.MaxPixels {
+		return &LimitError{ErrPixelLimit, pixels, limits.MaxPixels}
+	}
+
+	if memory := EstimateMemory(config); limits.MaxMemory > 0 && memory > limits.MaxMemory {
+		return &LimitError{ErrMemoryLimit, memory, limits.MaxMemory}
+	}
+
+	return nil
+}
+
+// rectangle at the origin of the rounded width and height, clamped between 1 and MAX_OUTPUT_DIMENSION
+func outputRect(width, height float64) image.Rectangle {
+	clamp := func(v float64) int {
+		if math.IsNaN(v) {
+			return MAX_OUTPUT_DIMENSION
+		}
+		return int(math.Max(1, math.Min(v, MAX_OUTPUT_DIMENSION)))
+	}
+	return image.Rect(0, 0, clamp(width), clamp(height))
+}
+
+// estimates the memory of the decoded image, the converted working image and the second buffer of the filter engine
+func EstimateMemory(config image.Config) int64 {
+	var decodedBytes, workingBytes int64 = 4, 4
+
+	switch config.ColorModel {
+	case color.RGBA64Model, color.NRGBA64Model:
+		decodedBytes, workingBytes = 8, 8
+	case color.Gray16Model:
+		decodedBytes, workingBytes = 2, 8
+	case color.GrayModel, color.AlphaModel:
+		decodedBytes = 1
+	case color.YCbCrModel:
+		decodedBytes = 3
+	default:
+		if _, ok := config.ColorModel.(color.Palette); ok {
+			decodedBytes = 1
+		}
+	}
+
+	return int64(config.Width) * int64(config.Height) * (decodedBytes + 2*workingBytes)
+}
@@ -0,0 +1,381 @@
+package internal
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/draw"
+	"io"
+)
+
+const (
+	EXIF_ORIENTATION_TAG = 0x0112
+
+	JPEG_MAX_SEGMENT_SIZE = 0xffff - 2
+	JPEG_ICC_CHUNK_SIZE   = JPEG_MAX_SEGMENT_SIZE - 14
+
+	// compressed iCCP chunks are inflated up to this size, the 255 jpeg icc segments hold about as much
+	MAX_ICC_PROFILE_SIZE = 16 << 20
+)
+
+var (
+	pngSignature     = []byte("\x89PNG\r\n\x1a\n")
+	jpegExifHeader   = []byte("Exif\x00\x00")
+	jpegXmpHeader    = []byte("http://ns.adobe.com/xap/1.0/\x00")
+	jpegIccHeader    = []byte("ICC_PROFILE\x00")
+	pngXmpKeyword    = []byte("XML:com.adobe.xmp\x00")
+	pngIccName       = []byte("ICC Profile\x00")
+	errNoOrientation = errors.New("no orientation tag found")
+)
+
+type PngTextChunk struct {
+	Type string
+	Data []byte
+}
+
+type ImageMetadata struct {
+	Exif        []byte
+	Xmp         []byte
+	Icc         []byte
+	Text        []PngTextChunk
+	Orientation int
+}
+
+func ParseMetadata(data []byte) *ImageMetadata {
+	meta := &ImageMetadata{Orientation: 1}
+
+	if bytes.HasPrefix(data, pngSignature) {
+		parsePngMetadata(data, meta)
+	} else if bytes.HasPrefix(data, []byte{0xff, 0xd8}) {
+		parseJpegMetadata(data, meta)
+	}
+
+	if meta.Exif != nil {
+		if orientation, err := getExifOrientation(meta.Exif); err == nil && orientation >= 1 && orientation <= 8 {
+			meta.Orientation = orientation
+		}
+	}
+
+	return meta
+}
+
+func parseJpegMetadata(data []byte, meta *ImageMetadata) {
+	var iccChunks [][]byte
+
+	for pos := 2; pos+4 <= len(data); {
+		if data[pos] != 0xff {
+			break
+		}
+
+		marker := data[pos+1]
+		if marker == 0xff {
+			pos++
+			continue
+		}
+		// start of scan, the remaining data is entropy coded
+		if marker == 0xda || marker == 0xd9 {
+			break
+		}
+
+		segmentLen := int(binary.BigEndian.Uint16(data[pos+2:]))
+		if segmentLen < 2 || pos+2+segmentLen > len(data) {
+			break
+		}
+		segment := data[pos+4 : pos+2+segmentLen]
+
+		switch {
+		case marker == 0xe1 && bytes.HasPrefix(segment, jpegExifHeader):
+			meta.Exif = bytes.Clone(segment[len(jpegExifHeader):])
+		case marker == 0xe1 && bytes.HasPrefix(segment, jpegXmpHeader):
+			meta.Xmp = bytes.Clone(segment[len(jpegXmpHeader):])
+		case marker == 0xe2 && bytes.HasPrefix(segment, jpegIccHeader) && len(segment) > len(jpegIccHeader)+2:
+			seq, count := int(segment[len(jpegIccHeader)]), int(segment[len(jpegIccHeader)+1])
+			if iccChunks == nil {
+				iccChunks = make([][]byte, count)
+			}
+			if seq >= 1 && seq <= len(iccChunks) {
+				iccChunks[seq-1] = segment[len(jpegIccHeader)+2:]
+			}
+		}
+
+		pos += 2 + segmentLen
+	}
+
+	if iccChunks != nil {
+		var icc []byte
+		for _, chunk := range iccChunks {
+			if chunk == nil {
+				return
+			}
+			icc = append(icc, chunk...)
+		}
+		meta.Icc = icc
+	}
+}
+
+func parsePngMetadata(data []byte, meta *ImageMetadata) {
+	for pos := len(pngSignature); pos+12 <= len(data); {
+		chunkLen := int(binary.BigEndian.Uint32(data[pos:]))
+		if chunkLen < 0 || pos+12+chunkLen > len(data) {
+			break
+		}
+
+		chunkType := string(data[pos+4 : pos+8])
+		chunkData := data[pos+8 : pos+8+chunkLen]
+
+		switch chunkType {
+		case "eXIf":
+			meta.Exif = bytes.Clone(chunkData)
+		case "iCCP":
+			if icc, err := decodePngIccChunk(chunkData); err == nil {
+				meta.Icc = icc
+			}
+		case "iTXt":
+			if bytes.HasPrefix(chunkData, pngXmpKeyword) && len(chunkData) > len(pngXmpKeyword)+2 {
+				// keyword, compression flag, compression method, language tag and translated keyword precede the text
+				rest := chunkData[len(pngXmpKeyword)+2:]
+				if parts := bytes.SplitN(rest, []byte{0}, 3); len(parts) == 3 && chunkData[len(pngXmpKeyword)] == 0 {
+					meta.Xmp = bytes.Clone(parts[2])
+					break
+				}
+			}
+			fallthrough
+		case "tEXt", "zTXt":
+			meta.Text = append(meta.Text, PngTextChunk{chunkType, bytes.Clone(chunkData)})
+		case "IEND":
+			return
+		}
+
+		pos += 12 + chunkLen
+	}
+}
+
+func decodePngIccChunk(chunkData []byte) ([]byte, error) {
+	nameEnd := bytes.IndexByte(chunkData, 0)
+	if nameEnd < 0 || nameEnd+2 > len(chunkData) {
+		return nil, errors.New("malformed iCCP chunk")
+	}
+
+	reader, err := zlib.NewReader(bytes.NewReader(chunkData[nameEnd+2:]))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	icc, err := io.ReadAll(io.LimitReader(reader, MAX_ICC_PROFILE_SIZE+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(icc) > MAX_ICC_PROFILE_SIZE {
+		return nil, errors.New("iCCP chunk exceeds the maximum icc profile size")
+	}
+
+	return icc, nil
+}
+
+func exifByteOrder(exif []byte) (binary.ByteOrder, error) {
+	if len(exif) < 8 {
+		return nil, errors.New("exif data too short")
+	}
+
+	switch string(exif[:2]) {
+	case "II":
+		return binary.LittleEndian, nil
+	case "MM":
+		return binary.BigEndian, nil
+	default:
+		return nil, errors.New("invalid exif byte order")
+	}
+}
+
+func findExifOrientationEntry(exif []byte) (int, binary.ByteOrder, error) {
+	order, err := exifByteOrder(exif)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	ifdOffset := int(order.Uint32(exif[4:]))
+	if ifdOffset+2 > len(exif) {
+		return 0, nil, errors.New("invalid exif ifd offset")
+	}
+
+	entryCount := int(order.Uint16(exif[ifdOffset:]))
+	for i := 0; i < entryCount; i++ {
+		entry := ifdOffset + 2 + i*12
+		if entry+12 > len(exif) {
+			break
+		}
+
+		if order.Uint16(exif[entry:]) == EXIF_ORIENTATION_TAG {
+			return entry, order, nil
+		}
+	}
+
+	return 0, nil, errNoOrientation
+}
+
+func getExifOrientation(exif []byte) (int, error) {
+	entry, order, err := findExifOrientationEntry(exif)
+	if err != nil {
+		return 0, err
+	}
+
+	return int(order.Uint16(exif[entry+8:])), nil
+}
+
+func setExifOrientation(exif []byte, orientation int) []byte {
+	entry, order, err := findExifOrientationEntry(exif)
+	if err != nil {
+		return exif
+	}
+
+	exif = bytes.Clone(exif)
+	order.PutUint16(exif[entry+8:], uint16(orientation))
+	return exif
+}
+
+func ApplyOrientation(img image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+
+	src, ok := img.(draw.RGBA64Image)
+	if !ok {
+		src = ToRGBA64(img)
+	}
+
+	bnds := img.Bounds()
+	w, h := bnds.Dx(), bnds.Dy()
+
+	dstBnds := image.Rect(0, 0, w, h)
+	if orientation >= 5 {
+		dstBnds = image.Rect(0, 0, h, w)
+	}
+
+	var dst draw.RGBA64Image
+	if _, ok := img.(*image.RGBA64); ok {
+		dst = image.NewRGBA64(dstBnds)
+	} else {
+		dst = image.NewRGBA(dstBnds)
+	}
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dX, dY int
+
+			switch orientation {
+			case 2:
+				dX, dY = w-1-x, y
+			case 3:
+				dX, dY = w-1-x, h-1-y
+			case 4:
+				dX, dY = x, h-1-y
+			case 5:
+				dX, dY = y, x
+			case 6:
+				dX, dY = h-1-y, x
+			case 7:
+				dX, dY = h-1-y, w-1-x
+			case 8:
+				dX, dY = y, w-1-x
+			}
+
+			dst.SetRGBA64(dX, dY, src.RGBA64At(bnds.Min.X+x, bnds.Min.Y+y))
+		}
+	}
+
+	return dst
+}
+
+func writePngChunk(w io.Writer, chunkType string, data []byte) {
+	header := make([]byte, 8)
+	binary.BigEndian.PutUint32(header, uint32(len(data)))
+	copy(header[4:], chunkType)
+
+	crc := crc32.NewIEEE()
+	crc.Write(header[4:])
+	crc.Write(data)
+
+	w.Write(header)
+	w.Write(data)
+	binary.Write(w, binary.BigEndian, crc.Sum32())
+}
+
+func embedPngMetadata(encoded []byte, meta *ImageMetadata) ([]byte, error) {
+	// signature (8) + IHDR chunk (25)
+	ihdrEnd := len(pngSignature) + 25
+	if len(encoded) < ihdrEnd || string(encoded[len(pngSignature)+4:len(pngSignature)+8]) != "IHDR" {
+		return nil, errors.New("unexpected png stream layout")
+	}
+
+	var buf bytes.Buffer
+	buf.Write(encoded[:ihdrEnd])
+
+	if meta.Icc != nil {
+		var compressed bytes.Buffer
+		zw := zlib.NewWriter(&compressed)
+		zw.Write(meta.Icc)
+		zw.Close()
+
+		writePngChunk(&buf, "iCCP", append(append(bytes.Clone(pngIccName), 0), compressed.Bytes()...))
+	}
+
+	if meta.Exif != nil {
+		writePngChunk(&buf, "eXIf", meta.Exif)
+	}
+
+	if meta.Xmp != nil {
+		// uncompressed iTXt without language tag or translated keyword
+		writePngChunk(&buf, "iTXt", append(append(bytes.Clone(pngXmpKeyword), 0, 0, 0, 0), meta.Xmp...))
+	}
+
+	for _, text := range meta.Text {
+		writePngChunk(&buf, text.Type, text.Data)
+	}
+
+	buf.Write(encoded[ihdrEnd:])
+	return buf.Bytes(), nil
+}
+
+func writeJpegSegment(w io.Writer, marker byte, parts ...[]byte) {
+	length := 2
+	for _, part := range parts {
+		length += len(part)
+	}
+
+	w.Write([]byte{0xff, marker, byte(length >> 8), byte(length)})
+	for _, part := range parts {
+		w.Write(part)
+	}
+}
+
+func embedJpegMetadata(encoded []byte, meta *ImageMetadata) ([]byte, error) {
+	if len(encoded) < 2 || encoded[0] != 0xff || encoded[1] != 0xd8 {
+		return nil, errors.New("unexpected jpeg stream layout")
+	}
+
+	var buf bytes.Buffer
+	buf.Write(encoded[:2])
+
+	if meta.Exif != nil && len(meta.Exif)+len(jpegExifHeader) <= JPEG_MAX_SEGMENT_SIZE {
+		writeJpegSegment(&buf, 0xe1, jpegExifHeader, meta.Exif)
+	}
+
+	if meta.Xmp != nil && len(meta.Xmp)+len(jpegXmpHeader) <= JPEG_MAX_SEGMENT_SIZE {
+		writeJpegSegment(&buf, 0xe1, jpegXmpHeader, meta.Xmp)
+	}
+
+	if meta.Icc != nil {
+		count := (len(meta.Icc) + JPEG_ICC_CHUNK_SIZE - 1) / JPEG_ICC_CHUNK_SIZE
+		for i := 0; i < count && count <= 0xff; i++ {
+			chunk := meta.Icc[i*JPEG_ICC_CHUNK_SIZE : min((i+1)*JPEG_ICC_CHUNK_SIZE, len(meta.Icc))]
+			writeJpegSegment(&buf, 0xe2, jpegIccHeader, []byte{byte(i + 1), byte(count)}, chunk)
+		}
+	}
+
+	buf.Write(encoded[2:])
+	return buf.Bytes(), nil
+}
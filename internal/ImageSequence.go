@@ -0,0 +1,217 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// exactly one frame number verb like %d or %04d, escaped %% don't count
+var sequencePatternRegexp = regexp.MustCompile(`^[^%]*%0?[0-9]*d[^%]*$`)
+
+type ImageSequence struct {
+	Pattern string
+	Start   int
+	Count   int
+}
+
+func IsSequencePattern(filePath string) bool {
+	return sequencePatternRegexp.MatchString(strings.ReplaceAll(filePath, "%%", ""))
+}
+
+// counts the consecutive frames of a printf style pattern like frame_%04d.png,
+// a negative start number picks the first existing frame of 0 and 1
+func OpenImageSequence(pattern string, start int) (*ImageSequence, error) {
+	if start < 0 {
+		start = 0
+		if _, err := os.Stat(fmt.Sprintf(pattern, 0)); err != nil {
+			start = 1
+		}
+	}
+
+	seq := &ImageSequence{pattern, start, 0}
+	for {
+		if _, err := os.Stat(seq.Path(seq.Count)); err != nil {
+			break
+		}
+		seq.Count++
+	}
+
+	if seq.Count == 0 {
+		return nil, errors.New("no frames found for sequence pattern " + pattern)
+	}
+
+	return seq, nil
+}
+
+func (seq *ImageSequence) Path(frame int) string {
+	return fmt.Sprintf(seq.Pattern, seq.Start+frame)
+}
+
+func RunImageSequence(seq *ImageSequence, outputPattern, filterName string, args []string, iterations, coreCount int, stripMetadata bool, readOpts ReadOptions, writeOpts WriteOptions) error {
+	if !IsSequencePattern(outputPattern) {
+		return errors.New("output path of an image sequence needs a frame number pattern like out_%04d.png")
+	}
+	if IsTemporalFilter(filterName) && iterations > 1 {
+		return errors.New("temporal filters can't be iterated, use a larger frame radius instead")
+	}
+
+	first, _, err := ReadImageWithMetadata(seq.Path(0), readOpts)
+	if err != nil {
+		return err
+	}
+
+	runner := &imageSequenceRunner{seq, outputPattern, filterName, args, iterations, coreCount, stripMetadata, readOpts, writeOpts}
+
+	// every frame is processed with the image type of the first one
+	if _, ok := first.(*image.RGBA64); ok {
+		return runImageSequence[*image.RGBA64](runner)
+	}
+	return runImageSequence[*image.RGBA](runner)
+}
+
+type imageSequenceRunner struct {
+	seq           *ImageSequence
+	outputPattern string
+	filterName    string
+	args          []string
+	iterations    int
+	coreCount     int
+	stripMetadata bool
+	readOpts      ReadOptions
+	writeOpts     WriteOptions
+}
+
+func (runner *imageSequenceRunner) readFrame(frame int) (image.Image, *ImageMetadata, error) {
+	img, meta, err := ReadImageWithMetadata(runner.seq.Path(frame), runner.readOpts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if runner.stripMetadata {
+		meta = &ImageMetadata{Icc: meta.Icc}
+	}
+
+	return img, meta, nil
+}
+
+func (runner *imageSequenceRunner) writeFrame(frame int, img draw.Image, meta *ImageMetadata) error {
+	opts := runner.writeOpts
+	opts.Metadata = meta
+
+	filePath := fmt.Sprintf(runner.outputPattern, runner.seq.Start+frame)
+	_, err := WriteImage(filePath, &img, opts)
+	return err
+}
+
+func convertImage[T draw.Image](img image.Image) T {
+	var converted T
+
+	switch any(converted).(type) {
+	case *image.RGBA64:
+		converted = any(ToRGBA64(img)).(T)
+	case *image.RGBA:
+		converted = any(ToRGBA(img, false)).(T)
+	}
+
+	return converted
+}
+
+func runImageSequence[T draw.Image](runner *imageSequenceRunner) error {
+	if !IsTemporalFilter(runner.filterName) {
+		return runSpatialImageSequence[T](runner)
+	}
+
+	filter, err := GetTemporalFilter[T](runner.filterName, runner.args)
+	if err != nil {
+		return err
+	}
+
+	radius := filter.Radius()
+	frames := map[int]T{}
+	metas := map[int]*ImageMetadata{}
+	procs := getProcessorCount(runner.coreCount)
+
+	var previous T
+	for i := range runner.seq.Count {
+		lo, hi := max(0, i-radius), min(runner.seq.Count-1, i+radius)
+
+		// only the frames of the current window are kept in memory
+		for frame := range frames {
+			if frame < lo {
+				delete(frames, frame)
+				delete(metas, frame)
+			}
+		}
+
+		window := make([]T, 0, hi-lo+1)
+		for frame := lo; frame <= hi; frame++ {
+			if _, loaded := frames[frame]; !loaded {
+				img, meta, err := runner.readFrame(frame)
+				if err != nil {
+					return err
+				}
+				frames[frame], metas[frame] = convertImage[T](img), meta
+			}
+
+			if frames[frame].Bounds() != frames[lo].Bounds() {
+				return errors.New("all frames of an image sequence need the same dimensions")
+			}
+			window = append(window, frames[frame])
+		}
+
+		current := window[i-lo]
+		if i == 0 {
+			previous = current
+		}
+
+		filteredImg := newImage[T](current.Bounds())
+		runRowBands(current.Bounds().Max.Y, procs, i, runner.seq.Count, func(startY, endY int, prgrsCh chan int) {
+			filter.Apply(window, i-lo, previous, filteredImg, startY, endY, prgrsCh)
+		})
+
+		if err := runner.writeFrame(i, filteredImg, metas[i]); err != nil {
+			return err
+		}
+		previous = filteredImg
+	}
+
+	return nil
+}
+
+func runSpatialImageSequence[T draw.Image](runner *imageSequenceRunner) error {
+	for i := range runner.seq.Count {
+		fmt.Fprintf(os.Stderr, "frame %d / %d\n", i+1, runner.seq.Count)
+
+		img, meta, err := runner.readFrame(i)
+		if err != nil {
+			return err
+		}
+
+		filterEngine, err := NewImageFilterEngineFromImage(runner.seq.Path(i), "", convertImage[T](img), runner.coreCount)
+		if err != nil {
+			return err
+		}
+
+		filterEngine.SetLimits(runner.readOpts.Limits)
+		filterEngine.SetWorkingSpace(runner.readOpts.WorkingSpace)
+
+		if err := filterEngine.SetFilter(runner.filterName, runner.args); err != nil {
+			return err
+		}
+
+		if err := filterEngine.Run(runner.iterations); err != nil {
+			return err
+		}
+
+		if err := runner.writeFrame(i, *filterEngine.GetOutput(), meta); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,132 @@
+package internal
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+// maps the pixels of the target image back into the source image
+type InverseMapping interface {
+	OutputBounds(src image.Rectangle) image.Rectangle
+	// returns the function mapping a target position to its source position,
+	// positions are continuous coordinates where pixel x covers [x, x+1), ok is false for targets without a source
+	Inverse(src, dst image.Rectangle) func(x, y float64) (sx, sy float64, ok bool)
+}
+
+type inverseMapParams struct {
+	mapping    InverseMapping
+	kernel     ResampleKernel
+	background color.RGBA64
+}
+
+type InverseMapRGBA64Filter struct {
+	inverseMapParams
+}
+
+type InverseMapRGBAFilter struct {
+	inverseMapParams
+}
+
+// target pixels without a source and kernel taps outside of the source image take the background color
+func NewInverseMapRGBA64Filter(mapping InverseMapping, kernel ResampleKernel, background color.Color) *InverseMapRGBA64Filter {
+	return &InverseMapRGBA64Filter{inverseMapParams{mapping, kernel, color.RGBA64Model.Convert(background).(color.RGBA64)}}
+}
+
+func NewInverseMapRGBAFilter(mapping InverseMapping, kernel ResampleKernel, background color.Color) *InverseMapRGBAFilter {
+	return &InverseMapRGBAFilter{inverseMapParams{mapping, kernel, color.RGBA64Model.Convert(background).(color.RGBA64)}}
+}
+
+func (params *inverseMapParams) OutputBounds(bnds image.Rectangle) image.Rectangle {
+	return params.mapping.OutputBounds(bnds)
+}
+
+func (filter *InverseMapRGBA64Filter) Apply(img, filteredImg *image.RGBA64, startY, endY int, prgrsCh chan int) {
+	inverseMapRows(img, filteredImg, &filter.inverseMapParams, startY, endY, prgrsCh)
+}
+
+func (filter *InverseMapRGBAFilter) Apply(img, filteredImg *image.RGBA, startY, endY int, prgrsCh chan int) {
+	inverseMapRows(img, filteredImg, &filter.inverseMapParams, startY, endY, prgrsCh)
+}
+
+func inverseMapRows(img, filteredImg draw.RGBA64Image, params *inverseMapParams, startY, endY int, prgrsCh chan int) {
+	dst := filteredImg.Bounds()
+	inverse := params.mapping.Inverse(img.Bounds(), dst)
+
+	progress := newRowProgressReporter(startY, endY, prgrsCh)
+	for y := startY; y < endY; y++ {
+		for x := dst.Min.X; x < dst.Max.X; x++ {
+			sx, sy, ok := inverse(float64(x)+0.5, float64(y)+0.5)
+			if !ok {
+				filteredImg.SetRGBA64(x, y, params.background)
+				continue
+			}
+
+			r, g, b, a := samplePoint(img, params.kernel, sx, sy, params.background)
+			setResampledPixel(filteredImg, x, y, r, g, b, a)
+		}
+		progress.RowDone()
+	}
+}
+
+// interpolates the premultiplied color at a continuous source position
+func samplePoint(img draw.RGBA64Image, kernel ResampleKernel, x, y float64, background color.RGBA64) (r, g, b, a float64) {
+	bnds := img.Bounds()
+
+	if kernel.Name == NearestKernel.Name {
+		c := background
+		if pt := image.Pt(int(math.Floor(x)), int(math.Floor(y))); pt.In(bnds) {
+			c = img.RGBA64At(pt.X, pt.Y)
+		}
+		return float64(c.R), float64(c.G), float64(c.B), float64(c.A)
+	}
+
+	// area averaging needs a target pixel size, single points are interpolated bilinear
+	if kernel.weight == nil {
+		kernel = BilinearKernel
+	}
+
+	support := kernel.Support
+	if x < float64(bnds.Min.X)-support || x > float64(bnds.Max.X)+support ||
+		y < float64(bnds.Min.Y)-support || y > float64(bnds.Max.Y)+support {
+		return float64(background.R), float64(background.G), float64(background.B), float64(background.A)
+	}
+
+	// taps cover the pixel centers i + 0.5 within the kernel support
+	taps := int(math.Ceil(support)) * 2
+	x0 := int(math.Floor(x-0.5)) - taps/2 + 1
+	y0 := int(math.Floor(y-0.5)) - taps/2 + 1
+
+	var sum float64
+	for j := range taps {
+		wy := kernel.weight(float64(y0+j) + 0.5 - y)
+		if wy == 0 {
+			continue
+		}
+
+		for i := range taps {
+			w := kernel.weight(float64(x0+i)+0.5-x) * wy
+			if w == 0 {
+				continue
+			}
+
+			c := background
+			if pt := image.Pt(x0+i, y0+j); pt.In(bnds) {
+				c = img.RGBA64At(pt.X, pt.Y)
+			}
+
+			r += float64(c.R) * w
+			g += float64(c.G) * w
+			b += float64(c.B) * w
+			a += float64(c.A) * w
+			sum += w
+		}
+	}
+
+	if sum != 0 {
+		r, g, b, a = r/sum, g/sum, b/sum, a/sum
+	}
+
+	return r, g, b, a
+}
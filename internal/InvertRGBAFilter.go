@@ -30,3 +30,15 @@ func (filter *InvertRGBAFilter) Apply(img, filteredImg *image.RGBA, startY, endY
 		}
 	}
 }
+
+func invertColor(r, g, b float64) (float64, float64, float64) {
+	return 1 - r, 1 - g, 1 - b
+}
+
+func (filter *InvertRGBA64Filter) PixelFunc() ColorFunc {
+	return invertColor
+}
+
+func (filter *InvertRGBAFilter) PixelFunc() ColorFunc {
+	return invertColor
+}
@@ -0,0 +1,281 @@
+package internal
+
+import (
+	"errors"
+	"image"
+	"image/draw"
+	"math"
+	"strconv"
+)
+
+const (
+	KUWAHARA_ANISOTROPIC = "anisotropic"
+
+	KUWAHARA_SECTORS = 8
+	// sigma of the gaussian smoothing the structure tensor, the flow follows the edges at this scale
+	KUWAHARA_TENSOR_SIGMA = 2.0
+	// sectors start to overlap at the center and fade out at this angle from their axis
+	KUWAHARA_ZERO_CROSSING = 3 * math.Pi / 8
+	// scales the summed channel variance of a sector before the sharpness is applied
+	KUWAHARA_VARIANCE_SCALE = 1000.0
+	// quadrants need to be this much smoother to replace an earlier one, so rounding can't flip ties between bands
+	KUWAHARA_VARIANCE_TOLERANCE = 1e-9
+)
+
+// the classic filter replaces every pixel by the mean of the one of its four quadrants with the lowest luminance variance,
+// the anisotropic filter by kyprianidis et al. stretches the neighborhood along the edges, splits it into eight smooth
+// sectors and blends their means weighted by the inverse of their variance to the power of the sharpness
+type kuwaharaParams struct {
+	radius      int
+	anisotropic bool
+	sharpness   float64
+}
+
+type KuwaharaRGBA64Filter struct {
+	kuwaharaParams
+}
+
+type KuwaharaRGBAFilter struct {
+	kuwaharaParams
+}
+
+// parses [radius [anisotropic [sharpness]]], radius 4 and sharpness 8 by default
+func parseKuwaharaParams(args []string) (kuwaharaParams, error) {
+	usage := "filter needs the radius (int >= 1), optionally followed by anisotropic and the sharpness (float >= 1), as optional non-flag arguments"
+	params := kuwaharaParams{4, false, 8}
+	if len(args) > 3 {
+		return params, errors.New(usage)
+	}
+
+	if len(args) >= 1 {
+		radius, err := strconv.Atoi(args[0])
+		if err != nil || radius < 1 {
+			return params, errors.New(usage)
+		}
+		params.radius = radius
+	}
+	if len(args) >= 2 {
+		if args[1] != KUWAHARA_ANISOTROPIC {
+			return params, errors.New(usage)
+		}
+		params.anisotropic = true
+	}
+	if len(args) >= 3 {
+		sharpness, err := strconv.ParseFloat(args[2], 64)
+		if err != nil || sharpness < 1 {
+			return params, errors.New(usage)
+		}
+		params.sharpness = sharpness
+	}
+
+	return params, nil
+}
+
+func (params *kuwaharaParams) apply(img, filteredImg draw.RGBA64Image, startY, endY int, prgrsCh chan int) {
+	if params.anisotropic {
+		params.applyAnisotropic(img, filteredImg, startY, endY, prgrsCh)
+		return
+	}
+
+	r := params.radius
+	band := loadImageBand(img, startY, endY, r)
+	w, h := band.bnds.Dx(), band.bnds.Dy()
+
+	var channels [4]*summedArea
+	for ch := range channels {
+		channels[ch] = newSummedArea(band.plane(ch), w, h)
+	}
+	lum, lumSq := make([]float64, w*h), make([]float64, w*h)
+	for i := range lum {
+		lum[i] = luminance(band.pix[i*4], band.pix[i*4+1], band.pix[i*4+2])
+		lumSq[i] = lum[i] * lum[i]
+	}
+	lumArea, lumSqArea := newSummedArea(lum, w, h), newSummedArea(lumSq, w, h)
+
+	progress := newRowProgressReporter(startY, endY, prgrsCh)
+	for y := startY - band.bnds.Min.Y; y < endY-band.bnds.Min.Y; y++ {
+		for x := range w {
+			minVariance := math.Inf(1)
+			var quadrant [4]int
+
+			// the quadrants share the row and the column of the pixel
+			for _, q := range [4][4]int{{x - r, y - r, x + 1, y + 1}, {x, y - r, x + r + 1, y + 1}, {x - r, y, x + 1, y + r + 1}, {x, y, x + r + 1, y + r + 1}} {
+				sum, n := lumArea.sum(q[0], q[1], q[2], q[3])
+				sumSq, _ := lumSqArea.sum(q[0], q[1], q[2], q[3])
+				mean := sum / float64(n)
+				if variance := sumSq/float64(n) - mean*mean; variance < minVariance-KUWAHARA_VARIANCE_TOLERANCE {
+					minVariance, quadrant = variance, q
+				}
+			}
+
+			var c [4]float64
+			for ch := range c {
+				sum, n := channels[ch].sum(quadrant[0], quadrant[1], quadrant[2], quadrant[3])
+				c[ch] = sum / float64(n)
+			}
+			band.set(filteredImg, x, y, c)
+		}
+		progress.RowDone()
+	}
+}
+
+// smoothed structure tensor e, f, g of the rgb sobel derivatives for every pixel of the band
+func structureTensor(band *imageBand) [3][]float64 {
+	w, h := band.bnds.Dx(), band.bnds.Dy()
+	var tensor [3][]float64
+	for i := range tensor {
+		tensor[i] = make([]float64, w*h)
+	}
+
+	for y := range h {
+		for x := range w {
+			tensor[0][y*w+x], tensor[1][y*w+x], tensor[2][y*w+x] = band.colorTensor(x, y)
+		}
+	}
+
+	radius := int(math.Ceil(3 * KUWAHARA_TENSOR_SIGMA))
+	kernel := make([]float64, 2*radius+1)
+	for i := range kernel {
+		d := float64(i - radius)
+		kernel[i] = math.Exp(-d * d / (2 * KUWAHARA_TENSOR_SIGMA * KUWAHARA_TENSOR_SIGMA))
+	}
+
+	// separable gaussian, normalized by the weights within the band
+	tmp := make([]float64, w*h)
+	for _, plane := range tensor {
+		for pass, stride := range [2]int{1, w} {
+			src, dst := plane, tmp
+			if pass == 1 {
+				src, dst = tmp, plane
+			}
+			for y := range h {
+				for x := range w {
+					pos := [2]int{x, y}[pass]
+					limit := [2]int{w, h}[pass]
+					var sum, weights float64
+					for k := max(0, pos-radius); k <= min(limit-1, pos+radius); k++ {
+						weight := kernel[k-pos+radius]
+						sum += src[y*w+x+(k-pos)*stride] * weight
+						weights += weight
+					}
+					dst[y*w+x] = sum / weights
+				}
+			}
+		}
+	}
+
+	return tensor
+}
+
+func (params *kuwaharaParams) applyAnisotropic(img, filteredImg draw.RGBA64Image, startY, endY int, prgrsCh chan int) {
+	r := float64(params.radius)
+	band := loadImageBand(img, startY, endY, max(2*params.radius, int(math.Ceil(3*KUWAHARA_TENSOR_SIGMA))+1))
+	w, h := band.bnds.Dx(), band.bnds.Dy()
+	tensor := structureTensor(band)
+
+	zeta := 2 / r
+	sinZero := math.Sin(KUWAHARA_ZERO_CROSSING)
+	eta := (zeta + math.Cos(KUWAHARA_ZERO_CROSSING)) / (sinZero * sinZero)
+
+	// polynomial weights of the sectors around the axes and, after rotating by 45 degrees, around the diagonals
+	sectorWeights := func(u, v float64, weights []float64) {
+		for half := range 2 {
+			uu, vv := zeta-eta*u*u, zeta-eta*v*v
+			for k, z := range [4]float64{v + uu, -u + vv, -v + uu, u + vv} {
+				z = max(0, z)
+				weights[2*k+half] = z * z
+			}
+			u, v = math.Sqrt2/2*(u-v), math.Sqrt2/2*(u+v)
+		}
+	}
+
+	progress := newRowProgressReporter(startY, endY, prgrsCh)
+	var means, squares [KUWAHARA_SECTORS][4]float64
+	var sums [KUWAHARA_SECTORS]float64
+	weights := make([]float64, KUWAHARA_SECTORS)
+
+	for y := startY - band.bnds.Min.Y; y < endY-band.bnds.Min.Y; y++ {
+		for x := range w {
+			i := y*w + x
+			e, f, g := tensor[0][i], tensor[1][i], tensor[2][i]
+
+			// the eigenvector of the smaller eigenvalue points along the edge
+			lambda1, lambda2 := tensorEigenvalues(e, f, g)
+			phi, anisotropy := 0.0, 0.0
+			if lambda1+lambda2 > 0 {
+				phi = math.Atan2(-f, lambda1-e)
+				anisotropy = (lambda1 - lambda2) / (lambda1 + lambda2)
+			}
+
+			a := r * math.Max(0.1, math.Min(2, 1+anisotropy))
+			b := r * math.Max(0.1, math.Min(2, 1/(1+anisotropy)))
+			cosPhi, sinPhi := math.Cos(phi), math.Sin(phi)
+			reachX := int(math.Sqrt(a*a*cosPhi*cosPhi + b*b*sinPhi*sinPhi))
+			reachY := int(math.Sqrt(a*a*sinPhi*sinPhi + b*b*cosPhi*cosPhi))
+
+			means, squares, sums = [KUWAHARA_SECTORS][4]float64{}, [KUWAHARA_SECTORS][4]float64{}, [KUWAHARA_SECTORS]float64{}
+			for ky := max(0, y-reachY); ky <= min(h-1, y+reachY); ky++ {
+				for kx := max(0, x-reachX); kx <= min(w-1, x+reachX); kx++ {
+					dx, dy := float64(kx-x), float64(ky-y)
+					u, v := (cosPhi*dx+sinPhi*dy)/a, (-sinPhi*dx+cosPhi*dy)/b
+					dist := u*u + v*v
+					if dist > 1 {
+						continue
+					}
+
+					sectorWeights(u, v, weights)
+					var total float64
+					for _, weight := range weights {
+						total += weight
+					}
+					gauss := math.Exp(-3.125*dist) / total
+
+					c := band.pix[band.offset(kx, ky):]
+					for k, weight := range weights {
+						weight *= gauss
+						for ch := range 4 {
+							means[k][ch] += c[ch] * weight
+							squares[k][ch] += c[ch] * c[ch] * weight
+						}
+						sums[k] += weight
+					}
+				}
+			}
+
+			var out [4]float64
+			var total float64
+			for k := range KUWAHARA_SECTORS {
+				if sums[k] == 0 {
+					continue
+				}
+				var variance float64
+				for ch := range 4 {
+					means[k][ch] /= sums[k]
+					if ch < 3 {
+						variance += math.Abs(squares[k][ch]/sums[k] - means[k][ch]*means[k][ch])
+					}
+				}
+
+				weight := 1 / (1 + math.Pow(KUWAHARA_VARIANCE_SCALE*variance, params.sharpness/2))
+				for ch := range out {
+					out[ch] += means[k][ch] * weight
+				}
+				total += weight
+			}
+
+			for ch := range out {
+				out[ch] /= total
+			}
+			band.set(filteredImg, x, y, out)
+		}
+		progress.RowDone()
+	}
+}
+
+func (filter *KuwaharaRGBA64Filter) Apply(img, filteredImg *image.RGBA64, startY, endY int, prgrsCh chan int) {
+	filter.apply(img, filteredImg, startY, endY, prgrsCh)
+}
+
+func (filter *KuwaharaRGBAFilter) Apply(img, filteredImg *image.RGBA, startY, endY int, prgrsCh chan int) {
+	filter.apply(img, filteredImg, startY, endY, prgrsCh)
+}
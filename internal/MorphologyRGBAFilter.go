@@ -0,0 +1,327 @@
+package internal
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"strconv"
+)
+
+const (
+	MORPH_ERODE     = "erode"
+	MORPH_DILATE    = "dilate"
+	MORPH_OPEN      = "open"
+	MORPH_CLOSE     = "close"
+	MORPH_GRADIENT  = "gradient"
+	MORPH_TOP_HAT   = "tophat"
+	MORPH_BLACK_HAT = "blackhat"
+
+	ELEMENT_SQUARE = "square"
+	ELEMENT_RECT   = "rect"
+	ELEMENT_CROSS  = "cross"
+	ELEMENT_DISK   = "disk"
+	ELEMENT_CUSTOM = "custom"
+)
+
+// erosions and dilations the operations chain, the rows a band needs above and below grow with it
+var morphologyPasses = map[string]int{
+	MORPH_ERODE:     1,
+	MORPH_DILATE:    1,
+	MORPH_OPEN:      2,
+	MORPH_CLOSE:     2,
+	MORPH_GRADIENT:  1,
+	MORPH_TOP_HAT:   2,
+	MORPH_BLACK_HAT: 2,
+}
+
+// the element spans rx pixels left and right and ry pixels above and below its center,
+// disk and custom elements list their offsets, rectangles and crosses are decomposed into lines
+type structuringElement struct {
+	shape   string
+	rx, ry  int
+	offsets []image.Point
+}
+
+// erodes and dilates the premultiplied channels of the pixels with the minimum and maximum within the element,
+// dilations use the element mirrored at its origin, so openings never brighten and closings never darken a pixel,
+// pixels outside of the image are ignored, so the borders neither grow nor shrink
+type morphologyParams struct {
+	op      string
+	element structuringElement
+}
+
+type MorphologyRGBA64Filter struct {
+	morphologyParams
+}
+
+type MorphologyRGBAFilter struct {
+	morphologyParams
+}
+
+// one plane per premultiplied channel for the rows of a band and the rows around it
+type morphPlanes [4][]uint16
+
+// parses rows of 0 and 1 of odd and equal length, the center of the matrix is the origin of the element
+func parseCustomElement(rows []string) (structuringElement, error) {
+	element := structuringElement{shape: ELEMENT_CUSTOM}
+	if len(rows)%2 == 0 || len(rows[0])%2 == 0 {
+		return element, errors.New("custom element needs an odd number of rows of 0 and 1 with the same odd length")
+	}
+
+	element.rx, element.ry = len(rows[0])/2, len(rows)/2
+	for y, row := range rows {
+		if len(row) != len(rows[0]) {
+			return element, errors.New("custom element needs an odd number of rows of 0 and 1 with the same odd length")
+		}
+		for x, v := range row {
+			switch v {
+			case '1':
+				element.offsets = append(element.offsets, image.Pt(x-element.rx, y-element.ry))
+			case '0':
+			default:
+				return element, errors.New("custom element rows need to consist of 0 and 1, got " + row)
+			}
+		}
+	}
+
+	if len(element.offsets) == 0 {
+		return element, errors.New("custom element needs at least one 1")
+	}
+	return element, nil
+}
+
+// parses [square|cross|disk [radius]], rect radiusX radiusY or custom rows, a square of radius 1 by default
+func parseMorphologyParams(op string, args []string) (morphologyParams, error) {
+	params := morphologyParams{op, structuringElement{shape: ELEMENT_SQUARE, rx: 1, ry: 1}}
+	if len(args) == 0 {
+		return params, nil
+	}
+
+	usage := "filter needs square, cross or disk followed by the radius (int >= 1), rect followed by radius x and y (int, int) " +
+		"or custom followed by rows of 0 and 1, e.g. 010 111 010, as optional non-flag arguments"
+
+	shape := args[0]
+	switch shape {
+	case ELEMENT_CUSTOM:
+		if len(args) < 2 {
+			return params, errors.New(usage)
+		}
+		element, err := parseCustomElement(args[1:])
+		params.element = element
+		return params, err
+	case ELEMENT_RECT:
+		if len(args) != 3 {
+			return params, errors.New(usage)
+		}
+	case ELEMENT_SQUARE, ELEMENT_CROSS, ELEMENT_DISK:
+		if len(args) > 2 {
+			return params, errors.New(usage)
+		}
+	default:
+		return params, errors.New(usage)
+	}
+
+	radii := []int{1, 1}
+	for i, arg := range args[1:] {
+		radius, err := strconv.Atoi(arg)
+		if err != nil || radius < 0 || (shape != ELEMENT_RECT && radius < 1) {
+			return params, errors.New(usage)
+		}
+		radii[i] = radius
+	}
+	if shape != ELEMENT_RECT {
+		radii[1] = radii[0]
+	}
+
+	params.element = structuringElement{shape: shape, rx: radii[0], ry: radii[1]}
+	if shape == ELEMENT_DISK {
+		r := radii[0]
+		limit := (float64(r) + 0.5) * (float64(r) + 0.5)
+		for y := -r; y <= r; y++ {
+			for x := -r; x <= r; x++ {
+				if float64(x*x+y*y) <= limit {
+					params.element.offsets = append(params.element.offsets, image.Pt(x, y))
+				}
+			}
+		}
+	}
+
+	return params, nil
+}
+
+func morphOp(a, b uint16, erode bool) uint16 {
+	if erode {
+		return min(a, b)
+	}
+	return max(a, b)
+}
+
+// minimum or maximum of every window of 2r+1 values of the n values at src[i*stride] with the van herk/gil-werman algorithm,
+// which needs 3 comparisons per value whatever the window size, values outside count as neutral
+func vanHerkGilWerman(src, dst []uint16, stride, n, r int, erode bool, pre, suf []uint16) {
+	var neutral uint16
+	if erode {
+		neutral = 0xffff
+	}
+
+	k, m := 2*r+1, n+2*r
+	value := func(j int) uint16 {
+		if j < r || j >= n+r {
+			return neutral
+		}
+		return src[(j-r)*stride]
+	}
+
+	for j := range m {
+		if j%k == 0 {
+			pre[j] = value(j)
+		} else {
+			pre[j] = morphOp(pre[j-1], value(j), erode)
+		}
+	}
+	for j := m - 1; j >= 0; j-- {
+		if j == m-1 || (j+1)%k == 0 {
+			suf[j] = value(j)
+		} else {
+			suf[j] = morphOp(suf[j+1], value(j), erode)
+		}
+	}
+
+	for i := range n {
+		dst[i*stride] = morphOp(suf[i], pre[i+k-1], erode)
+	}
+}
+
+// erodes or dilates the w x h planes by the element
+func (element *structuringElement) apply(src morphPlanes, w, h int, erode bool) morphPlanes {
+	var dst morphPlanes
+	for ch := range dst {
+		dst[ch] = make([]uint16, w*h)
+	}
+
+	if element.offsets != nil {
+		// the dilation of a pixel reaches the pixels the element placed on them covers
+		sign := -1
+		if erode {
+			sign = 1
+		}
+		for ch := range dst {
+			for y := range h {
+				for x := range w {
+					v := uint16(0)
+					if erode {
+						v = 0xffff
+					}
+					for _, off := range element.offsets {
+						if sx, sy := x+sign*off.X, y+sign*off.Y; sx >= 0 && sx < w && sy >= 0 && sy < h {
+							v = morphOp(v, src[ch][sy*w+sx], erode)
+						}
+					}
+					dst[ch][y*w+x] = v
+				}
+			}
+		}
+		return dst
+	}
+
+	scratch := max(w, h) + 2*max(element.rx, element.ry)
+	pre, suf := make([]uint16, scratch), make([]uint16, scratch)
+	horizontal := make([]uint16, w*h)
+
+	for ch := range dst {
+		for y := range h {
+			vanHerkGilWerman(src[ch][y*w:], horizontal[y*w:], 1, w, element.rx, erode, pre, suf)
+		}
+
+		// the square is separable, the cross combines its horizontal and its vertical line
+		vertical := horizontal
+		if element.shape == ELEMENT_CROSS {
+			vertical = src[ch]
+		}
+		for x := range w {
+			vanHerkGilWerman(vertical[x:], dst[ch][x:], w, h, element.ry, erode, pre, suf)
+		}
+
+		if element.shape == ELEMENT_CROSS {
+			for i, v := range horizontal {
+				dst[ch][i] = morphOp(dst[ch][i], v, erode)
+			}
+		}
+	}
+
+	return dst
+}
+
+func loadMorphPlanes(img draw.RGBA64Image, startY, endY int) morphPlanes {
+	bnds := img.Bounds()
+	w := bnds.Dx()
+
+	var planes morphPlanes
+	for ch := range planes {
+		planes[ch] = make([]uint16, w*(endY-startY))
+	}
+
+	for y := startY; y < endY; y++ {
+		for x := bnds.Min.X; x < bnds.Max.X; x++ {
+			c := img.RGBA64At(x, y)
+			i := (y-startY)*w + x - bnds.Min.X
+			planes[0][i], planes[1][i], planes[2][i], planes[3][i] = c.R, c.G, c.B, c.A
+		}
+	}
+
+	return planes
+}
+
+// filters the rows between startY and endY from the rows around them, the difference operations
+// keep the alpha of the larger operand, so opaque images stay opaque, and clamp negative differences to 0
+func (params *morphologyParams) apply(img, filteredImg draw.RGBA64Image, startY, endY int, prgrsCh chan int) {
+	bnds := img.Bounds()
+	halo := params.element.ry * morphologyPasses[params.op]
+	bandStartY, bandEndY := max(bnds.Min.Y, startY-halo), min(bnds.Max.Y, endY+halo)
+	w, h := bnds.Dx(), bandEndY-bandStartY
+
+	src := loadMorphPlanes(img, bandStartY, bandEndY)
+	element := &params.element
+
+	var result, subtrahend morphPlanes
+	switch params.op {
+	case MORPH_ERODE:
+		result = element.apply(src, w, h, true)
+	case MORPH_DILATE:
+		result = element.apply(src, w, h, false)
+	case MORPH_OPEN:
+		result = element.apply(element.apply(src, w, h, true), w, h, false)
+	case MORPH_CLOSE:
+		result = element.apply(element.apply(src, w, h, false), w, h, true)
+	case MORPH_GRADIENT:
+		result, subtrahend = element.apply(src, w, h, false), element.apply(src, w, h, true)
+	case MORPH_TOP_HAT:
+		result, subtrahend = src, element.apply(element.apply(src, w, h, true), w, h, false)
+	case MORPH_BLACK_HAT:
+		result, subtrahend = element.apply(element.apply(src, w, h, false), w, h, true), src
+	}
+
+	progress := newRowProgressReporter(startY, endY, prgrsCh)
+	for y := startY; y < endY; y++ {
+		for x := bnds.Min.X; x < bnds.Max.X; x++ {
+			i := (y-bandStartY)*w + x - bnds.Min.X
+			c := color.RGBA64{result[0][i], result[1][i], result[2][i], result[3][i]}
+			if subtrahend[0] != nil {
+				c.R -= min(c.R, subtrahend[0][i])
+				c.G -= min(c.G, subtrahend[1][i])
+				c.B -= min(c.B, subtrahend[2][i])
+			}
+			filteredImg.SetRGBA64(x, y, c)
+		}
+		progress.RowDone()
+	}
+}
+
+func (filter *MorphologyRGBA64Filter) Apply(img, filteredImg *image.RGBA64, startY, endY int, prgrsCh chan int) {
+	filter.apply(img, filteredImg, startY, endY, prgrsCh)
+}
+
+func (filter *MorphologyRGBAFilter) Apply(img, filteredImg *image.RGBA, startY, endY int, prgrsCh chan int) {
+	filter.apply(img, filteredImg, startY, endY, prgrsCh)
+}
@@ -0,0 +1,82 @@
+package internal
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"strconv"
+)
+
+// draws the color over the pixels whose sobel color gradient exceeds the threshold, fully at
+// twice the threshold, which outlines the shapes of the image like ink lines
+type outlineParams struct {
+	threshold float64
+	color     color.RGBA64
+}
+
+type OutlineRGBA64Filter struct {
+	outlineParams
+}
+
+type OutlineRGBAFilter struct {
+	outlineParams
+}
+
+// parses [threshold between 0 and 255 [color]], 40 and black by default
+func parseOutlineParams(args []string) (outlineParams, error) {
+	params := outlineParams{40.0 / 0xff, color.RGBA64{0, 0, 0, 0xffff}}
+	if len(args) > 2 {
+		return params, errors.New("filter needs the gradient threshold between 0 and 255 (float) and the line color as optional non-flag arguments")
+	}
+
+	if len(args) >= 1 {
+		threshold, err := strconv.ParseFloat(args[0], 64)
+		if err != nil || threshold <= 0 || threshold > 255 {
+			return params, errors.New("first non-flag argument needs to be the gradient threshold (float between 0 and 255)")
+		}
+		params.threshold = threshold / 0xff
+	}
+	if len(args) >= 2 {
+		c, err := parseColor(args[1])
+		if err != nil {
+			return params, err
+		}
+		params.color = c
+	}
+
+	return params, nil
+}
+
+func (params *outlineParams) apply(img, filteredImg draw.RGBA64Image, startY, endY int, prgrsCh chan int) {
+	band := loadImageBand(img, startY, endY, 1)
+	w := band.bnds.Dx()
+
+	line := [4]float64{float64(params.color.R) / 0xffff, float64(params.color.G) / 0xffff, float64(params.color.B) / 0xffff, float64(params.color.A) / 0xffff}
+
+	progress := newRowProgressReporter(startY, endY, prgrsCh)
+	for y := startY - band.bnds.Min.Y; y < endY-band.bnds.Min.Y; y++ {
+		for x := range w {
+			lambda1, _ := tensorEigenvalues(band.colorTensor(x, y))
+			coverage := clampUnit((math.Sqrt(lambda1) - params.threshold) / params.threshold)
+
+			// source over with premultiplied colors
+			i := band.offset(x, y)
+			var c [4]float64
+			for ch := range c {
+				c[ch] = line[ch]*coverage + band.pix[i+ch]*(1-coverage*line[3])
+			}
+			band.set(filteredImg, x, y, c)
+		}
+		progress.RowDone()
+	}
+}
+
+func (filter *OutlineRGBA64Filter) Apply(img, filteredImg *image.RGBA64, startY, endY int, prgrsCh chan int) {
+	filter.apply(img, filteredImg, startY, endY, prgrsCh)
+}
+
+func (filter *OutlineRGBAFilter) Apply(img, filteredImg *image.RGBA, startY, endY int, prgrsCh chan int) {
+	filter.apply(img, filteredImg, startY, endY, prgrsCh)
+}
@@ -0,0 +1,116 @@
+package internal
+
+import (
+	"errors"
+	"image"
+	"math"
+	"strconv"
+)
+
+// x' = (h[0]*x + h[1]*y + h[2]) / w, y' = (h[3]*x + h[4]*y + h[5]) / w, w = h[6]*x + h[7]*y + 1
+type homography [8]float64
+
+func (h *homography) apply(x, y float64) (float64, float64, bool) {
+	w := h[6]*x + h[7]*y + 1
+	if math.Abs(w) < 1e-12 {
+		return 0, 0, false
+	}
+	return (h[0]*x + h[1]*y + h[2]) / w, (h[3]*x + h[4]*y + h[5]) / w, true
+}
+
+// solves the homography mapping the four from points onto the four to points
+func computeHomography(from, to [4][2]float64) (homography, error) {
+	var m [8][9]float64
+	for i := range 4 {
+		x, y, u, v := from[i][0], from[i][1], to[i][0], to[i][1]
+		m[i*2] = [9]float64{x, y, 1, 0, 0, 0, -x * u, -y * u, u}
+		m[i*2+1] = [9]float64{0, 0, 0, x, y, 1, -x * v, -y * v, v}
+	}
+
+	// gaussian elimination with partial pivoting
+	for col := range 8 {
+		pivot := col
+		for row := col + 1; row < 8; row++ {
+			if math.Abs(m[row][col]) > math.Abs(m[pivot][col]) {
+				pivot = row
+			}
+		}
+		if math.Abs(m[pivot][col]) < 1e-12 {
+			return homography{}, errors.New("corner points must not be collinear")
+		}
+		m[col], m[pivot] = m[pivot], m[col]
+
+		for row := range 8 {
+			if row == col {
+				continue
+			}
+			f := m[row][col] / m[col][col]
+			for k := col; k < 9; k++ {
+				m[row][k] -= f * m[col][k]
+			}
+		}
+	}
+
+	var h homography
+	for i := range 8 {
+		h[i] = m[i][8] / m[i][i]
+	}
+	return h, nil
+}
+
+type perspectiveMapping struct {
+	width, height int
+	inverse       homography
+}
+
+// maps the source quadrilateral given by its top left, top right, bottom right and bottom left corners
+// onto a width x height rectangle, e.g. to correct the keystone of a photographed document
+func NewPerspectiveMapping(corners [4][2]float64, width, height int) (InverseMapping, error) {
+	w, h := float64(width), float64(height)
+	inverse, err := computeHomography([4][2]float64{{0, 0}, {w, 0}, {w, h}, {0, h}}, corners)
+	if err != nil {
+		return nil, err
+	}
+
+	return &perspectiveMapping{width, height, inverse}, nil
+}
+
+func (mapping *perspectiveMapping) OutputBounds(src image.Rectangle) image.Rectangle {
+	return outputRect(float64(mapping.width), float64(mapping.height))
+}
+
+func (mapping *perspectiveMapping) Inverse(src, dst image.Rectangle) func(x, y float64) (float64, float64, bool) {
+	return func(x, y float64) (float64, float64, bool) {
+		sx, sy, ok := mapping.inverse.apply(x-float64(dst.Min.X), y-float64(dst.Min.Y))
+		return sx + float64(src.Min.X), sy + float64(src.Min.Y), ok
+	}
+}
+
+func parsePerspectiveParams(args []string) (inverseMapParams, error) {
+	usage := errors.New("filter needs the top left, top right, bottom right and bottom left source corners x1, y1 ... x4, y4 (8 floats) and the target width and height (int, int), optionally followed by the interpolation and a background color")
+	if len(args) < 10 {
+		return inverseMapParams{}, usage
+	}
+
+	var corners [4][2]float64
+	for i := range 8 {
+		v, err := strconv.ParseFloat(args[i], 64)
+		if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+			return inverseMapParams{}, usage
+		}
+		corners[i/2][i%2] = v
+	}
+
+	width, errW := strconv.Atoi(args[8])
+	height, errH := strconv.Atoi(args[9])
+	if errW != nil || errH != nil || width <= 0 || height <= 0 {
+		return inverseMapParams{}, errors.New("perspective width and height need to be integers greater than 0")
+	}
+
+	mapping, err := NewPerspectiveMapping(corners, width, height)
+	if err != nil {
+		return inverseMapParams{}, err
+	}
+
+	return parseSamplingArgs(args[10:], mapping, BilinearKernel)
+}
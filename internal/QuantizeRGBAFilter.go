@@ -0,0 +1,153 @@
+package internal
+
+import (
+	"errors"
+	"image"
+	"image/draw"
+	"math"
+	"strconv"
+)
+
+const (
+	QUANTIZE_DEFAULT_COLORS = 16
+)
+
+// reduces the image to a palette built from its own colors, the palette is built in the analysis
+// and handed to the quantizer of the embedded dither filter
+type quantizeParams struct {
+	quantizerName string
+	size          int
+	target        *ditherQuantizer
+}
+
+type QuantizeRGBA64Filter struct {
+	quantizeParams
+	OrderedDitherRGBA64Filter
+}
+
+type QuantizeRGBAFilter struct {
+	quantizeParams
+	OrderedDitherRGBAFilter
+}
+
+type QuantizeDiffusionRGBA64Filter struct {
+	quantizeParams
+	DitherRGBA64Filter
+}
+
+type QuantizeDiffusionRGBAFilter struct {
+	quantizeParams
+	DitherRGBAFilter
+}
+
+// parses the optional quantizer, color count and dither method in any order
+func parseQuantizeParams(args []string) (quantizeParams, string, error) {
+	params := quantizeParams{QUANTIZER_MEDIAN_CUT, QUANTIZE_DEFAULT_COLORS, nil}
+	dither := ""
+
+	for _, arg := range args {
+		if size, err := strconv.Atoi(arg); err == nil {
+			if size < 1 || size > 0x10000 {
+				return params, dither, errors.New("quantize color count needs to be between 1 and 65536")
+			}
+			params.size = size
+		} else if ValidateQuantizer(arg) == nil {
+			params.quantizerName = arg
+		} else if _, found := diffusionKernels[arg]; found || orderedDitherMatrix(arg) != nil {
+			dither = arg
+		} else {
+			return params, dither, errors.New("filter takes mediancut, octree or kmeans, the color count (int) and floyd, atkinson, jarvis, sierra, bayer or bluenoise as optional non-flag arguments, got " + arg)
+		}
+	}
+
+	return params, dither, nil
+}
+
+func newQuantizeRGBA64Filter(args []string) (interface{}, error) {
+	params, dither, err := parseQuantizeParams(args)
+	if err != nil {
+		return nil, err
+	}
+
+	if kernel, found := diffusionKernels[dither]; found {
+		filter := &QuantizeDiffusionRGBA64Filter{params, DitherRGBA64Filter{ditherParams{kernel: kernel}}}
+		filter.target = &filter.ditherQuantizer
+		return filter, nil
+	}
+
+	filter := &QuantizeRGBA64Filter{params, OrderedDitherRGBA64Filter{orderedDitherParams{matrix: orderedDitherMatrix(dither)}}}
+	filter.target = &filter.ditherQuantizer
+	return filter, nil
+}
+
+func newQuantizeRGBAFilter(args []string) (interface{}, error) {
+	params, dither, err := parseQuantizeParams(args)
+	if err != nil {
+		return nil, err
+	}
+
+	if kernel, found := diffusionKernels[dither]; found {
+		filter := &QuantizeDiffusionRGBAFilter{params, DitherRGBAFilter{ditherParams{kernel: kernel}}}
+		filter.target = &filter.ditherQuantizer
+		return filter, nil
+	}
+
+	filter := &QuantizeRGBAFilter{params, OrderedDitherRGBAFilter{orderedDitherParams{matrix: orderedDitherMatrix(dither)}}}
+	filter.target = &filter.ditherQuantizer
+	return filter, nil
+}
+
+// counts the non premultiplied 8 bit colors of the visible pixels in the rows startY to endY
+func (params *quantizeParams) analyze(img draw.RGBA64Image, startY, endY int) interface{} {
+	bnds := img.Bounds()
+	counts := map[[3]uint8]int{}
+
+	for y := startY; y < endY; y++ {
+		for x := bnds.Min.X; x < bnds.Max.X; x++ {
+			r, g, b, a := unpremultiply(img.RGBA64At(x, y))
+			if a == 0 {
+				continue
+			}
+			counts[[3]uint8{uint8(math.Round(r * 0xff)), uint8(math.Round(g * 0xff)), uint8(math.Round(b * 0xff))}]++
+		}
+	}
+
+	return counts
+}
+
+func (params *quantizeParams) Merge(a, b interface{}) interface{} {
+	countsA := a.(map[[3]uint8]int)
+	for clr, count := range b.(map[[3]uint8]int) {
+		countsA[clr] += count
+	}
+	return countsA
+}
+
+func (params *quantizeParams) SetStats(stats interface{}) {
+	palette := getQuantizer(params.quantizerName)(sortedColorCounts(stats.(map[[3]uint8]int)), params.size)
+
+	params.target.palette = [][3]float64{{0, 0, 0}}
+	if len(palette) > 0 {
+		params.target.palette = make([][3]float64, len(palette))
+		for i, c := range palette {
+			r, g, b, _ := c.RGBA()
+			params.target.palette[i] = [3]float64{float64(r) / 0xffff, float64(g) / 0xffff, float64(b) / 0xffff}
+		}
+	}
+}
+
+func (filter *QuantizeRGBA64Filter) Analyze(img *image.RGBA64, startY, endY int) interface{} {
+	return filter.analyze(img, startY, endY)
+}
+
+func (filter *QuantizeRGBAFilter) Analyze(img *image.RGBA, startY, endY int) interface{} {
+	return filter.analyze(img, startY, endY)
+}
+
+func (filter *QuantizeDiffusionRGBA64Filter) Analyze(img *image.RGBA64, startY, endY int) interface{} {
+	return filter.analyze(img, startY, endY)
+}
+
+func (filter *QuantizeDiffusionRGBAFilter) Analyze(img *image.RGBA, startY, endY int) interface{} {
+	return filter.analyze(img, startY, endY)
+}
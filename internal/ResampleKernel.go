@@ -0,0 +1,134 @@
+package internal
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+type ResampleKernel struct {
+	Name    string
+	Support float64
+	weight  func(x float64) float64
+}
+
+type resampleWeights struct {
+	start   int
+	weights []float64
+}
+
+var (
+	NearestKernel  = ResampleKernel{"nearest", 0.5, func(x float64) float64 { return 1 }}
+	BilinearKernel = ResampleKernel{"bilinear", 1, func(x float64) float64 { return math.Max(0, 1-math.Abs(x)) }}
+	BicubicKernel  = ResampleKernel{"bicubic", 2, catmullRom}
+	Lanczos3Kernel = ResampleKernel{"lanczos3", 3, lanczos3}
+	// averages all source pixels covered by the target pixel, the weights are computed from the pixel overlap
+	AreaKernel = ResampleKernel{"area", 0.5, nil}
+
+	resampleKernelsByName = map[string]ResampleKernel{
+		"nearest":  NearestKernel,
+		"bilinear": BilinearKernel,
+		"bicubic":  BicubicKernel,
+		"lanczos3": Lanczos3Kernel,
+		"area":     AreaKernel,
+	}
+)
+
+func GetResampleKernel(name string) (ResampleKernel, error) {
+	if kernel, found := resampleKernelsByName[name]; found {
+		return kernel, nil
+	}
+
+	return ResampleKernel{}, errors.New("unknown interpolation, available: nearest, bilinear, bicubic, lanczos3, area")
+}
+
+func catmullRom(x float64) float64 {
+	x = math.Abs(x)
+	if x < 1 {
+		return 1.5*x*x*x - 2.5*x*x + 1
+	} else if x < 2 {
+		return -0.5*x*x*x + 2.5*x*x - 4*x + 2
+	}
+	return 0
+}
+
+func sinc(x float64) float64 {
+	if x == 0 {
+		return 1
+	}
+	return math.Sin(math.Pi*x) / (math.Pi * x)
+}
+
+func lanczos3(x float64) float64 {
+	if math.Abs(x) < 3 {
+		return sinc(x) * sinc(x/3)
+	}
+	return 0
+}
+
+// computes the source pixel weights of every target pixel in one dimension,
+// scale is the source size of one target pixel and offset the source position of the first target pixel
+func computeResampleWeights(kernel ResampleKernel, srcSize, dstStart, dstEnd int, scale, offset float64) []resampleWeights {
+	weights := make([]resampleWeights, dstEnd-dstStart)
+	filterScale := math.Max(scale, 1)
+
+	for d := dstStart; d < dstEnd; d++ {
+		center := (float64(d)+0.5)*scale + offset
+		w := &weights[d-dstStart]
+
+		if kernel.Name == NearestKernel.Name {
+			w.start = clampInt(int(math.Floor(center)), 0, srcSize-1)
+			w.weights = []float64{1}
+			continue
+		}
+
+		support := kernel.Support * filterScale
+		first := int(math.Floor(center - support))
+		last := int(math.Ceil(center + support))
+
+		w.start = clampInt(first, 0, srcSize-1)
+		w.weights = make([]float64, clampInt(last, 0, srcSize-1)-w.start+1)
+
+		sum := 0.0
+		for i := first; i <= last; i++ {
+			var weight float64
+			if kernel.weight == nil {
+				weight = math.Max(0, math.Min(float64(i+1), center+filterScale/2)-math.Max(float64(i), center-filterScale/2))
+			} else {
+				weight = kernel.weight((float64(i) + 0.5 - center) / filterScale)
+			}
+
+			// pixels outside of the image are replaced by the nearest edge pixel
+			w.weights[clampInt(i, 0, srcSize-1)-w.start] += weight
+			sum += weight
+		}
+
+		if sum != 0 {
+			for i := range w.weights {
+				w.weights[i] /= sum
+			}
+		}
+	}
+
+	return weights
+}
+
+func clampInt(v, lo, hi int) int {
+	return max(lo, min(hi, v))
+}
+
+// stores premultiplied 16 bit channel values, clamping overshoots of negative kernel lobes
+func setResampledPixel(img draw.RGBA64Image, x, y int, r, g, b, a float64) {
+	a = math.Max(0, math.Min(0xffff, a))
+	r = math.Max(0, math.Min(a, r))
+	g = math.Max(0, math.Min(a, g))
+	b = math.Max(0, math.Min(a, b))
+
+	if rgba, ok := img.(*image.RGBA); ok {
+		rgba.SetRGBA(x, y, color.RGBA{uint8(math.Round(r / 0x101)), uint8(math.Round(g / 0x101)), uint8(math.Round(b / 0x101)), uint8(math.Round(a / 0x101))})
+	} else {
+		img.SetRGBA64(x, y, color.RGBA64{uint16(math.Round(r)), uint16(math.Round(g)), uint16(math.Round(b)), uint16(math.Round(a))})
+	}
+}
@@ -0,0 +1,174 @@
+package internal
+
+import (
+	"errors"
+	"image"
+	"image/draw"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	RESIZE_MODE_SIZE    = "size"
+	RESIZE_MODE_PERCENT = "percent"
+	RESIZE_MODE_FIT     = "fit"
+	RESIZE_MODE_FILL    = "fill"
+)
+
+type resizeParams struct {
+	mode          string
+	width, height int
+	percent       float64
+	kernel        ResampleKernel
+}
+
+type ResizeRGBA64Filter struct {
+	resizeParams
+}
+
+type ResizeRGBAFilter struct {
+	resizeParams
+}
+
+// parses [fit|fill] width height, width height (0 keeps the aspect ratio) or percentage%,
+// each optionally followed by the interpolation
+func parseResizeParams(args []string) (resizeParams, error) {
+	params := resizeParams{mode: RESIZE_MODE_SIZE, kernel: BicubicKernel}
+	usage := errors.New("filter needs width and height (int, int), a percentage (float%) or fit/fill followed by width and height, optionally followed by the interpolation (nearest, bilinear, bicubic, lanczos3, area)")
+
+	if len(args) >= 1 && (args[0] == RESIZE_MODE_FIT || args[0] == RESIZE_MODE_FILL) {
+		params.mode = args[0]
+		args = args[1:]
+	}
+
+	if len(args) >= 1 && strings.HasSuffix(args[0], "%") && params.mode == RESIZE_MODE_SIZE {
+		percent, err := strconv.ParseFloat(strings.TrimSuffix(args[0], "%"), 64)
+		if err != nil || percent <= 0 {
+			return params, usage
+		}
+		params.mode, params.percent = RESIZE_MODE_PERCENT, percent
+		args = args[1:]
+	} else {
+		if len(args) < 2 {
+			return params, usage
+		}
+
+		width, errW := strconv.Atoi(args[0])
+		height, errH := strconv.Atoi(args[1])
+		if errW != nil || errH != nil || width < 0 || height < 0 || (width == 0 && height == 0) {
+			return params, usage
+		}
+		if params.mode != RESIZE_MODE_SIZE && (width == 0 || height == 0) {
+			return params, errors.New("fit and fill need a width and height greater than 0")
+		}
+		params.width, params.height = width, height
+		args = args[2:]
+	}
+
+	if len(args) >= 1 {
+		kernel, err := GetResampleKernel(args[0])
+		if err != nil {
+			return params, err
+		}
+		params.kernel = kernel
+	}
+
+	return params, nil
+}
+
+func (params *resizeParams) OutputBounds(bnds image.Rectangle) image.Rectangle {
+	srcW, srcH := float64(bnds.Dx()), float64(bnds.Dy())
+	var width, height float64
+
+	switch params.mode {
+	case RESIZE_MODE_PERCENT:
+		width, height = srcW*params.percent/100, srcH*params.percent/100
+	case RESIZE_MODE_FIT:
+		scale := math.Min(float64(params.width)/srcW, float64(params.height)/srcH)
+		width, height = srcW*scale, srcH*scale
+	case RESIZE_MODE_FILL:
+		width, height = float64(params.width), float64(params.height)
+	default:
+		width, height = float64(params.width), float64(params.height)
+		if width == 0 {
+			width = srcW * height / srcH
+		} else if height == 0 {
+			height = srcH * width / srcW
+		}
+	}
+
+	return outputRect(math.Round(width), math.Round(height))
+}
+
+// returns the source offset and the source size of one target pixel, fill crops the centered source region
+func (params *resizeParams) sourceRegion(src, dst image.Rectangle) (offX, offY, scaleX, scaleY float64) {
+	srcW, srcH := float64(src.Dx()), float64(src.Dy())
+	dstW, dstH := float64(dst.Dx()), float64(dst.Dy())
+
+	if params.mode == RESIZE_MODE_FILL {
+		scale := math.Min(srcW/dstW, srcH/dstH)
+		return (srcW - dstW*scale) / 2, (srcH - dstH*scale) / 2, scale, scale
+	}
+
+	return 0, 0, srcW / dstW, srcH / dstH
+}
+
+func (filter *ResizeRGBA64Filter) Apply(img, filteredImg *image.RGBA64, startY, endY int, prgrsCh chan int) {
+	resizeRows(img, filteredImg, &filter.resizeParams, startY, endY, prgrsCh)
+}
+
+func (filter *ResizeRGBAFilter) Apply(img, filteredImg *image.RGBA, startY, endY int, prgrsCh chan int) {
+	resizeRows(img, filteredImg, &filter.resizeParams, startY, endY, prgrsCh)
+}
+
+// resamples the rows of the target band in two separable passes,
+// the horizontal pass only covers the source rows the band needs
+func resizeRows(img, filteredImg draw.RGBA64Image, params *resizeParams, startY, endY int, prgrsCh chan int) {
+	src, dst := img.Bounds(), filteredImg.Bounds()
+	offX, offY, scaleX, scaleY := params.sourceRegion(src, dst)
+
+	xWeights := computeResampleWeights(params.kernel, src.Dx(), 0, dst.Dx(), scaleX, offX)
+	yWeights := computeResampleWeights(params.kernel, src.Dy(), startY, endY, scaleY, offY)
+
+	firstRow, lastRow := yWeights[0].start, yWeights[0].start
+	for _, w := range yWeights {
+		firstRow = min(firstRow, w.start)
+		lastRow = max(lastRow, w.start+len(w.weights)-1)
+	}
+
+	rowLen := dst.Dx() * 4
+	horizontal := make([]float64, (lastRow-firstRow+1)*rowLen)
+
+	for sy := firstRow; sy <= lastRow; sy++ {
+		row := horizontal[(sy-firstRow)*rowLen:]
+		for dx, w := range xWeights {
+			var r, g, b, a float64
+			for i, weight := range w.weights {
+				c := img.RGBA64At(src.Min.X+w.start+i, src.Min.Y+sy)
+				r += float64(c.R) * weight
+				g += float64(c.G) * weight
+				b += float64(c.B) * weight
+				a += float64(c.A) * weight
+			}
+			row[dx*4], row[dx*4+1], row[dx*4+2], row[dx*4+3] = r, g, b, a
+		}
+	}
+
+	progress := newRowProgressReporter(startY, endY, prgrsCh)
+	for dy := startY; dy < endY; dy++ {
+		w := yWeights[dy-startY]
+		for dx := 0; dx < dst.Dx(); dx++ {
+			var r, g, b, a float64
+			for i, weight := range w.weights {
+				px := horizontal[(w.start+i-firstRow)*rowLen+dx*4:]
+				r += px[0] * weight
+				g += px[1] * weight
+				b += px[2] * weight
+				a += px[3] * weight
+			}
+			setResampledPixel(filteredImg, dst.Min.X+dx, dy, r, g, b, a)
+		}
+		progress.RowDone()
+	}
+}
@@ -0,0 +1,55 @@
+package internal
+
+import (
+	"image"
+	"image/color"
+)
+
+type TemporalAverageRGBA64Filter struct {
+	radius int
+}
+
+type TemporalAverageRGBAFilter struct {
+	radius int
+}
+
+func (filter *TemporalAverageRGBA64Filter) Radius() int {
+	return filter.radius
+}
+
+func (filter *TemporalAverageRGBAFilter) Radius() int {
+	return filter.radius
+}
+
+func (filter *TemporalAverageRGBA64Filter) Apply(window []*image.RGBA64, current int, previous, filteredImg *image.RGBA64, startY, endY int, prgrsCh chan int) {
+	if iter, err := NewImageIterator(window[current], NONE, startY, endY, prgrsCh); err == nil {
+		for iter.HasNext() {
+			curr := iter.Next()
+
+			r, g, b, a := temporalAverage(window, curr.X, curr.Y)
+			filteredImg.SetRGBA64(curr.X, curr.Y, color.RGBA64{uint16(r), uint16(g), uint16(b), uint16(a)})
+		}
+	}
+}
+
+func (filter *TemporalAverageRGBAFilter) Apply(window []*image.RGBA, current int, previous, filteredImg *image.RGBA, startY, endY int, prgrsCh chan int) {
+	if iter, err := NewImageIterator(window[current], NONE, startY, endY, prgrsCh); err == nil {
+		for iter.HasNext() {
+			curr := iter.Next()
+
+			r, g, b, a := temporalAverage(window, curr.X, curr.Y)
+			filteredImg.SetRGBA(curr.X, curr.Y, color.RGBA{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8), uint8(a >> 8)})
+		}
+	}
+}
+
+func temporalAverage[T image.Image](window []T, x, y int) (r, g, b, a uint32) {
+	var i uint32
+
+	for _, frame := range window {
+		c := frame.At(x, y)
+		addRGBAI(&r, &g, &b, &a, &i, &c)
+	}
+
+	return r / i, g / i, b / i, a / i
+}
@@ -0,0 +1,56 @@
+package internal
+
+import (
+	"image"
+	"image/color"
+)
+
+type TemporalEmaRGBA64Filter struct {
+	alpha float64
+}
+
+type TemporalEmaRGBAFilter struct {
+	alpha float64
+}
+
+func (filter *TemporalEmaRGBA64Filter) Radius() int {
+	return 0
+}
+
+func (filter *TemporalEmaRGBAFilter) Radius() int {
+	return 0
+}
+
+func (filter *TemporalEmaRGBA64Filter) Apply(window []*image.RGBA64, current int, previous, filteredImg *image.RGBA64, startY, endY int, prgrsCh chan int) {
+	if iter, err := NewImageIterator(window[current], NONE, startY, endY, prgrsCh); err == nil {
+		for iter.HasNext() {
+			curr := iter.Next()
+
+			r, g, b, a := emaBlend(*curr.Self, previous.At(curr.X, curr.Y), filter.alpha)
+			filteredImg.SetRGBA64(curr.X, curr.Y, color.RGBA64{uint16(r), uint16(g), uint16(b), uint16(a)})
+		}
+	}
+}
+
+func (filter *TemporalEmaRGBAFilter) Apply(window []*image.RGBA, current int, previous, filteredImg *image.RGBA, startY, endY int, prgrsCh chan int) {
+	if iter, err := NewImageIterator(window[current], NONE, startY, endY, prgrsCh); err == nil {
+		for iter.HasNext() {
+			curr := iter.Next()
+
+			r, g, b, a := emaBlend(*curr.Self, previous.At(curr.X, curr.Y), filter.alpha)
+			filteredImg.SetRGBA(curr.X, curr.Y, color.RGBA{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8), uint8(a >> 8)})
+		}
+	}
+}
+
+// exponential moving average, alpha weights the current frame against the accumulated previous output
+func emaBlend(c, prev color.Color, alpha float64) (r, g, b, a uint32) {
+	cr, cg, cb, ca := c.RGBA()
+	pr, pg, pb, pa := prev.RGBA()
+
+	blend := func(cv, pv uint32) uint32 {
+		return uint32(alpha*float64(cv) + (1-alpha)*float64(pv) + 0.5)
+	}
+
+	return blend(cr, pr), blend(cg, pg), blend(cb, pb), blend(ca, pa)
+}
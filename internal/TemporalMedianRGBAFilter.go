@@ -0,0 +1,70 @@
+package internal
+
+import (
+	"image"
+	"image/color"
+	"slices"
+)
+
+type TemporalMedianRGBA64Filter struct {
+	radius int
+}
+
+type TemporalMedianRGBAFilter struct {
+	radius int
+}
+
+func (filter *TemporalMedianRGBA64Filter) Radius() int {
+	return filter.radius
+}
+
+func (filter *TemporalMedianRGBAFilter) Radius() int {
+	return filter.radius
+}
+
+func (filter *TemporalMedianRGBA64Filter) Apply(window []*image.RGBA64, current int, previous, filteredImg *image.RGBA64, startY, endY int, prgrsCh chan int) {
+	if iter, err := NewImageIterator(window[current], NONE, startY, endY, prgrsCh); err == nil {
+		values := newChannelBuffers(len(window))
+
+		for iter.HasNext() {
+			curr := iter.Next()
+
+			r, g, b, a := temporalMedian(window, curr.X, curr.Y, values)
+			filteredImg.SetRGBA64(curr.X, curr.Y, color.RGBA64{uint16(r), uint16(g), uint16(b), uint16(a)})
+		}
+	}
+}
+
+func (filter *TemporalMedianRGBAFilter) Apply(window []*image.RGBA, current int, previous, filteredImg *image.RGBA, startY, endY int, prgrsCh chan int) {
+	if iter, err := NewImageIterator(window[current], NONE, startY, endY, prgrsCh); err == nil {
+		values := newChannelBuffers(len(window))
+
+		for iter.HasNext() {
+			curr := iter.Next()
+
+			r, g, b, a := temporalMedian(window, curr.X, curr.Y, values)
+			filteredImg.SetRGBA(curr.X, curr.Y, color.RGBA{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8), uint8(a >> 8)})
+		}
+	}
+}
+
+func newChannelBuffers(size int) [4][]uint32 {
+	var buffers [4][]uint32
+	for ch := range buffers {
+		buffers[ch] = make([]uint32, size)
+	}
+	return buffers
+}
+
+func temporalMedian[T image.Image](window []T, x, y int, values [4][]uint32) (r, g, b, a uint32) {
+	for i, frame := range window {
+		values[0][i], values[1][i], values[2][i], values[3][i] = frame.At(x, y).RGBA()
+	}
+
+	for ch := range values {
+		slices.Sort(values[ch])
+	}
+
+	mid := len(window) / 2
+	return values[0][mid], values[1][mid], values[2][mid], values[3][mid]
+}
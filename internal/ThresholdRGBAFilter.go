@@ -0,0 +1,285 @@
+package internal
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"strconv"
+)
+
+const (
+	THRESHOLD_FIXED    = "fixed"
+	THRESHOLD_OTSU     = "otsu"
+	THRESHOLD_MEAN     = "mean"
+	THRESHOLD_GAUSSIAN = "gaussian"
+	THRESHOLD_SAUVOLA  = "sauvola"
+	THRESHOLD_NIBLACK  = "niblack"
+
+	THRESHOLD_BINS = 256
+	// dynamic range of the standard deviation in sauvolas formula
+	SAUVOLA_RANGE float64 = 0.5
+)
+
+// turns pixels brighter than the threshold white and all others black,
+// the adaptive methods compare every pixel to the statistics of the window around it
+type thresholdParams struct {
+	method string
+	// fixed and otsu threshold
+	level  float64
+	window int
+	// offset subtracted from the local mean by mean and gaussian, weight of the standard deviation for sauvola and niblack
+	k float64
+}
+
+type ThresholdRGBA64Filter struct {
+	thresholdParams
+}
+
+type ThresholdRGBAFilter struct {
+	thresholdParams
+}
+
+var thresholdDefaultK = map[string]float64{
+	THRESHOLD_MEAN:     5,
+	THRESHOLD_GAUSSIAN: 5,
+	THRESHOLD_SAUVOLA:  0.2,
+	THRESHOLD_NIBLACK:  -0.2,
+}
+
+// parses level, otsu or mean/gaussian [window] [offset] and sauvola/niblack [window] [k]
+func parseThresholdParams(args []string) (thresholdParams, error) {
+	params := thresholdParams{THRESHOLD_FIXED, 0.5, 15, 0}
+	if len(args) == 0 {
+		return params, nil
+	}
+
+	if level, err := strconv.ParseFloat(args[0], 64); err == nil {
+		if math.IsNaN(level) || level < 0 || level > 0xff {
+			return params, errors.New("threshold level needs to be between 0 and 255")
+		}
+		params.level = level / 0xff
+		return params, nil
+	}
+
+	params.method = args[0]
+	if params.method == THRESHOLD_OTSU {
+		return params, nil
+	}
+
+	k, found := thresholdDefaultK[params.method]
+	if !found {
+		return params, errors.New("first non-flag argument needs to be a level (float between 0 and 255), otsu, mean, gaussian, sauvola or niblack")
+	}
+	params.k = k
+
+	if len(args) >= 2 {
+		window, err := strconv.Atoi(args[1])
+		if err != nil || window < 3 || window%2 == 0 {
+			return params, errors.New("threshold window size needs to be an odd int >= 3")
+		}
+		params.window = window
+	}
+	if len(args) >= 3 {
+		k, err := strconv.ParseFloat(args[2], 64)
+		if err != nil || math.IsNaN(k) || math.IsInf(k, 0) {
+			return params, errors.New("third non-flag argument needs to be the offset of mean/gaussian (float between 0 and 255) or k of sauvola/niblack (float)")
+		}
+		params.k = k
+	}
+	if params.method == THRESHOLD_MEAN || params.method == THRESHOLD_GAUSSIAN {
+		params.k /= 0xff
+	}
+
+	return params, nil
+}
+
+// intensity of the pixel normalized to [0, 1]
+func pixelIntensity(img draw.RGBA64Image, x, y int) float64 {
+	var c color.Color = img.RGBA64At(x, y)
+	return calcIntensity(&c, 8) / 0xff
+}
+
+// only otsu needs the histogram of the whole image
+func (params *thresholdParams) analyze(img draw.RGBA64Image, startY, endY int) interface{} {
+	if params.method != THRESHOLD_OTSU {
+		return nil
+	}
+
+	hist := make([]float64, THRESHOLD_BINS)
+	for y := startY; y < endY; y++ {
+		for x := img.Bounds().Min.X; x < img.Bounds().Max.X; x++ {
+			hist[luminanceBin(pixelIntensity(img, x, y), THRESHOLD_BINS)]++
+		}
+	}
+	return hist
+}
+
+func (params *thresholdParams) Merge(a, b interface{}) interface{} {
+	if a == nil {
+		return nil
+	}
+	return addHistograms(a.([]float64), b.([]float64))
+}
+
+// otsus method picks the level that maximizes the variance between the black and the white pixels
+func (params *thresholdParams) SetStats(stats interface{}) {
+	if stats == nil {
+		return
+	}
+	hist := stats.([]float64)
+
+	var total, sum float64
+	for i, count := range hist {
+		total += count
+		sum += float64(i) * count
+	}
+
+	var best, weightBlack, sumBlack float64
+	level := 0
+	for i, count := range hist[:len(hist)-1] {
+		weightBlack += count
+		sumBlack += float64(i) * count
+		weightWhite := total - weightBlack
+		if weightBlack == 0 || weightWhite == 0 {
+			continue
+		}
+
+		meanBlack, meanWhite := sumBlack/weightBlack, (sum-sumBlack)/weightWhite
+		if variance := weightBlack * weightWhite * (meanBlack - meanWhite) * (meanBlack - meanWhite); variance > best {
+			best, level = variance, i
+		}
+	}
+
+	params.level = (float64(level) + 0.5) / (THRESHOLD_BINS - 1)
+}
+
+// returns the intensities of the rows around the band, rows outside of the image repeat the edge rows
+func intensityRows(img draw.RGBA64Image, firstRow, lastRow int) [][]float64 {
+	bnds := img.Bounds()
+	rows := make([][]float64, lastRow-firstRow+1)
+	for y := range rows {
+		sy := clampInt(firstRow+y, bnds.Min.Y, bnds.Max.Y-1)
+		rows[y] = make([]float64, bnds.Dx())
+		for x := range rows[y] {
+			rows[y][x] = pixelIntensity(img, bnds.Min.X+x, sy)
+		}
+	}
+	return rows
+}
+
+// returns the local mean and standard deviation of every pixel of the band, box and gaussian windows are separable
+func (params *thresholdParams) localStats(img draw.RGBA64Image, startY, endY int) ([][]float64, [][]float64) {
+	radius := params.window / 2
+	rows := intensityRows(img, startY-radius, endY-1+radius)
+	width := img.Bounds().Dx()
+
+	weights := make([]float64, params.window)
+	for i := range weights {
+		if params.method == THRESHOLD_GAUSSIAN {
+			d := float64(i - radius)
+			sigma := float64(params.window) / 6
+			weights[i] = math.Exp(-d * d / (2 * sigma * sigma))
+		} else {
+			weights[i] = 1
+		}
+	}
+	var weightSum float64
+	for _, w := range weights {
+		weightSum += w
+	}
+
+	// horizontal pass over every row, followed by the vertical pass for the band rows
+	horizontal := make([][]float64, len(rows))
+	horizontalSq := make([][]float64, len(rows))
+	for y, row := range rows {
+		horizontal[y] = make([]float64, width)
+		horizontalSq[y] = make([]float64, width)
+		for x := range width {
+			var sum, sumSq float64
+			for i, w := range weights {
+				v := row[clampInt(x+i-radius, 0, width-1)]
+				sum += v * w
+				sumSq += v * v * w
+			}
+			horizontal[y][x], horizontalSq[y][x] = sum/weightSum, sumSq/weightSum
+		}
+	}
+
+	means := make([][]float64, endY-startY)
+	stdDevs := make([][]float64, endY-startY)
+	for y := range means {
+		means[y] = make([]float64, width)
+		stdDevs[y] = make([]float64, width)
+		for x := range width {
+			var mean, meanSq float64
+			for i, w := range weights {
+				mean += horizontal[y+i][x] * w
+				meanSq += horizontalSq[y+i][x] * w
+			}
+			mean, meanSq = mean/weightSum, meanSq/weightSum
+			means[y][x], stdDevs[y][x] = mean, math.Sqrt(math.Max(0, meanSq-mean*mean))
+		}
+	}
+
+	return means, stdDevs
+}
+
+func (params *thresholdParams) threshold(mean, stdDev float64) float64 {
+	switch params.method {
+	case THRESHOLD_SAUVOLA:
+		return mean * (1 + params.k*(stdDev/SAUVOLA_RANGE-1))
+	case THRESHOLD_NIBLACK:
+		return mean + params.k*stdDev
+	default:
+		return mean - params.k
+	}
+}
+
+func (params *thresholdParams) apply(img, filteredImg draw.RGBA64Image, startY, endY int, prgrsCh chan int) {
+	bnds := img.Bounds()
+	adaptive := params.method != THRESHOLD_FIXED && params.method != THRESHOLD_OTSU
+
+	var means, stdDevs [][]float64
+	if adaptive {
+		means, stdDevs = params.localStats(img, startY, endY)
+	}
+
+	progress := newRowProgressReporter(startY, endY, prgrsCh)
+	for y := startY; y < endY; y++ {
+		for x := bnds.Min.X; x < bnds.Max.X; x++ {
+			lum := pixelIntensity(img, x, y)
+
+			// every method compares strictly, only pixels brighter than the threshold turn white
+			white := lum > params.level
+			if adaptive {
+				white = lum > params.threshold(means[y-startY][x-bnds.Min.X], stdDevs[y-startY][x-bnds.Min.X])
+			}
+
+			a := img.RGBA64At(x, y).A
+			if white {
+				filteredImg.SetRGBA64(x, y, color.RGBA64{a, a, a, a})
+			} else {
+				filteredImg.SetRGBA64(x, y, color.RGBA64{0, 0, 0, a})
+			}
+		}
+		progress.RowDone()
+	}
+}
+
+func (filter *ThresholdRGBA64Filter) Analyze(img *image.RGBA64, startY, endY int) interface{} {
+	return filter.analyze(img, startY, endY)
+}
+
+func (filter *ThresholdRGBAFilter) Analyze(img *image.RGBA, startY, endY int) interface{} {
+	return filter.analyze(img, startY, endY)
+}
+
+func (filter *ThresholdRGBA64Filter) Apply(img, filteredImg *image.RGBA64, startY, endY int, prgrsCh chan int) {
+	filter.apply(img, filteredImg, startY, endY, prgrsCh)
+}
+
+func (filter *ThresholdRGBAFilter) Apply(img, filteredImg *image.RGBA, startY, endY int, prgrsCh chan int) {
+	filter.apply(img, filteredImg, startY, endY, prgrsCh)
+}
@@ -0,0 +1,273 @@
+package internal
+
+import (
+	"cmp"
+	"errors"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var toneChannelsByName = map[string][]int{
+	"rgb": {0, 1, 2},
+	"r":   {0},
+	"g":   {1},
+	"b":   {2},
+}
+
+// splits the arguments into groups starting with a channel selector (r, g, b or rgb), arguments before the
+// first selector apply to all channels, parse turns the arguments of a group into its channel curve
+func parseChannelGroups(args []string, parse func([]string) (func(float64) float64, error)) (ToneFunc, error) {
+	curves := [3]func(float64) float64{}
+	channels := toneChannelsByName["rgb"]
+	var group []string
+
+	flush := func() error {
+		if len(group) == 0 {
+			return nil
+		}
+		curve, err := parse(group)
+		if err != nil {
+			return err
+		}
+		for _, ch := range channels {
+			curves[ch] = curve
+		}
+		group = nil
+		return nil
+	}
+
+	for _, arg := range args {
+		if chs, found := toneChannelsByName[arg]; found {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+			channels = chs
+		} else {
+			group = append(group, arg)
+		}
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+
+	return func(ch int, v float64) float64 {
+		if curves[ch] == nil {
+			return v
+		}
+		return curves[ch](v)
+	}, nil
+}
+
+func parseFloatArgs(args []string) ([]float64, error) {
+	vals := make([]float64, len(args))
+	for i, arg := range args {
+		v, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			return nil, err
+		}
+		vals[i] = v
+	}
+	return vals, nil
+}
+
+// parses [r|g|b|rgb] blackIn whiteIn [gamma] [blackOut whiteOut] groups with values between 0 and 255
+func parseLevels(args []string) (ToneFunc, error) {
+	if len(args) == 0 {
+		return nil, errors.New("filter needs black and white input points (float, float) between 0 and 255, optionally followed by gamma (float) and black and white output points, prefixed by r, g, b or rgb for single channels")
+	}
+
+	return parseChannelGroups(args, func(group []string) (func(float64) float64, error) {
+		vals, err := parseFloatArgs(group)
+		if err != nil || (len(vals) != 2 && len(vals) != 3 && len(vals) != 5) {
+			return nil, errors.New("levels need black, white, optionally gamma and black and white output points per channel group")
+		}
+
+		black, white, gamma, outBlack, outWhite := vals[0]/0xff, vals[1]/0xff, 1.0, 0.0, 1.0
+		if len(vals) >= 3 {
+			gamma = vals[2]
+		}
+		if len(vals) == 5 {
+			outBlack, outWhite = vals[3]/0xff, vals[4]/0xff
+		}
+		if white <= black || gamma <= 0 {
+			return nil, errors.New("levels need a white point greater than the black point and a gamma greater than 0")
+		}
+
+		return func(v float64) float64 {
+			return outBlack + math.Pow(clampUnit((v-black)/(white-black)), 1/gamma)*(outWhite-outBlack)
+		}, nil
+	})
+}
+
+func parseSingleToneArg(args []string, usage string) (float64, error) {
+	if len(args) < 1 {
+		return 0, errors.New(usage)
+	}
+	v, err := strconv.ParseFloat(args[0], 64)
+	if err != nil {
+		return 0, errors.New(usage)
+	}
+	return v, nil
+}
+
+// shifts all channel values by amount percent of the value range
+func parseBrightness(args []string) (ToneFunc, error) {
+	amount, err := parseSingleToneArg(args, "filter needs the brightness change in percent (float between -100 and 100) as non-flag argument")
+	if err != nil {
+		return nil, err
+	}
+
+	return func(ch int, v float64) float64 {
+		return v + amount/100
+	}, nil
+}
+
+// scales the distance of the channel values to the middle gray by 1 + amount/100
+func parseContrast(args []string) (ToneFunc, error) {
+	amount, err := parseSingleToneArg(args, "filter needs the contrast change in percent (float >= -100) as non-flag argument")
+	if err != nil {
+		return nil, err
+	}
+	if amount < -100 {
+		return nil, errors.New("contrast change needs to be at least -100")
+	}
+
+	return func(ch int, v float64) float64 {
+		return (v-0.5)*(1+amount/100) + 0.5
+	}, nil
+}
+
+// multiplies the linear light intensity by 2^stops
+func parseExposure(args []string) (ToneFunc, error) {
+	stops, err := parseSingleToneArg(args, "filter needs the exposure change in stops (float) as non-flag argument")
+	if err != nil {
+		return nil, err
+	}
+	factor := math.Pow(2, stops)
+
+	return func(ch int, v float64) float64 {
+		return srgbCurve.fromLinear(clampUnit(srgbCurve.toLinear(v) * factor))
+	}, nil
+}
+
+func parseGamma(args []string) (ToneFunc, error) {
+	gamma, err := parseSingleToneArg(args, "filter needs the gamma (float > 0) as non-flag argument")
+	if err != nil {
+		return nil, err
+	}
+	if gamma <= 0 {
+		return nil, errors.New("gamma needs to be greater than 0")
+	}
+
+	return func(ch int, v float64) float64 {
+		return math.Pow(v, 1/gamma)
+	}, nil
+}
+
+// parses [r|g|b|rgb] x,y ... groups of control points between 0 and 255, an argument naming a file
+// is replaced by its contents, one x,y or x y point or channel selector per line, # starts a comment
+func parseCurves(args []string) (ToneFunc, error) {
+	var expanded []string
+	for _, arg := range args {
+		if _, found := toneChannelsByName[arg]; found || strings.Contains(arg, ",") {
+			expanded = append(expanded, arg)
+			continue
+		}
+
+		data, err := os.ReadFile(arg)
+		if err != nil {
+			return nil, errors.New("curves arguments need to be x,y control points, r, g, b, rgb or a control point file, got " + arg)
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			line, _, _ = strings.Cut(line, "#")
+			if fields := strings.Fields(strings.ReplaceAll(line, ",", " ")); len(fields) > 0 {
+				expanded = append(expanded, strings.Join(fields, ","))
+			}
+		}
+	}
+
+	if len(expanded) == 0 {
+		return nil, errors.New("filter needs x,y control points between 0 and 255 or a control point file, prefixed by r, g, b or rgb for single channels")
+	}
+
+	return parseChannelGroups(expanded, func(group []string) (func(float64) float64, error) {
+		points := make([][2]float64, 0, len(group))
+		for _, arg := range group {
+			xs, ys, _ := strings.Cut(arg, ",")
+			x, errX := strconv.ParseFloat(xs, 64)
+			y, errY := strconv.ParseFloat(ys, 64)
+			if errX != nil || errY != nil {
+				return nil, errors.New("curves control points need to be x,y (float, float), got " + arg)
+			}
+			points = append(points, [2]float64{x / 0xff, y / 0xff})
+		}
+
+		return newMonotoneSpline(points)
+	})
+}
+
+// interpolates the control points with a monotone cubic hermite spline (fritsch-carlson),
+// which doesn't overshoot between the points, values outside of the points keep the end values
+func newMonotoneSpline(points [][2]float64) (func(float64) float64, error) {
+	if len(points) < 2 {
+		return nil, errors.New("curves need at least 2 control points per channel group")
+	}
+
+	slices.SortFunc(points, func(a, b [2]float64) int {
+		return cmp.Compare(a[0], b[0])
+	})
+
+	n := len(points)
+	secants := make([]float64, n-1)
+	for i := range n - 1 {
+		dx := points[i+1][0] - points[i][0]
+		if dx <= 0 {
+			return nil, errors.New("curves control points need distinct x values")
+		}
+		secants[i] = (points[i+1][1] - points[i][1]) / dx
+	}
+
+	tangents := make([]float64, n)
+	tangents[0], tangents[n-1] = secants[0], secants[n-2]
+	for i := 1; i < n-1; i++ {
+		if secants[i-1]*secants[i] > 0 {
+			tangents[i] = (secants[i-1] + secants[i]) / 2
+		}
+	}
+
+	for i := range n - 1 {
+		if secants[i] == 0 {
+			tangents[i], tangents[i+1] = 0, 0
+			continue
+		}
+
+		alpha, beta := tangents[i]/secants[i], tangents[i+1]/secants[i]
+		if s := alpha*alpha + beta*beta; s > 9 {
+			tau := 3 / math.Sqrt(s)
+			tangents[i], tangents[i+1] = tau*alpha*secants[i], tau*beta*secants[i]
+		}
+	}
+
+	return func(v float64) float64 {
+		if v <= points[0][0] {
+			return points[0][1]
+		} else if v >= points[n-1][0] {
+			return points[n-1][1]
+		}
+
+		i, _ := slices.BinarySearchFunc(points, v, func(p [2]float64, v float64) int {
+			return cmp.Compare(p[0], v)
+		})
+		i = max(0, i-1)
+
+		h := points[i+1][0] - points[i][0]
+		t := (v - points[i][0]) / h
+		t2, t3 := t*t, t*t*t
+
+		return (2*t3-3*t2+1)*points[i][1] + (t3-2*t2+t)*h*tangents[i] +
+			(-2*t3+3*t2)*points[i+1][1] + (t3-t2)*h*tangents[i+1]
+	}, nil
+}
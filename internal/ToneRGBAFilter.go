@@ -0,0 +1,105 @@
+package internal
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// maps a normalized, non premultiplied channel value of channel ch (0 red, 1 green, 2 blue) to its adjusted value
+type ToneFunc func(ch int, v float64) float64
+
+// per-pixel tone adjustment looking up every channel value in a table built from fn
+type ToneRGBA64Filter struct {
+	fn  ToneFunc
+	lut [3][]uint16
+}
+
+type ToneRGBAFilter struct {
+	fn  ToneFunc
+	lut [3][]uint8
+}
+
+func NewToneRGBA64Filter(fn ToneFunc) *ToneRGBA64Filter {
+	filter := &ToneRGBA64Filter{fn: fn}
+	for ch := range 3 {
+		filter.lut[ch] = make([]uint16, 0x10000)
+		for v := range 0x10000 {
+			filter.lut[ch][v] = uint16(math.Round(clampUnit(fn(ch, float64(v)/0xffff)) * 0xffff))
+		}
+	}
+	return filter
+}
+
+func NewToneRGBAFilter(fn ToneFunc) *ToneRGBAFilter {
+	filter := &ToneRGBAFilter{fn: fn}
+	for ch := range 3 {
+		filter.lut[ch] = make([]uint8, 0x100)
+		for v := range 0x100 {
+			filter.lut[ch][v] = uint8(math.Round(clampUnit(fn(ch, float64(v)/0xff)) * 0xff))
+		}
+	}
+	return filter
+}
+
+func clampUnit(v float64) float64 {
+	return math.Max(0, math.Min(1, v))
+}
+
+func (filter *ToneRGBA64Filter) Apply(img, filteredImg *image.RGBA64, startY, endY int, prgrsCh chan int) {
+	if iter, err := NewImageIterator(img, NONE, startY, endY, prgrsCh); err == nil {
+		for iter.HasNext() {
+			curr := iter.Next()
+
+			var r, g, b, a uint32 = (*curr.Self).RGBA()
+			filteredImg.SetRGBA64(curr.X, curr.Y, color.RGBA64{
+				uint16(lookupPremultiplied(filter.lut[0], r, a, 0xffff)),
+				uint16(lookupPremultiplied(filter.lut[1], g, a, 0xffff)),
+				uint16(lookupPremultiplied(filter.lut[2], b, a, 0xffff)),
+				uint16(a),
+			})
+		}
+	}
+}
+
+func (filter *ToneRGBAFilter) Apply(img, filteredImg *image.RGBA, startY, endY int, prgrsCh chan int) {
+	if iter, err := NewImageIterator(img, NONE, startY, endY, prgrsCh); err == nil {
+		for iter.HasNext() {
+			curr := iter.Next()
+
+			var r, g, b, a uint32 = (*curr.Self).RGBA()
+			r, g, b, a = r>>8, g>>8, b>>8, a>>8
+			filteredImg.SetRGBA(curr.X, curr.Y, color.RGBA{
+				uint8(lookupPremultiplied(filter.lut[0], r, a, 0xff)),
+				uint8(lookupPremultiplied(filter.lut[1], g, a, 0xff)),
+				uint8(lookupPremultiplied(filter.lut[2], b, a, 0xff)),
+				uint8(a),
+			})
+		}
+	}
+}
+
+// looks up the premultiplied value v, translucent pixels are unpremultiplied for the lookup
+func lookupPremultiplied[V uint8 | uint16](lut []V, v, a, cMax uint32) uint32 {
+	if a == cMax {
+		return uint32(lut[v])
+	} else if a == 0 {
+		return 0
+	}
+
+	return (uint32(lut[min(cMax, (v*cMax+a/2)/a)])*a + cMax/2) / cMax
+}
+
+func toneColorFunc(fn ToneFunc) ColorFunc {
+	return func(r, g, b float64) (float64, float64, float64) {
+		return clampUnit(fn(0, r)), clampUnit(fn(1, g)), clampUnit(fn(2, b))
+	}
+}
+
+func (filter *ToneRGBA64Filter) PixelFunc() ColorFunc {
+	return toneColorFunc(filter.fn)
+}
+
+func (filter *ToneRGBAFilter) PixelFunc() ColorFunc {
+	return toneColorFunc(filter.fn)
+}
@@ -0,0 +1,176 @@
+package internal
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"math"
+	"strconv"
+)
+
+const (
+	FALLOFF_LINEAR     = "linear"
+	FALLOFF_SMOOTHSTEP = "smoothstep"
+	FALLOFF_GAUSSIAN   = "gaussian"
+
+	// steepness of the gaussian falloff, the curve reaches 99% at the outer edge of the feather
+	GAUSSIAN_FALLOFF_STEEPNESS float64 = 4.5
+)
+
+type vignetteParams struct {
+	// a center radius of 0 uses the image center and an ellipse through the image corners
+	centerX, centerY, radiusX, radiusY float64
+	// share of the radius the vignette fades in over, 0 gives a hard edge
+	feather  float64
+	falloff  string
+	strength float64
+	// non premultiplied color the image fades to
+	r, g, b float64
+	invert  bool
+}
+
+type VignetteRGBA64Filter struct {
+	vignetteParams
+}
+
+type VignetteRGBAFilter struct {
+	vignetteParams
+}
+
+var defaultVignette = vignetteParams{0, 0, 0, 0, 0.6, FALLOFF_SMOOTHSTEP, 0.6, 0, 0, 0, false}
+
+// the spot preset keeps the pixels at the center and fades them linearly to white at the radius,
+// everything outside of the radius turns white
+func newSpotVignette(spotX, spotY int, spotR float64) vignetteParams {
+	return vignetteParams{float64(spotX), float64(spotY), spotR, spotR, 1, FALLOFF_LINEAR, 1, 0xffff, 0xffff, 0xffff, false}
+}
+
+// parses [strength] [feather] [centerX centerY radiusX radiusY] [falloff] [color] [invert],
+// numbers are positional while the falloff, color and invert may follow in any order
+func parseVignetteParams(args []string) (vignetteParams, error) {
+	params := defaultVignette
+
+	var nums []float64
+	for _, arg := range args {
+		if v, err := strconv.ParseFloat(arg, 64); err == nil {
+			nums = append(nums, v)
+		} else if arg == FALLOFF_LINEAR || arg == FALLOFF_SMOOTHSTEP || arg == FALLOFF_GAUSSIAN {
+			params.falloff = arg
+		} else if arg == "invert" {
+			params.invert = true
+		} else if c, err := parseColor(arg); err == nil {
+			nc := color.NRGBA64Model.Convert(c).(color.NRGBA64)
+			params.r, params.g, params.b = float64(nc.R), float64(nc.G), float64(nc.B)
+		} else {
+			return params, errors.New("filter takes the strength, feather (float, float), the center and radii (4 floats), linear/smoothstep/gaussian, a color and invert as optional non-flag arguments, got " + arg)
+		}
+	}
+
+	switch len(nums) {
+	case 6:
+		params.centerX, params.centerY, params.radiusX, params.radiusY = nums[2], nums[3], nums[4], nums[5]
+		if params.radiusX <= 0 || params.radiusY <= 0 {
+			return params, errors.New("vignette radii need to be greater than 0")
+		}
+		fallthrough
+	case 2:
+		params.feather = nums[1]
+		fallthrough
+	case 1:
+		params.strength = nums[0]
+	case 0:
+	default:
+		return params, errors.New("vignette takes 1 (strength), 2 (strength, feather) or 6 (strength, feather, centerX, centerY, radiusX, radiusY) numbers")
+	}
+
+	if params.feather < 0 || params.feather > 1 {
+		return params, errors.New("vignette feather needs to be between 0 and 1")
+	}
+
+	return params, nil
+}
+
+// returns the center and radii for the image, the default ellipse touches the image corners
+func (params *vignetteParams) ellipse(bnds image.Rectangle) (cx, cy, rx, ry float64) {
+	if params.radiusX == 0 {
+		return float64(bnds.Min.X+bnds.Max.X) / 2, float64(bnds.Min.Y+bnds.Max.Y) / 2,
+			float64(bnds.Dx()) / math.Sqrt2, float64(bnds.Dy()) / math.Sqrt2
+	}
+	return params.centerX, params.centerY, params.radiusX, params.radiusY
+}
+
+// returns how much of the vignette color covers the pixel at the normalized elliptical distance d
+func (params *vignetteParams) amount(d float64) float64 {
+	var t float64
+	if params.feather == 0 {
+		if d >= 1 {
+			t = 1
+		}
+	} else {
+		t = math.Max(0, math.Min(1, (d-(1-params.feather))/params.feather))
+	}
+
+	switch params.falloff {
+	case FALLOFF_SMOOTHSTEP:
+		t = t * t * (3 - 2*t)
+	case FALLOFF_GAUSSIAN:
+		t = (1 - math.Exp(-GAUSSIAN_FALLOFF_STEEPNESS*t*t)) / (1 - math.Exp(-GAUSSIAN_FALLOFF_STEEPNESS))
+	}
+
+	if params.invert {
+		t = 1 - t
+	}
+
+	return t * params.strength
+}
+
+// blends the premultiplied 16 bit channels towards the vignette color, keeping the alpha
+func (params *vignetteParams) blend(x, y int, cx, cy, rx, ry float64, r, g, b, a uint32) (float64, float64, float64) {
+	dx, dy := (float64(x)-cx)/rx, (float64(y)-cy)/ry
+	amt := params.amount(math.Sqrt(dx*dx + dy*dy))
+	alpha := float64(a) / 0xffff
+
+	return float64(r)*(1-amt) + params.r*alpha*amt,
+		float64(g)*(1-amt) + params.g*alpha*amt,
+		float64(b)*(1-amt) + params.b*alpha*amt
+}
+
+func (filter *VignetteRGBA64Filter) Apply(img, filteredImg *image.RGBA64, startY, endY int, prgrsCh chan int) {
+	cx, cy, rx, ry := filter.ellipse(img.Bounds())
+
+	if iter, err := NewImageIterator(img, NONE, startY, endY, prgrsCh); err == nil {
+		for iter.HasNext() {
+			curr := iter.Next()
+
+			var r, g, b, a uint32 = (*curr.Self).RGBA()
+			fr, fg, fb := filter.blend(curr.X, curr.Y, cx, cy, rx, ry, r, g, b, a)
+
+			filteredImg.SetRGBA64(curr.X, curr.Y, color.RGBA64{
+				uint16(math.Round(fr)),
+				uint16(math.Round(fg)),
+				uint16(math.Round(fb)),
+				uint16(a),
+			})
+		}
+	}
+}
+
+func (filter *VignetteRGBAFilter) Apply(img, filteredImg *image.RGBA, startY, endY int, prgrsCh chan int) {
+	cx, cy, rx, ry := filter.ellipse(img.Bounds())
+
+	if iter, err := NewImageIterator(img, NONE, startY, endY, prgrsCh); err == nil {
+		for iter.HasNext() {
+			curr := iter.Next()
+
+			var r, g, b, a uint32 = (*curr.Self).RGBA()
+			fr, fg, fb := filter.blend(curr.X, curr.Y, cx, cy, rx, ry, r, g, b, a)
+
+			filteredImg.SetRGBA(curr.X, curr.Y, color.RGBA{
+				uint8(math.Round(fr / 0x101)),
+				uint8(math.Round(fg / 0x101)),
+				uint8(math.Round(fb / 0x101)),
+				uint8(a >> 8),
+			})
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package internal
+
+import (
+	"errors"
+	"image"
+	"image/draw"
+	"math"
+	"strconv"
+)
+
+const (
+	WHITE_BALANCE_GRAY_WORLD = "grayworld"
+	WHITE_BALANCE_WHITE      = "whitepatch"
+	WHITE_BALANCE_PERCENTILE = "percentile"
+
+	WHITE_BALANCE_BINS = 1024
+	// keeps nearly empty channels from blowing up
+	WHITE_BALANCE_MAX_GAIN = 8.0
+
+	// reference color temperature, which keeps the image unchanged
+	TEMPERATURE_NEUTRAL = 6500.0
+	// shift of the white point in the cie 1960 uv plane at a tint of 100
+	TEMPERATURE_TINT_SCALE = 0.02
+)
+
+// scales the linear channels so that the estimated illuminant turns neutral, gray world scales the channel means
+// to their luminance, white patch and percentile scale the brightest or the percentile channel values to white
+type whiteBalanceParams struct {
+	method     string
+	percentile float64
+	conv       *colorConverter
+}
+
+type whiteBalanceStats struct {
+	hists [3][]float64
+	sums  [3]float64
+	count float64
+}
+
+type WhiteBalanceRGBA64Filter struct {
+	whiteBalanceParams
+	tone *ToneRGBA64Filter
+}
+
+type WhiteBalanceRGBAFilter struct {
+	whiteBalanceParams
+	tone *ToneRGBAFilter
+}
+
+// parses [grayworld|whitepatch|percentile [p]], gray world by default and the 99th percentile
+func parseWhiteBalanceParams(args []string) (whiteBalanceParams, error) {
+	params := whiteBalanceParams{WHITE_BALANCE_GRAY_WORLD, 99, newColorConverter(nil)}
+
+	if len(args) >= 1 {
+		params.method = args[0]
+	}
+
+	switch params.method {
+	case WHITE_BALANCE_GRAY_WORLD:
+	case WHITE_BALANCE_WHITE:
+		params.percentile = 100
+	case WHITE_BALANCE_PERCENTILE:
+		if len(args) >= 2 {
+			p, err := strconv.ParseFloat(args[1], 64)
+			if err != nil || p <= 0 || p > 100 {
+				return params, errors.New("percentile needs to be a float between 0 and 100")
+			}
+			params.percentile = p
+		}
+	default:
+		return params, errors.New("first non-flag argument needs to be grayworld, whitepatch or percentile")
+	}
+
+	return params, nil
+}
+
+// sums the linear channel values and counts the channel values of the visible pixels in the rows startY to endY
+func (params *whiteBalanceParams) analyze(img draw.RGBA64Image, startY, endY int) interface{} {
+	bnds := img.Bounds()
+	stats := &whiteBalanceStats{}
+	for ch := range stats.hists {
+		stats.hists[ch] = make([]float64, WHITE_BALANCE_BINS)
+	}
+
+	for y := startY; y < endY; y++ {
+		for x := bnds.Min.X; x < bnds.Max.X; x++ {
+			r, g, b, a := unpremultiply(img.RGBA64At(x, y))
+			if a == 0 {
+				continue
+			}
+			for ch, v := range [3]float64{r, g, b} {
+				stats.hists[ch][luminanceBin(v, WHITE_BALANCE_BINS)]++
+				stats.sums[ch] += params.conv.trc[ch].toLinear(v)
+			}
+			stats.count++
+		}
+	}
+
+	return stats
+}
+
+func (params *whiteBalanceParams) Merge(a, b interface{}) interface{} {
+	statsA, statsB := a.(*whiteBalanceStats), b.(*whiteBalanceStats)
+	for ch := range statsA.hists {
+		addHistograms(statsA.hists[ch], statsB.hists[ch])
+		statsA.sums[ch] += statsB.sums[ch]
+	}
+	statsA.count += statsB.count
+	return statsA
+}
+
+// linear value below which the given percent of the channel values lie
+func histogramPercentile(hist []float64, total, percentile float64, curve *toneCurve) float64 {
+	limit := total * percentile / 100
+	var acc float64
+	for bin, count := range hist {
+		acc += count
+		if acc >= limit && count > 0 {
+			return curve.toLinear(float64(bin) / float64(len(hist)-1))
+		}
+	}
+	return 1
+}
+
+// returns the tone function scaling the linear channels by the gains of the estimated illuminant
+func (params *whiteBalanceParams) toneFunc(stats interface{}) ToneFunc {
+	wbStats := stats.(*whiteBalanceStats)
+	gains := [3]float64{1, 1, 1}
+
+	if wbStats.count > 0 {
+		var ref [3]float64
+		for ch := range ref {
+			if params.method == WHITE_BALANCE_GRAY_WORLD {
+				ref[ch] = wbStats.sums[ch] / wbStats.count
+			} else {
+				ref[ch] = histogramPercentile(wbStats.hists[ch], wbStats.count, params.percentile, &params.conv.trc[ch])
+			}
+		}
+
+		target := 1.0
+		if params.method == WHITE_BALANCE_GRAY_WORLD {
+			target = params.conv.luminance(ref)
+		}
+		for ch := range gains {
+			if ref[ch] > 0 {
+				gains[ch] = math.Min(target/ref[ch], WHITE_BALANCE_MAX_GAIN)
+			}
+		}
+	}
+
+	curves := params.conv.trc
+	return func(ch int, v float64) float64 {
+		return curves[ch].fromLinear(clampUnit(curves[ch].toLinear(v) * gains[ch]))
+	}
+}
+
+func (params *whiteBalanceParams) SetWorkingSpace(profile *ColorProfile) {
+	params.conv = newColorConverter(profile)
+}
+
+func (filter *WhiteBalanceRGBA64Filter) Analyze(img *image.RGBA64, startY, endY int) interface{} {
+	return filter.analyze(img, startY, endY)
+}
+
+func (filter *WhiteBalanceRGBAFilter) Analyze(img *image.RGBA, startY, endY int) interface{} {
+	return filter.analyze(img, startY, endY)
+}
+
+func (filter *WhiteBalanceRGBA64Filter) SetStats(stats interface{}) {
+	filter.tone = NewToneRGBA64Filter(filter.toneFunc(stats))
+}
+
+func (filter *WhiteBalanceRGBAFilter) SetStats(stats interface{}) {
+	filter.tone = NewToneRGBAFilter(filter.toneFunc(stats))
+}
+
+func (filter *WhiteBalanceRGBA64Filter) Apply(img, filteredImg *image.RGBA64, startY, endY int, prgrsCh chan int) {
+	filter.tone.Apply(img, filteredImg, startY, endY, prgrsCh)
+}
+
+func (filter *WhiteBalanceRGBAFilter) Apply(img, filteredImg *image.RGBA, startY, endY int, prgrsCh chan int) {
+	filter.tone.Apply(img, filteredImg, startY, endY, prgrsCh)
+}
+
+// chromaticity of a black body at kelvin between 1667 and 25000, cubic spline approximation by kim et al.
+func planckianXY(kelvin float64) (x, y float64) {
+	t := kelvin
+	if t <= 4000 {
+		x = -0.2661239e9/(t*t*t) - 0.2343589e6/(t*t) + 0.8776956e3/t + 0.179910
+	} else {
+		x = -3.0258469e9/(t*t*t) + 2.1070379e6/(t*t) + 0.2226347e3/t + 0.240390
+	}
+
+	switch {
+	case t <= 2222:
+		y = -1.1063814*x*x*x - 1.34811020*x*x + 2.18555832*x - 0.20219683
+	case t <= 4000:
+		y = -0.9549476*x*x*x - 1.37418593*x*x + 2.09137015*x - 0.16748867
+	default:
+		y = 3.0817580*x*x*x - 5.87338670*x*x + 3.75112997*x - 0.37001483
+	}
+	return x, y
+}
+
+// xyz with y = 1 of the black body at kelvin, whose white point is shifted towards green by tint in the uv plane
+func illuminantXYZ(kelvin, tint float64) [3]float64 {
+	x, y := planckianXY(kelvin)
+
+	d := -2*x + 12*y + 3
+	u, v := 4*x/d, 6*y/d+tint*TEMPERATURE_TINT_SCALE
+	d = 2*u - 8*v + 4
+	x, y = 3*u/d, 2*v/d
+
+	return [3]float64{x / y, 1, (1 - x - y) / y}
+}
+
+// parses the color temperature of the light in kelvin and an optional tint between -100 and 100, the image is adapted
+// from this light to 6500 kelvin, so lower temperatures cool and higher ones warm the image, positive tints turn it magenta
+func parseTemperature(args []string) (SpaceColorFunc, error) {
+	kelvin, err := parseSingleToneArg(args, "filter needs the color temperature in kelvin (float between 1667 and 25000) as non-flag argument, optionally followed by the tint (float between -100 and 100)")
+	if err != nil {
+		return nil, err
+	}
+	if kelvin < 1667 || kelvin > 25000 {
+		return nil, errors.New("color temperature needs to be between 1667 and 25000 kelvin")
+	}
+
+	var tint float64
+	if len(args) >= 2 {
+		if tint, err = strconv.ParseFloat(args[1], 64); err != nil || tint < -100 || tint > 100 {
+			return nil, errors.New("tint needs to be a float between -100 and 100")
+		}
+	}
+
+	adaptation := chromaticAdaptation(illuminantXYZ(kelvin, tint/100), illuminantXYZ(TEMPERATURE_NEUTRAL, 0))
+
+	// adapts the linear values of the working space with the bradford transform
+	return func(conv *colorConverter) ColorFunc {
+		rgbToXYZ := conv.toXYZD65()
+		matrix := rgbToXYZ.inverse().mul(adaptation.mul(rgbToXYZ))
+
+		return func(r, g, b float64) (float64, float64, float64) {
+			return conv.fromLinear(matrix.apply(conv.toLinear(r, g, b)))
+		}
+	}, nil
+}